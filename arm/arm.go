@@ -45,6 +45,9 @@ type CPU struct {
 	virtualization   bool
 	genericTimer     bool
 
+	// VFP/NEON enabled
+	vfp bool
+
 	// timer multiplier
 	TimerMultiplier int64
 	// timer function
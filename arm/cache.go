@@ -21,6 +21,8 @@ func cache_enable()
 func cache_disable()
 func cache_flush_data()
 func cache_flush_instruction()
+func mmu_cache_disable()
+func tlb_bp_invalidate()
 
 // EnableSMP sets the SMP bit in Cortex-A7 Auxiliary Control Register, to
 // enable coherent requests to the processor. This must be ensured before
@@ -51,3 +53,20 @@ func (cpu *CPU) CacheFlushData() {
 func (cpu *CPU) CacheFlushInstruction() {
 	cache_flush_instruction()
 }
+
+// PrepareForHandoff cleans and disables the ARM caches and MMU, and invalidates
+// TLBs and branch predictor, to safely jump to another image (e.g. chainloaded
+// OS) or reboot. The memory must be identity mapped (as in TamaGo default
+// configuration) as the MMU is disabled while executing.
+func (cpu *CPU) PrepareForHandoff() {
+	// clean and invalidate data cache while still enabled
+	cache_flush_data()
+	// disable caches and MMU
+	mmu_cache_disable()
+	// push out any dirty data written since the first flush
+	cache_flush_data()
+	// invalidate instruction cache
+	cache_flush_instruction()
+	// invalidate TLBs and branch predictor
+	tlb_bp_invalidate()
+}
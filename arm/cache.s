@@ -104,3 +104,22 @@ TEXT ·cache_flush_instruction(SB),$0
 	MOVW	$0, R0
 	MCR	15, 0, R0, C7, C5, 0
 	RET
+
+// func mmu_cache_disable()
+TEXT ·mmu_cache_disable(SB),$0
+	MRC	15, 0, R1, C1, C0, 0
+	BIC	$0x1000, R1	// Disable I-cache
+	BIC	$0x4, R1	// Disable D-cache
+	BIC	$0x1, R1	// Disable MMU
+	MCR	15, 0, R1, C1, C0, 0
+	WORD	$0xf57ff06f	// ISB SY
+	RET
+
+// func tlb_bp_invalidate()
+TEXT ·tlb_bp_invalidate(SB),$0
+	MOVW	$0, R0
+	MCR	15, 0, R0, C8, C7, 0	// TLBIALL - invalidate unified TLB
+	MCR	15, 0, R0, C7, C5, 6	// BPIALL - invalidate branch predictor
+	WORD	$0xf57ff04f		// DSB SY
+	WORD	$0xf57ff06f		// ISB SY
+	RET
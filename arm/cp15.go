@@ -0,0 +1,118 @@
+// ARM processor support
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package arm
+
+import (
+	"errors"
+)
+
+// CP15 system control coprocessor registers accessible with ReadCP15() and
+// WriteCP15() (B4.1 VMSA System registers descriptions, ARM Architecture
+// Reference Manual - ARMv7-A and ARMv7-R edition).
+const (
+	// Main ID Register (read-only)
+	CP15_MIDR = iota
+	// Multiprocessor Affinity Register (read-only)
+	CP15_MPIDR
+	// System Control Register
+	CP15_SCTLR
+	// Auxiliary Control Register
+	CP15_ACTLR
+	// Coprocessor Access Control Register
+	CP15_CPACR
+	// Translation Table Base Register 0
+	CP15_TTBR0
+	// Domain Access Control Register
+	CP15_DACR
+	// Vector Base Address Register
+	CP15_VBAR
+	// Configuration Base Address Register (read-only, Cortex-A7/A9)
+	CP15_CBAR
+)
+
+// defined in cp15.s
+func read_midr() uint32
+func read_mpidr() uint32
+func read_sctlr() uint32
+func write_sctlr(val uint32)
+func read_cpacr() uint32
+func write_cpacr(val uint32)
+func read_ttbr0() uint32
+func write_ttbr0(val uint32)
+func read_dacr() uint32
+func write_dacr(val uint32)
+func read_vbar() uint32
+func write_vbar(val uint32)
+func read_cbar() uint32
+
+// ReadCP15 returns the value of the argument CP15 system control coprocessor
+// register (e.g. CP15_SCTLR).
+//
+// This is an advanced and unsafe escape hatch, meant for SoC specific
+// register access not otherwise modeled by this package. Reading a register
+// not implemented by the processor (e.g. CP15_CBAR) results in an Undefined
+// Instruction exception.
+func (cpu *CPU) ReadCP15(reg int) (val uint32, err error) {
+	switch reg {
+	case CP15_MIDR:
+		val = read_midr()
+	case CP15_MPIDR:
+		val = read_mpidr()
+	case CP15_SCTLR:
+		val = read_sctlr()
+	case CP15_ACTLR:
+		val = uint32(read_actlr())
+	case CP15_CPACR:
+		val = read_cpacr()
+	case CP15_TTBR0:
+		val = read_ttbr0()
+	case CP15_DACR:
+		val = read_dacr()
+	case CP15_VBAR:
+		val = read_vbar()
+	case CP15_CBAR:
+		val = read_cbar()
+	default:
+		err = errors.New("invalid CP15 register")
+	}
+
+	return
+}
+
+// WriteCP15 sets the value of the argument CP15 system control coprocessor
+// register (e.g. CP15_SCTLR), an instruction barrier is executed after the
+// write.
+//
+// This is an advanced and unsafe escape hatch, meant for SoC specific
+// register access not otherwise modeled by this package. Writing system
+// control registers can alter the processor state in ways incompatible with
+// the Go runtime (e.g. cache, MMU or exception configuration).
+func (cpu *CPU) WriteCP15(reg int, val uint32) (err error) {
+	switch reg {
+	case CP15_SCTLR:
+		write_sctlr(val)
+	case CP15_ACTLR:
+		write_actlr(int32(val))
+	case CP15_CPACR:
+		write_cpacr(val)
+	case CP15_TTBR0:
+		write_ttbr0(val)
+	case CP15_DACR:
+		write_dacr(val)
+	case CP15_VBAR:
+		write_vbar(val)
+	case CP15_MIDR, CP15_MPIDR, CP15_CBAR:
+		err = errors.New("read-only CP15 register")
+	default:
+		err = errors.New("invalid CP15 register")
+	}
+
+	return
+}
@@ -0,0 +1,116 @@
+// ARM processor support
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// ARM Architecture Reference Manual - ARMv7-A and ARMv7-R edition
+//
+// B4.1 VMSA System registers descriptions
+
+// func read_midr() uint32
+TEXT ·read_midr(SB),$0-4
+	// B4.1.105 MIDR, Main ID Register, VMSA
+	MRC	15, 0, R0, C0, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_mpidr() uint32
+TEXT ·read_mpidr(SB),$0-4
+	// B4.1.106 MPIDR, Multiprocessor Affinity Register, VMSA
+	MRC	15, 0, R0, C0, C0, 5
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_sctlr() uint32
+TEXT ·read_sctlr(SB),$0-4
+	// B4.1.130 SCTLR, System Control Register, VMSA
+	MRC	15, 0, R0, C1, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func write_sctlr(val uint32)
+TEXT ·write_sctlr(SB),$0-4
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C1, C0, 0
+	WORD	$0xf57ff06f		// ISB SY
+
+	RET
+
+// func read_cpacr() uint32
+TEXT ·read_cpacr(SB),$0-4
+	// B4.1.40 CPACR, Coprocessor Access Control Register, VMSA
+	MRC	15, 0, R0, C1, C0, 2
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func write_cpacr(val uint32)
+TEXT ·write_cpacr(SB),$0-4
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C1, C0, 2
+	WORD	$0xf57ff06f		// ISB SY
+
+	RET
+
+// func read_ttbr0() uint32
+TEXT ·read_ttbr0(SB),$0-4
+	// B4.1.154 TTBR0, Translation Table Base Register 0, VMSA
+	MRC	15, 0, R0, C2, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func write_ttbr0(val uint32)
+TEXT ·write_ttbr0(SB),$0-4
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C2, C0, 0
+	WORD	$0xf57ff06f		// ISB SY
+
+	RET
+
+// func read_dacr() uint32
+TEXT ·read_dacr(SB),$0-4
+	// B4.1.43 DACR, Domain Access Control Register, VMSA
+	MRC	15, 0, R0, C3, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func write_dacr(val uint32)
+TEXT ·write_dacr(SB),$0-4
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C3, C0, 0
+	WORD	$0xf57ff06f		// ISB SY
+
+	RET
+
+// func read_vbar() uint32
+TEXT ·read_vbar(SB),$0-4
+	// B4.1.156 VBAR, Vector Base Address Register, Security Extensions
+	MRC	15, 0, R0, C12, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func write_vbar(val uint32)
+TEXT ·write_vbar(SB),$0-4
+	MOVW	val+0(FP), R0
+	MCR	15, 0, R0, C12, C0, 0
+	WORD	$0xf57ff06f		// ISB SY
+
+	RET
+
+// func read_cbar() uint32
+TEXT ·read_cbar(SB),$0-4
+	// Cortex-A7/A9 Configuration Base Address Register
+	MRC	15, 4, R0, C15, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
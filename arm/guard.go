@@ -0,0 +1,234 @@
+// ARM processor support
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package arm
+
+import (
+	"errors"
+	"reflect"
+	"unsafe"
+
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// Stack guard canary value
+const STACK_GUARD_CANARY = 0xdeadc0de
+
+// Exception vector offsets (B1.8.1 Exception vectors and the exception base
+// address, ARM Architecture Reference Manual - ARMv7-A and ARMv7-R edition).
+const (
+	RESET          = 0x00
+	UNDEFINED      = 0x04
+	SUPERVISOR     = 0x08
+	PREFETCH_ABORT = 0x0c
+	DATA_ABORT     = 0x10
+	IRQ            = 0x18
+	FIQ            = 0x1c
+
+	VECTORS = 8
+	// VBAR[4:0] are reserved
+	VECTOR_TABLE_ALIGN = 32
+
+	// ldr pc, [pc, #24] (vector handler address, 8 words ahead)
+	LDR_PC_VECTOR = 0xe59ff018
+
+	SCTLR_V = 13
+)
+
+// Short-descriptor translation table format (B3.5.1, ARM Architecture
+// Reference Manual - ARMv7-A and ARMv7-R edition).
+const (
+	SECTION_SIZE   = 1 << 20
+	SECTION_SHIFT  = 20
+	SECTION        = 0b10
+	SECTION_DOMAIN = 5
+	SECTION_AP     = 10
+	SECTION_AP2    = 15
+
+	TTBR0_BASE_MASK = 0xffffc000
+	DACR_CLIENT     = 0b01
+)
+
+// defined in guard.s
+func read_dfar() uint32
+func dataAbort()
+
+// data abort handler state
+var (
+	abortGuard   *StackGuard
+	abortStack   uint32
+	abortAddress uint32
+	abortPC      uint32
+)
+
+// vector table, with room for its alignment
+var vectorTable [VECTORS*2 + VECTOR_TABLE_ALIGN/4]uint32
+
+// StackGuard represents an opt-in guard region, placed right below a stack
+// and filled with a canary value, to detect stack overflows on bare metal
+// configurations which lack MMU guard pages.
+type StackGuard struct {
+	// Start is the lowest address of the guard region (word aligned).
+	Start uint32
+	// Size is the guard region size in bytes (multiple of 4).
+	Size int
+	// Top is the highest address of the guarded stack, faults trapped by
+	// the data abort handler are reported running from it (see
+	// EnableStackGuard).
+	Top uint32
+}
+
+// Init fills the guard region with the canary value, the region must be
+// reserved for this purpose and never used by the Go runtime.
+func (g *StackGuard) Init() {
+	for addr := g.Start; addr < g.Start+uint32(g.Size); addr += 4 {
+		reg.Write(addr, STACK_GUARD_CANARY)
+	}
+}
+
+// Overflow returns whether the canary value has been overwritten anywhere in
+// the guard region, indicating a stack overflow.
+func (g *StackGuard) Overflow() bool {
+	for addr := g.Start; addr < g.Start+uint32(g.Size); addr += 4 {
+		if reg.Read(addr) != STACK_GUARD_CANARY {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Contains returns whether an address falls within the guard region. When
+// the guard region is mapped with no access permissions, a data abort handler
+// can use it against the faulting address (see DataFaultAddress) to report
+// stack overflows.
+func (g *StackGuard) Contains(addr uint32) bool {
+	return addr >= g.Start && addr < g.Start+uint32(g.Size)
+}
+
+// DataFaultAddress returns the Data Fault Address Register (DFAR), holding the
+// faulting address of the last synchronous data abort.
+func (cpu *CPU) DataFaultAddress() uint32 {
+	return read_dfar()
+}
+
+// Protect maps the guard region with no access permissions, so that any stack
+// overflow within it raises a data abort (see EnableStackGuard), rather than
+// being detected afterwards through the canary value.
+//
+// The guard region must be aligned and sized to 1MB sections, as mapped in
+// the translation table by the TamaGo runtime, and belong to a domain with
+// client access (permissions checked). Once protected the canary value can no
+// longer be verified with Overflow().
+func (g *StackGuard) Protect() error {
+	if g.Start%SECTION_SIZE != 0 || g.Size == 0 || g.Size%SECTION_SIZE != 0 {
+		return errors.New("guard region must be 1MB section aligned and sized")
+	}
+
+	l1 := read_ttbr0() & TTBR0_BASE_MASK
+	dacr := read_dacr()
+
+	for addr := g.Start; addr < g.Start+uint32(g.Size); addr += SECTION_SIZE {
+		desc := l1 + (addr>>SECTION_SHIFT)*4
+		entry := reg.Read(desc)
+
+		if entry&0b11 != SECTION {
+			return errors.New("guard region is not mapped as section")
+		}
+
+		if domain := (entry >> SECTION_DOMAIN) & 0xf; (dacr>>(domain*2))&0b11 != DACR_CLIENT {
+			return errors.New("guard region domain has no client access")
+		}
+
+		// AP[2:0] = 0b000, no access at any privilege level
+		entry &^= 1<<SECTION_AP2 | 0b11<<SECTION_AP
+		reg.Write(desc, entry)
+	}
+
+	// push out the updated descriptors and discard stale ones
+	cache_flush_data()
+	tlb_bp_invalidate()
+
+	return nil
+}
+
+// printHex prints a 32-bit value in hexadecimal notation, without heap
+// allocations.
+func printHex(val uint32) {
+	const digits = "0123456789abcdef"
+
+	buf := [10]byte{'0', 'x'}
+
+	for i := 0; i < 8; i++ {
+		buf[9-i] = digits[(val>>(i*4))&0xf]
+	}
+
+	print(string(buf[:]))
+}
+
+// dataAbortReport is invoked by the data abort handler, in the interrupted
+// processor mode running from the guarded stack top, to report the fault.
+// The processor is halted as the interrupted context cannot be resumed.
+func dataAbortReport() {
+	if abortGuard.Contains(abortAddress) {
+		print("stack overflow at ")
+	} else {
+		print("data abort at ")
+	}
+
+	printHex(abortAddress)
+	print(", pc ")
+	printHex(abortPC)
+	print("\n")
+
+	for {
+	}
+}
+
+// EnableStackGuard installs, as an opt-in, a data abort handler which reports
+// faults within the argument guard region as stack overflows, before halting
+// the processor. The guard region is meant to be protected with Protect(), as
+// the canary value alone does not raise aborts.
+//
+// The handler replaces the data abort vector only, all other exceptions are
+// forwarded to the previously installed vector table. Data aborts outside the
+// guard region are reported as such, as they are fatal for the Go runtime as
+// well.
+func (cpu *CPU) EnableStackGuard(g *StackGuard) error {
+	if g.Top <= g.Start || g.Top%8 != 0 {
+		return errors.New("invalid guarded stack top")
+	}
+
+	if (read_sctlr()>>SCTLR_V)&1 == 1 {
+		return errors.New("high exception vectors are in use")
+	}
+
+	abortGuard = g
+	abortStack = g.Top
+
+	prev := read_vbar()
+	base := uint32(uintptr(unsafe.Pointer(&vectorTable[0])))
+	off := (VECTOR_TABLE_ALIGN - base%VECTOR_TABLE_ALIGN) % VECTOR_TABLE_ALIGN
+	table := vectorTable[off/4 : off/4+VECTORS*2]
+
+	for i := 0; i < VECTORS; i++ {
+		table[i] = LDR_PC_VECTOR
+		table[VECTORS+i] = prev + uint32(i*4)
+	}
+
+	table[VECTORS+DATA_ABORT/4] = uint32(reflect.ValueOf(dataAbort).Pointer())
+
+	// make the vector table visible to instruction fetches
+	cache_flush_data()
+	cache_flush_instruction()
+
+	write_vbar(base + off)
+
+	return nil
+}
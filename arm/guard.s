@@ -0,0 +1,45 @@
+// ARM processor support
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func read_dfar() uint32
+TEXT ·read_dfar(SB),$0
+	// ARM Architecture Reference Manual - ARMv7-A and ARMv7-R edition
+	//
+	// B4.1.52 DFAR, Data Fault Address Register, VMSA
+	MRC	15, 0, R0, C6, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// The data abort handler is entered in Abort mode, with no usable stack, it
+// records the fault and switches back to the interrupted mode to report it
+// from the guarded stack top. The interrupted context is not preserved (R11
+// is clobbered on global variable access) as the fault is fatal.
+//
+// func dataAbort()
+TEXT ·dataAbort(SB),NOSPLIT|NOFRAME,$0
+	// B4.1.52 DFAR, Data Fault Address Register, VMSA
+	MRC	15, 0, R0, C6, C0, 0
+	MOVW	R0, ·abortAddress(SB)
+
+	// B1.9.8 Data Abort exception, the preferred return address is the
+	// faulting instruction address + 8
+	SUB	$8, R14, R0
+	MOVW	R0, ·abortPC(SB)
+
+	// switch to the interrupted mode, with IRQ and FIQ masked
+	WORD	$0xe14f1000		// mrs r1, SPSR
+	AND	$0x1f, R1
+	ORR	$0xc0, R1
+	WORD	$0xe121f001		// msr CPSR_c, r1
+
+	MOVW	·abortStack(SB), R13
+	B	·dataAbortReport(SB)
@@ -0,0 +1,79 @@
+// ARM processor support
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package arm
+
+import (
+	"unsafe"
+)
+
+// defined in neon.s
+func neon_compare(a *byte, b *byte, n int) int
+func neon_copy(dst *byte, src *byte, n int)
+
+// Compare returns the offset of the first differing byte between the two
+// argument buffers, or -1 if they are equal. When the buffers differ in
+// length, and the shortest one is a prefix of the other, its length is
+// returned.
+//
+// The comparison is accelerated with NEON instructions once EnableVFP() has
+// been invoked, a scalar comparison is otherwise performed.
+func (cpu *CPU) Compare(a []byte, b []byte) int {
+	off := 0
+	n := len(a)
+
+	if len(b) < n {
+		n = len(b)
+	}
+
+	if cpu.vfp && n >= 16 {
+		// compare 16 bytes blocks, stopping at the first mismatching one
+		off = neon_compare(&a[0], &b[0], n&^15)
+	}
+
+	for i := off; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	if len(a) != len(b) {
+		return n
+	}
+
+	return -1
+}
+
+// Copy copies the source buffer into the destination one, returning the
+// number of copied bytes (the minimum of both lengths).
+//
+// The copy is accelerated with NEON instructions once EnableVFP() has been
+// invoked, for non overlapping buffers, the built-in copy is otherwise used.
+func (cpu *CPU) Copy(dst []byte, src []byte) int {
+	off := 0
+	n := len(dst)
+
+	if len(src) < n {
+		n = len(src)
+	}
+
+	if cpu.vfp && n >= 32 {
+		d := uintptr(unsafe.Pointer(&dst[0]))
+		s := uintptr(unsafe.Pointer(&src[0]))
+
+		if d+uintptr(n) <= s || s+uintptr(n) <= d {
+			off = n &^ 31
+			neon_copy(&dst[0], &src[0], off)
+		}
+	}
+
+	copy(dst[off:n], src[off:n])
+
+	return n
+}
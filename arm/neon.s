@@ -0,0 +1,46 @@
+// ARM processor support
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// func neon_compare(a *byte, b *byte, n int) int
+TEXT ·neon_compare(SB),$0-16
+	MOVW	a+0(FP), R0
+	MOVW	b+4(FP), R1
+	MOVW	n+8(FP), R2
+	MOVW	$0, R5
+loop:
+	CMP	R2, R5
+	BHS	done
+	WORD	$0xf4200a0d	// VLD1.8 {D0, D1}, [R0]!
+	WORD	$0xf4212a0d	// VLD1.8 {D2, D3}, [R1]!
+	WORD	$0xf3000852	// VCEQ.I8 Q0, Q0, Q1
+	WORD	$0xf2000111	// VAND D0, D0, D1
+	WORD	$0xec543b10	// VMOV R3, R4, D0
+	AND	R4, R3
+	CMN	$1, R3
+	BNE	done
+	ADD	$16, R5
+	B	loop
+done:
+	MOVW	R5, ret+12(FP)
+	RET
+
+// func neon_copy(dst *byte, src *byte, n int)
+TEXT ·neon_copy(SB),$0-12
+	MOVW	dst+0(FP), R0
+	MOVW	src+4(FP), R1
+	MOVW	n+8(FP), R2
+loop:
+	CMP	$0, R2
+	BEQ	done
+	WORD	$0xf421020d	// VLD1.8 {D0-D3}, [R1]!
+	WORD	$0xf400020d	// VST1.8 {D0-D3}, [R0]!
+	SUB	$32, R2
+	B	loop
+done:
+	RET
@@ -0,0 +1,154 @@
+// ARM processor support
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package arm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// defined in stack.s
+func read_sp_usr() uint32
+func read_lr_usr() uint32
+func read_sp_fiq() uint32
+func read_lr_fiq() uint32
+func read_sp_irq() uint32
+func read_lr_irq() uint32
+func read_sp_svc() uint32
+func read_lr_svc() uint32
+func read_sp_abt() uint32
+func read_lr_abt() uint32
+func read_sp_und() uint32
+func read_lr_und() uint32
+func read_sp_mon() uint32
+func read_lr_mon() uint32
+func read_sp_hyp() uint32
+
+// ReadSP returns the current stack pointer (R13).
+func ReadSP() uint32
+
+// ReadLR returns the current link register (R14), which holds the return
+// address of the ReadLR call itself (i.e. the caller program counter).
+func ReadLR() uint32
+
+// read_banked reads the stack pointer and link register of the argument
+// processor mode by switching to it (defined in stack.s).
+func read_banked(mode uint32) (sp uint32, lr uint32)
+
+// bankedMode validates a banked register access to the argument processor
+// mode, returning the mode whose registers must be read.
+func (cpu *CPU) bankedMode(mode int) (int, error) {
+	cur := int(cpu.Mode())
+
+	if cur == USR_MODE {
+		return 0, errors.New("banked registers are not accessible in USR mode")
+	}
+
+	// USR and SYS modes share the same registers
+	if mode == USR_MODE {
+		mode = SYS_MODE
+	}
+
+	switch mode {
+	case SYS_MODE, FIQ_MODE, IRQ_MODE, SVC_MODE, ABT_MODE, UND_MODE:
+	case MON_MODE:
+		if !cpu.security {
+			return 0, errors.New("Security Extensions not available")
+		}
+	case HYP_MODE:
+		if !cpu.virtualization {
+			return 0, errors.New("Virtualization Extensions not available")
+		}
+	default:
+		return 0, fmt.Errorf("invalid processor mode %#x", mode)
+	}
+
+	if mode == cur {
+		return 0, errors.New("current mode registers must be read directly (see ReadSP, ReadLR)")
+	}
+
+	return mode, nil
+}
+
+// ReadBankedSP returns the stack pointer banked for the argument processor
+// mode. On processors with the Virtualization Extensions the banked register
+// is accessed directly (MRS banked register), otherwise the processor
+// temporarily switches to the argument mode, with interrupts masked.
+//
+// An error is returned when the argument mode registers are the current ones
+// (see ReadSP), when invoked in USR mode or for invalid or unavailable modes.
+func (cpu *CPU) ReadBankedSP(mode int) (sp uint32, err error) {
+	if mode, err = cpu.bankedMode(mode); err != nil {
+		return
+	}
+
+	if !cpu.virtualization {
+		sp, _ = read_banked(uint32(mode))
+		return
+	}
+
+	switch mode {
+	case SYS_MODE:
+		sp = read_sp_usr()
+	case FIQ_MODE:
+		sp = read_sp_fiq()
+	case IRQ_MODE:
+		sp = read_sp_irq()
+	case SVC_MODE:
+		sp = read_sp_svc()
+	case ABT_MODE:
+		sp = read_sp_abt()
+	case UND_MODE:
+		sp = read_sp_und()
+	case MON_MODE:
+		sp = read_sp_mon()
+	case HYP_MODE:
+		sp = read_sp_hyp()
+	}
+
+	return
+}
+
+// ReadBankedLR returns the link register banked for the argument processor
+// mode, with the same methods and restrictions of ReadBankedSP, Hyp mode has
+// no banked link register.
+func (cpu *CPU) ReadBankedLR(mode int) (lr uint32, err error) {
+	if mode == HYP_MODE {
+		return 0, errors.New("HYP mode has no banked link register")
+	}
+
+	if mode, err = cpu.bankedMode(mode); err != nil {
+		return
+	}
+
+	if !cpu.virtualization {
+		_, lr = read_banked(uint32(mode))
+		return
+	}
+
+	switch mode {
+	case SYS_MODE:
+		lr = read_lr_usr()
+	case FIQ_MODE:
+		lr = read_lr_fiq()
+	case IRQ_MODE:
+		lr = read_lr_irq()
+	case SVC_MODE:
+		lr = read_lr_svc()
+	case ABT_MODE:
+		lr = read_lr_abt()
+	case UND_MODE:
+		lr = read_lr_und()
+	case MON_MODE:
+		lr = read_lr_mon()
+	}
+
+	return
+}
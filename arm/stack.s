@@ -0,0 +1,149 @@
+// ARM processor support
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// func ReadSP() uint32
+TEXT ·ReadSP(SB),$0
+	MOVW	R13, R0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func ReadLR() uint32
+TEXT ·ReadLR(SB),$0
+	MOVW	R14, R0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// ARM Architecture Reference Manual - ARMv7-A and ARMv7-R edition
+//
+// B9.3.9 MRS (Banked register), Virtualization Extensions only
+
+// func read_sp_usr() uint32
+TEXT ·read_sp_usr(SB),$0
+	WORD	$0xe1050200		// mrs r0, sp_usr
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_lr_usr() uint32
+TEXT ·read_lr_usr(SB),$0
+	WORD	$0xe1060200		// mrs r0, lr_usr
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_sp_fiq() uint32
+TEXT ·read_sp_fiq(SB),$0
+	WORD	$0xe10d0200		// mrs r0, sp_fiq
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_lr_fiq() uint32
+TEXT ·read_lr_fiq(SB),$0
+	WORD	$0xe10e0200		// mrs r0, lr_fiq
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_sp_irq() uint32
+TEXT ·read_sp_irq(SB),$0
+	WORD	$0xe1010300		// mrs r0, sp_irq
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_lr_irq() uint32
+TEXT ·read_lr_irq(SB),$0
+	WORD	$0xe1000300		// mrs r0, lr_irq
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_sp_svc() uint32
+TEXT ·read_sp_svc(SB),$0
+	WORD	$0xe1030300		// mrs r0, sp_svc
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_lr_svc() uint32
+TEXT ·read_lr_svc(SB),$0
+	WORD	$0xe1020300		// mrs r0, lr_svc
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_sp_abt() uint32
+TEXT ·read_sp_abt(SB),$0
+	WORD	$0xe1050300		// mrs r0, sp_abt
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_lr_abt() uint32
+TEXT ·read_lr_abt(SB),$0
+	WORD	$0xe1040300		// mrs r0, lr_abt
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_sp_und() uint32
+TEXT ·read_sp_und(SB),$0
+	WORD	$0xe1070300		// mrs r0, sp_und
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_lr_und() uint32
+TEXT ·read_lr_und(SB),$0
+	WORD	$0xe1060300		// mrs r0, lr_und
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_sp_mon() uint32
+TEXT ·read_sp_mon(SB),$0
+	WORD	$0xe10d0300		// mrs r0, sp_mon
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_lr_mon() uint32
+TEXT ·read_lr_mon(SB),$0
+	WORD	$0xe10c0300		// mrs r0, lr_mon
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_sp_hyp() uint32
+TEXT ·read_sp_hyp(SB),$0
+	WORD	$0xe10f0300		// mrs r0, sp_hyp
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// B9.3.10 MSR (register), B9.3.8 MRS
+
+// func read_banked(mode uint32) (sp uint32, lr uint32)
+TEXT ·read_banked(SB),$0-12
+	MOVW	mode+0(FP), R0
+	ORR	$0xc0, R0			// mask IRQ and FIQ
+
+	WORD	$0xe10f3000		// mrs r3, CPSR
+	WORD	$0xe121f000		// msr CPSR_c, r0
+	MOVW	R13, R1
+	MOVW	R14, R2
+	WORD	$0xe121f003		// msr CPSR_c, r3
+
+	MOVW	R1, sp+4(FP)
+	MOVW	R2, lr+8(FP)
+
+	RET
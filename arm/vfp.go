@@ -19,4 +19,5 @@ func vfp_enable()
 // EnableVFP activates the ARM Vector-Floating-Point co-processor.
 func (cpu *CPU) EnableVFP() {
 	vfp_enable()
+	cpu.vfp = true
 }
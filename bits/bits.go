@@ -24,3 +24,11 @@ func Clear(addr *uint32, pos int) {
 func SetN(addr *uint32, pos int, mask int, val uint32) {
 	*addr = (*addr & (^(uint32(mask) << pos))) | (val << pos)
 }
+
+func Toggle(addr *uint32, pos int) {
+	*addr ^= (1 << pos)
+}
+
+func ToggleN(addr *uint32, pos int, mask int) {
+	*addr ^= (uint32(mask) << pos)
+}
@@ -0,0 +1,144 @@
+// https://github.com/f-secure-foundry/tamago
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package bits
+
+import (
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	for _, tc := range []struct {
+		val  uint32
+		pos  int
+		mask int
+		exp  uint32
+	}{
+		{0x00000001, 0, 1, 1},
+		{0x00000000, 0, 1, 0},
+		{0x80000000, 31, 1, 1},
+		{0x7fffffff, 31, 1, 0},
+		{0xf0000000, 28, 0xf, 0xf},
+		{0x0000000f, 0, 0xf, 0xf},
+		{0x12345678, 8, 0xff, 0x56},
+		{0xffff0000, 16, 0xffff, 0xffff},
+	} {
+		if res := Get(&tc.val, tc.pos, tc.mask); res != tc.exp {
+			t.Errorf("Get(%#x, %d, %#x) = %#x, expected %#x", tc.val, tc.pos, tc.mask, res, tc.exp)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, tc := range []struct {
+		val uint32
+		pos int
+		exp uint32
+	}{
+		{0x00000000, 0, 0x00000001},
+		{0x00000000, 31, 0x80000000},
+		{0x80000000, 31, 0x80000000},
+		{0x0000ff00, 4, 0x0000ff10},
+	} {
+		val := tc.val
+		Set(&val, tc.pos)
+
+		if val != tc.exp {
+			t.Errorf("Set(%#x, %d) = %#x, expected %#x", tc.val, tc.pos, val, tc.exp)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	for _, tc := range []struct {
+		val uint32
+		pos int
+		exp uint32
+	}{
+		{0xffffffff, 0, 0xfffffffe},
+		{0xffffffff, 31, 0x7fffffff},
+		{0x00000000, 31, 0x00000000},
+		{0x0000ff10, 4, 0x0000ff00},
+	} {
+		val := tc.val
+		Clear(&val, tc.pos)
+
+		if val != tc.exp {
+			t.Errorf("Clear(%#x, %d) = %#x, expected %#x", tc.val, tc.pos, val, tc.exp)
+		}
+	}
+}
+
+func TestSetN(t *testing.T) {
+	for _, tc := range []struct {
+		val  uint32
+		pos  int
+		mask int
+		set  uint32
+		exp  uint32
+	}{
+		{0x00000000, 0, 0xf, 0xa, 0x0000000a},
+		{0xffffffff, 0, 0xf, 0x0, 0xfffffff0},
+		{0x00000000, 28, 0xf, 0xa, 0xa0000000},
+		{0xffffffff, 28, 0xf, 0x5, 0x5fffffff},
+		{0x00000000, 31, 1, 1, 0x80000000},
+		{0x12345678, 8, 0xff, 0xab, 0x1234ab78},
+		{0x12345678, 16, 0xffff, 0x8765, 0x87655678},
+	} {
+		val := tc.val
+		SetN(&val, tc.pos, tc.mask, tc.set)
+
+		if val != tc.exp {
+			t.Errorf("SetN(%#x, %d, %#x, %#x) = %#x, expected %#x", tc.val, tc.pos, tc.mask, tc.set, val, tc.exp)
+		}
+	}
+}
+
+func TestToggle(t *testing.T) {
+	for _, tc := range []struct {
+		val uint32
+		pos int
+		exp uint32
+	}{
+		{0x00000000, 0, 0x00000001},
+		{0x00000001, 0, 0x00000000},
+		{0x00000000, 31, 0x80000000},
+		{0x80000000, 31, 0x00000000},
+		{0x0000ff00, 8, 0x0000fe00},
+	} {
+		val := tc.val
+		Toggle(&val, tc.pos)
+
+		if val != tc.exp {
+			t.Errorf("Toggle(%#x, %d) = %#x, expected %#x", tc.val, tc.pos, val, tc.exp)
+		}
+	}
+}
+
+func TestToggleN(t *testing.T) {
+	for _, tc := range []struct {
+		val  uint32
+		pos  int
+		mask int
+		exp  uint32
+	}{
+		{0x00000000, 0, 0xf, 0x0000000f},
+		{0x0000000a, 0, 0xf, 0x00000005},
+		{0x00000000, 28, 0xf, 0xf0000000},
+		{0xa0000000, 28, 0xf, 0x50000000},
+		{0x00000000, 31, 1, 0x80000000},
+		{0x12345678, 16, 0xffff, 0xedcb5678},
+	} {
+		val := tc.val
+		ToggleN(&val, tc.pos, tc.mask)
+
+		if val != tc.exp {
+			t.Errorf("ToggleN(%#x, %d, %#x) = %#x, expected %#x", tc.val, tc.pos, tc.mask, val, tc.exp)
+		}
+	}
+}
@@ -69,6 +69,15 @@ func Init(start uint32, size int) {
 	dma.Unlock()
 }
 
+// Initialized returns whether a memory region for DMA buffer allocation has
+// been initialized with Init().
+func Initialized() bool {
+	dma.Lock()
+	defer dma.Unlock()
+
+	return dma.freeBlocks != nil
+}
+
 // Reserve allocated a slice of bytes for DMA purposes, by placing its data
 // within the DMA region, with optional alignment. It returns the slice along
 // with its data allocation address. The buffer can be freed up with Release().
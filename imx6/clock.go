@@ -22,7 +22,13 @@ const (
 	CCM_CACRR      = 0x020c4010
 	CACRR_ARM_PODF = 0
 
+	CCM_CSCMR1            = 0x020c401c
+	CSCMR1_USDHC2_CLK_SEL = 17
+	CSCMR1_USDHC1_CLK_SEL = 16
+
 	CCM_CSCDR1          = 0x020c4024
+	CSCDR1_USDHC2_PODF  = 16
+	CSCDR1_USDHC1_PODF  = 11
 	CSCDR1_CLK_PODF     = 0
 	CSCDR1_UART_CLK_SEL = 6
 
@@ -34,6 +40,10 @@ const (
 	PLL_POWER          = 12
 	PLL_DIV_SELECT     = 0
 
+	CCM_ANALOG_PFD_528 = 0x020c8100
+	PFD2_FRAC          = 16
+	PFD0_FRAC          = 0
+
 	PMU_REG_CORE   = 0x020c8140
 	CORE_REG2_TARG = 18
 	CORE_REG0_TARG = 0
@@ -46,8 +56,9 @@ const (
 
 // Oscillator frequencies
 const (
-	OSC_FREQ = 24000000
-	VCO_FREQ = 480000000
+	OSC_FREQ  = 24000000
+	VCO_FREQ  = 480000000
+	PLL2_FREQ = 528000000
 )
 
 // ARMCoreDiv returns the ARM core divider value
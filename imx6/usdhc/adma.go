@@ -67,6 +67,53 @@ func (bd *ADMABufferDescriptor) Init(addr uint32, size int) {
 	}
 }
 
+// Append extends the descriptor chain with the transfer of an additional
+// buffer, marking its last descriptor as the end of the chain.
+func (bd *ADMABufferDescriptor) Append(addr uint32, size int) {
+	b := bd
+
+	for b.next != nil {
+		b = b.next
+	}
+
+	next := &ADMABufferDescriptor{}
+	next.Init(addr, size)
+
+	b.Attribute &^= 1 << ATTR_END
+	b.next = next
+}
+
+// Split splits descriptors whose buffer crosses a multiple of the argument
+// address boundary, so that each of them lies within a single boundary
+// aligned region. The boundary must be a power of 2.
+func (bd *ADMABufferDescriptor) Split(boundary int) {
+	if boundary <= 0 {
+		return
+	}
+
+	for b := bd; b != nil; b = b.next {
+		start := uint64(b.Address)
+		end := start + uint64(b.Length)
+		limit := (start | uint64(boundary-1)) + 1
+
+		if end <= limit {
+			continue
+		}
+
+		// the remainder is transferred by a new descriptor
+		next := &ADMABufferDescriptor{
+			Attribute: b.Attribute,
+			Length:    uint16(end - limit),
+			Address:   uint32(limit),
+			next:      b.next,
+		}
+
+		b.Attribute &^= 1 << ATTR_END
+		b.Length = uint16(limit - start)
+		b.next = next
+	}
+}
+
 // Bytes converts the descriptor structure to byte array format.
 func (bd *ADMABufferDescriptor) Bytes() []byte {
 	buf := new(bytes.Buffer)
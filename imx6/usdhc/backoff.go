@@ -0,0 +1,75 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"fmt"
+	"time"
+)
+
+// Default backoff policy for long operations busy polling.
+const (
+	BACKOFF_INITIAL_DELAY = 1 * time.Millisecond
+	BACKOFF_MAX_DELAY     = 100 * time.Millisecond
+	BACKOFF_MULTIPLIER    = 2
+)
+
+// Backoff represents the card status polling policy applied while waiting
+// for completion of long operations (e.g. erase).
+type Backoff struct {
+	// delay after the first poll
+	InitialDelay time.Duration
+	// maximum delay between polls (0 for no limit)
+	MaxDelay time.Duration
+	// delay multiplier applied after each poll (≤ 1 for a fixed delay)
+	Multiplier int
+}
+
+// next returns the delay following the argument one.
+func (b *Backoff) next(delay time.Duration) time.Duration {
+	if b.Multiplier > 1 {
+		delay *= time.Duration(b.Multiplier)
+	}
+
+	if b.MaxDelay > 0 && delay > b.MaxDelay {
+		delay = b.MaxDelay
+	}
+
+	return delay
+}
+
+// waitStateLong waits for the card to reach the argument state, as
+// waitState(), polling its status according to the LongWait backoff policy.
+func (hw *USDHC) waitStateLong(state int, timeout time.Duration) (err error) {
+	start := time.Now()
+	delay := hw.LongWait.InitialDelay
+
+	for {
+		// CMD13 - SEND_STATUS - poll card status
+		if err = hw.cmd(13, hw.rca, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true, Timeout: hw.writeTimeout}); err == nil {
+			curState := (hw.rsp(0) >> STATUS_CURRENT_STATE) & 0b1111
+
+			if curState == uint32(state) {
+				return
+			}
+
+			err = fmt.Errorf("expected card state %s, got %s", StateName(uint32(state)), StateName(curState))
+		}
+
+		if time.Since(start) >= timeout {
+			return
+		}
+
+		time.Sleep(delay)
+		delay = hw.LongWait.next(delay)
+	}
+}
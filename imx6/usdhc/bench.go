@@ -0,0 +1,81 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// throughput returns the transfer rate in MB/s.
+func throughput(size int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return float64(size) / d.Seconds() / 1e6
+}
+
+// Benchmark measures sequential read and write throughput (MB/s) over the
+// argument number of blocks at the end of the user area, timed with the
+// runtime clock (backed by the ARM timer).
+//
+// The region is read, overwritten with its complement and then restored to
+// its original content, only the first two transfers are timed. The card
+// must not be accessed by other means, and power must not be removed, during
+// the benchmark.
+func (hw *USDHC) Benchmark(blocks int) (readMBps float64, writeMBps float64, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.SD && !hw.card.MMC {
+		return 0, 0, errors.New("no card detected")
+	}
+
+	if hw.partition != "user" {
+		return 0, 0, errors.New("benchmark requires the user partition")
+	}
+
+	if blocks <= 0 || blocks > hw.card.Blocks {
+		return 0, 0, fmt.Errorf("invalid number of blocks %d", blocks)
+	}
+
+	lba := hw.card.Blocks - blocks
+	size := blocks * hw.card.BlockSize
+
+	start := time.Now()
+	orig, err := hw.readBlocks(lba, blocks)
+
+	if err != nil {
+		return
+	}
+
+	readMBps = throughput(size, time.Since(start))
+
+	buf := make([]byte, size)
+
+	for i := range orig {
+		buf[i] = ^orig[i]
+	}
+
+	start = time.Now()
+	err = hw.writeBlocks(lba, buf)
+	writeMBps = throughput(size, time.Since(start))
+
+	// restore original content, also on failure
+	if e := hw.writeBlocks(lba, orig); e != nil {
+		return readMBps, writeMBps, fmt.Errorf("could not restore original content, %v", e)
+	}
+
+	return
+}
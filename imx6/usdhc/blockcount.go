@@ -0,0 +1,41 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+// cmd23Supported returns whether the card supports CMD23 (SET_BLOCK_COUNT),
+// which is mandatory on eMMC cards and reported in the SCR CMD_SUPPORT field
+// on SD cards.
+func (hw *USDHC) cmd23Supported() bool {
+	switch {
+	case hw.card.MMC:
+		return true
+	case hw.card.SD:
+		return (hw.card.SCR.CMDSupport>>CMD_SUPPORT_CMD23)&1 == 1
+	default:
+		return false
+	}
+}
+
+// setBlockCount issues CMD23 to predefine the number of blocks of the
+// following multiple block transfer, which then requires no stop command.
+// Reliable write is requested, on eMMC cards, for writes when enabled (see
+// USDHC.ReliableWrite).
+func (hw *USDHC) setBlockCount(dtd uint32, blocks uint32) error {
+	arg := blocks & 0xffff
+
+	if dtd == WRITE && hw.card.MMC && hw.ReliableWrite {
+		arg |= 1 << SET_BLOCK_COUNT_RELIABLE_WRITE
+	}
+
+	// CMD23 - SET_BLOCK_COUNT - define number of blocks
+	return hw.cmd(23, arg, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true})
+}
@@ -0,0 +1,121 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"io"
+)
+
+// Blockdev implements io.ReaderAt and io.WriterAt over the currently selected
+// card physical partition (see SelectPartition()), translating byte offsets
+// to block transfers, to serve as block device for filesystems.
+type Blockdev struct {
+	hw *USDHC
+}
+
+// NewBlockdev returns a Blockdev for the card.
+func (hw *USDHC) NewBlockdev() *Blockdev {
+	return &Blockdev{hw: hw}
+}
+
+// Size returns the capacity of the currently selected physical partition.
+func (d *Blockdev) Size() int64 {
+	return d.hw.Info().Size
+}
+
+// ReadAt implements io.ReaderAt, reads beyond the card capacity return
+// io.EOF.
+func (d *Blockdev) ReadAt(p []byte, off int64) (n int, err error) {
+	r := &Reader{
+		hw:   d.hw,
+		size: d.Size(),
+	}
+
+	return r.ReadAt(p, off)
+}
+
+// WriteAt implements io.WriterAt, writes starting beyond the card capacity
+// return io.EOF while the ones exceeding it are truncated and return
+// io.ErrUnexpectedEOF.
+//
+// Writes not aligned to the block size are performed with a read-modify-write
+// of the partially written blocks, holding the controller lock throughout.
+func (d *Blockdev) WriteAt(p []byte, off int64) (n int, err error) {
+	size := d.Size()
+
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	if max := size - off; int64(len(p)) > max {
+		p = p[0:max]
+		err = io.ErrUnexpectedEOF
+	}
+
+	if len(p) == 0 {
+		return
+	}
+
+	hw := d.hw
+
+	// the read-modify-write sequence is performed atomically
+	hw.Lock()
+	defer hw.Unlock()
+
+	blockSize := int64(hw.card.BlockSize)
+
+	start := off
+	end := off + int64(len(p))
+	lba := start / blockSize
+	blocks := (end+blockSize-1)/blockSize - lba
+
+	buf := p
+
+	if start%blockSize != 0 || end%blockSize != 0 {
+		buf = make([]byte, blocks*blockSize)
+
+		// read partially written first block
+		if start%blockSize != 0 {
+			b, e := hw.readBlocks(int(lba), 1)
+
+			if e != nil {
+				return 0, e
+			}
+
+			copy(buf, b)
+		}
+
+		// read partially written last block
+		if end%blockSize != 0 && (blocks > 1 || start%blockSize == 0) {
+			b, e := hw.readBlocks(int(lba+blocks-1), 1)
+
+			if e != nil {
+				return 0, e
+			}
+
+			copy(buf[(blocks-1)*blockSize:], b)
+		}
+
+		copy(buf[start-lba*blockSize:], p)
+	}
+
+	if e := hw.writeBlocks(int(lba), buf); e != nil {
+		return 0, e
+	}
+
+	return len(p), err
+}
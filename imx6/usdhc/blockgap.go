@@ -0,0 +1,69 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// PauseAtBlockGap requests the controller to stop the data transfer in
+// progress at the next block gap, waiting for the Block Gap Event. The
+// transfer can then be resumed with Continue() or aborted by a card
+// detection.
+//
+// As it is meant to be invoked while a transfer is in progress, from a
+// different goroutine, the controller lock is not held. A paused transfer
+// still counts against its completion timeout, therefore the pause must not
+// exceed it.
+//
+// On writes the controller simply stops sending blocks. On reads it stops
+// the card clock at the block gap, unless the Read Wait Control (RWCTL) is
+// enabled, which is only applicable to SDIO cards supporting read wait
+// signaling through DAT2 and is never set by this driver as memory cards
+// lack such support.
+func (hw *USDHC) PauseAtBlockGap() (err error) {
+	if hw.cg == 0 {
+		return errors.New("controller is not initialized")
+	}
+
+	reg.Clear(hw.prot_ctrl, PROT_CTRL_CREQ)
+	reg.Set(hw.prot_ctrl, PROT_CTRL_SABGREQ)
+
+	if !reg.WaitFor(hw.readTimeout, hw.int_status, INT_STATUS_BGE, 1, 1) {
+		reg.Clear(hw.prot_ctrl, PROT_CTRL_SABGREQ)
+		return errors.New("block gap event timeout")
+	}
+
+	// clear Block Gap Event
+	reg.Write(hw.int_status, 1<<INT_STATUS_BGE)
+
+	return
+}
+
+// Continue resumes a data transfer previously stopped with
+// PauseAtBlockGap().
+func (hw *USDHC) Continue() (err error) {
+	if hw.cg == 0 {
+		return errors.New("controller is not initialized")
+	}
+
+	if reg.Get(hw.prot_ctrl, PROT_CTRL_SABGREQ, 1) == 0 {
+		return errors.New("transfer not paused")
+	}
+
+	reg.Clear(hw.prot_ctrl, PROT_CTRL_SABGREQ)
+	reg.Set(hw.prot_ctrl, PROT_CTRL_CREQ)
+
+	return
+}
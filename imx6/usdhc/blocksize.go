@@ -0,0 +1,100 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// blockSizeSupported returns whether the argument block length is supported
+// for both reads and writes, according to the CSD register maximum block
+// lengths (READ_BL_LEN, WRITE_BL_LEN) and partial block support
+// (READ_BL_PARTIAL, WRITE_BL_PARTIAL).
+//
+// On SD cards the block length is limited to 512 bytes regardless of the
+// CSD maximum block lengths, which can report up to 2048 bytes only to
+// describe the capacity of 2GB and 4GB standard capacity cards (4.3.2
+// 2 GByte Card, SD-PL-7.10). Larger block lengths are therefore only
+// allowed on MMC cards.
+func blockSizeSupported(csd [4]uint32, size int, sd bool) bool {
+	if size <= 0 || size&(size-1) != 0 {
+		return false
+	}
+
+	if sd && size > 512 {
+		return false
+	}
+
+	read := 1 << ResponseField(csd, CSD_READ_BL_LEN, 0xf)
+	write := 1 << ResponseField(csd, CSD_WRITE_BL_LEN, 0xf)
+
+	readPartial := ResponseField(csd, CSD_READ_BL_PARTIAL, 1) == 1
+	writePartial := ResponseField(csd, CSD_WRITE_BL_PARTIAL, 1) == 1
+
+	switch {
+	case size > read || size > write:
+		return false
+	case size < read && !readPartial:
+		return false
+	case size < write && !writePartial:
+		return false
+	}
+
+	return true
+}
+
+// SetBlockSize changes the card block length (CMD16 SET_BLOCKLEN), used by
+// all subsequent data transfers, updating the reported block size and count
+// (see Info()) accordingly.
+//
+// The size must be a power of 2 supported by the card for both reads and
+// writes, as indicated by its CSD register, and cannot exceed 512 bytes on SD
+// cards. High capacity cards have a fixed
+// 512 bytes block length, which cannot be changed. The block length can
+// only be set in single data rate mode.
+func (hw *USDHC) SetBlockSize(size uint32) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.SD && !hw.card.MMC {
+		return errors.New("no card detected")
+	}
+
+	if hw.card.HC {
+		return fmt.Errorf("block size is fixed at %d bytes on high capacity cards", hw.card.BlockSize)
+	}
+
+	if hw.card.DDR {
+		return errors.New("block size cannot be set in dual data rate mode")
+	}
+
+	if !blockSizeSupported(hw.csd, int(size), hw.card.SD) {
+		return fmt.Errorf("unsupported block size %d", size)
+	}
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout); err != nil {
+		return
+	}
+
+	// CMD16 - SET_BLOCKLEN - define the block length
+	if err = hw.cmd(16, size, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	capacity := int64(hw.card.Blocks) * int64(hw.card.BlockSize)
+
+	hw.card.BlockSize = int(size)
+	hw.card.Blocks = int(capacity / int64(size))
+
+	return
+}
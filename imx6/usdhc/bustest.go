@@ -0,0 +1,63 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"fmt"
+)
+
+// busTestPattern returns the bus testing pattern for the argument data bus
+// width, along with the number of its leading bits which are defined, the
+// card returns them inverted (A.8.3 Bus testing procedure, JESD84-B51).
+func busTestPattern(width int) (pattern []byte, n int) {
+	switch width {
+	case 8:
+		return []byte{0x55, 0xaa, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, 16
+	case 4:
+		return []byte{0x5a, 0x00, 0x00, 0x00}, 8
+	default:
+		return []byte{0x80, 0x00, 0x00, 0x00}, 2
+	}
+}
+
+// busTest verifies the eMMC data lines connectivity, for the argument data
+// bus width, by sending a test pattern with CMD19 (BUS_TEST_W) and reading
+// it back with CMD14 (BUS_TEST_R), the card must be in transfer state.
+func (hw *USDHC) busTest(width int) (err error) {
+	pattern, n := busTestPattern(width)
+	buf := make([]byte, len(pattern))
+
+	// the transfers complete on the single block count
+	hw.noAC12 = true
+	defer func() { hw.noAC12 = false }()
+
+	// CMD19 - BUS_TEST_W - send bus test pattern
+	if err = hw.transferArg(19, WRITE, 0, 1, uint32(len(pattern)), pattern); err != nil {
+		return
+	}
+
+	// CMD14 - BUS_TEST_R - read back inverted bus test pattern
+	if err = hw.transferArg(14, READ, 0, 1, uint32(len(buf)), buf); err != nil {
+		return
+	}
+
+	// only the pattern bits matter, the remaining ones are undefined
+	for i := 0; i < n; i++ {
+		mask := byte(0x80) >> (i % 8)
+
+		if (pattern[i/8]^buf[i/8])&mask == 0 {
+			return fmt.Errorf("bus test failed on %d-bit data bus (%#x)", width, buf[0:(n+7)/8])
+		}
+	}
+
+	return
+}
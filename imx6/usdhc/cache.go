@@ -0,0 +1,97 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Cache registers (7.4 Extended CSD register, JESD84-B51).
+const (
+	EXT_CSD_FLUSH_CACHE = 32
+	EXT_CSD_CACHE_CTRL  = 33
+
+	// FLUSH_CACHE [32]
+	FLUSH_CACHE_FLUSH = 0
+	// CACHE_CTRL [33]
+	CACHE_CTRL_CACHE_EN = 0
+
+	// The cache flush has no specified timeout (6.6.38 Volatile Cache,
+	// JESD84-B51), a generous one is applied.
+	CACHE_FLUSH_TIMEOUT = 30 * time.Second
+)
+
+// cacheEnabled returns whether the eMMC volatile cache is present and enabled
+// according to the argument Extended CSD.
+func cacheEnabled(extCSD []byte) bool {
+	return binary.LittleEndian.Uint32(extCSD[EXT_CSD_CACHE_SIZE:]) != 0 &&
+		(extCSD[EXT_CSD_CACHE_CTRL]>>CACHE_CTRL_CACHE_EN)&1 == 1
+}
+
+// Flush writes back the eMMC volatile cache content to non-volatile storage,
+// waiting for its completion. On SD cards, and eMMC cards without an enabled
+// cache, it has no effect.
+func (hw *USDHC) Flush() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return
+	}
+
+	extCSD, err := hw.extCSDCached()
+
+	if err != nil || !cacheEnabled(extCSD) {
+		return
+	}
+
+	return hw.switchMMCLong(EXT_CSD_FLUSH_CACHE, 1<<FLUSH_CACHE_FLUSH, CACHE_FLUSH_TIMEOUT)
+}
+
+// SetCacheEnabled enables or disables the eMMC volatile cache (CACHE_CTRL),
+// its content is written back by the card when disabled. While enabled data
+// durability is only guaranteed after Flush().
+func (hw *USDHC) SetCacheEnabled(enable bool) (err error) {
+	var ctrl uint32
+
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return errors.New("cache is only supported on MMC cards")
+	}
+
+	extCSD, err := hw.extCSDCached()
+
+	if err != nil {
+		return
+	}
+
+	if binary.LittleEndian.Uint32(extCSD[EXT_CSD_CACHE_SIZE:]) == 0 {
+		return errors.New("card has no cache")
+	}
+
+	if enable {
+		ctrl = 1 << CACHE_CTRL_CACHE_EN
+	}
+
+	if err = hw.switchMMCLong(EXT_CSD_CACHE_CTRL, ctrl, CACHE_FLUSH_TIMEOUT); err != nil {
+		return
+	}
+
+	// refresh the cached Extended CSD
+	_, err = hw.readExtCSD()
+
+	return
+}
@@ -0,0 +1,129 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"strings"
+)
+
+// CID registers
+const (
+	// p212, 5.2 CID register, SD-PL-7.10
+	SD_CID_OID = 104 + CSD_RSP_OFF
+	SD_CID_PNM = 64 + CSD_RSP_OFF
+	SD_CID_PRV = 56 + CSD_RSP_OFF
+	SD_CID_PSN = 24 + CSD_RSP_OFF
+	SD_CID_MDT = 8 + CSD_RSP_OFF
+
+	SD_CID_PNM_LENGTH = 5
+
+	// 7.2 CID register, JESD84-B51
+	MMC_CID_CBX = 112 + CSD_RSP_OFF
+	MMC_CID_OID = 104 + CSD_RSP_OFF
+	MMC_CID_PNM = 56 + CSD_RSP_OFF
+	MMC_CID_PRV = 48 + CSD_RSP_OFF
+	MMC_CID_PSN = 16 + CSD_RSP_OFF
+	MMC_CID_MDT = 8 + CSD_RSP_OFF
+
+	MMC_CID_PNM_LENGTH = 6
+
+	// device type (removable card, BGA or POP)
+	CBX_CARD = 0b00
+	CBX_BGA  = 0b01
+	CBX_POP  = 0b10
+
+	// 7.2 CID register, MDT [15:8], JESD84-B51
+	MMC_MDT_BASE_YEAR     = 1997
+	MMC_MDT_BASE_YEAR_REV = 2013
+)
+
+// CID represents the Card Identification register of SD/MMC cards.
+type CID struct {
+	// Manufacturer ID (MID)
+	ManufacturerID uint8
+	// OEM/Application ID (OID), 16 bits on SD cards and 8 bits on MMC
+	// cards
+	OEMID uint16
+	// Product name (PNM), 5 characters on SD cards and 6 characters on
+	// MMC cards
+	ProductName string
+	// Product revision (PRV), as n.m BCD digits
+	ProductRevision uint8
+	// Product serial number (PSN)
+	SerialNumber uint32
+	// Manufacturing date (MDT), year and month
+	ManufacturingYear  int
+	ManufacturingMonth int
+}
+
+func cidName(rsp [4]uint32, pos int, length int) string {
+	name := make([]byte, length)
+
+	for i := range name {
+		name[i] = byte(ResponseField(rsp, pos+(length-1-i)*8, 0xff))
+	}
+
+	return strings.TrimRight(string(name), " \x00")
+}
+
+func cidSerial(rsp [4]uint32, pos int) uint32 {
+	return ResponseField(rsp, pos+16, 0xffff)<<16 | ResponseField(rsp, pos, 0xffff)
+}
+
+// parseCID decodes the CID register, for MMC cards the manufacturing year
+// encoding depends on the EXT_CSD revision (when known, 0 otherwise).
+func parseCID(rsp [4]uint32, mmc bool, rev uint8) (cid CID) {
+	cid.ManufacturerID = uint8(ResponseField(rsp, CID_MID, 0xff))
+
+	if !mmc {
+		cid.OEMID = uint16(ResponseField(rsp, SD_CID_OID, 0xffff))
+		cid.ProductName = cidName(rsp, SD_CID_PNM, SD_CID_PNM_LENGTH)
+		cid.ProductRevision = uint8(ResponseField(rsp, SD_CID_PRV, 0xff))
+		cid.SerialNumber = cidSerial(rsp, SD_CID_PSN)
+
+		// 5.2 CID register, MDT [19:8], SD-PL-7.10
+		mdt := ResponseField(rsp, SD_CID_MDT, 0xfff)
+		cid.ManufacturingYear = 2000 + int(mdt>>4)
+		cid.ManufacturingMonth = int(mdt & 0xf)
+
+		return
+	}
+
+	cid.OEMID = uint16(ResponseField(rsp, MMC_CID_OID, 0xff))
+	cid.ProductName = cidName(rsp, MMC_CID_PNM, MMC_CID_PNM_LENGTH)
+	cid.ProductRevision = uint8(ResponseField(rsp, MMC_CID_PRV, 0xff))
+	cid.SerialNumber = cidSerial(rsp, MMC_CID_PSN)
+
+	mdt := ResponseField(rsp, MMC_CID_MDT, 0xff)
+	cid.ManufacturingMonth = int(mdt >> 4)
+	year := int(mdt & 0xf)
+
+	// year codes 0 to 12 refer to 2013 onwards on EXT_CSD_REV > 4 devices
+	if rev > 4 && year <= 12 {
+		cid.ManufacturingYear = MMC_MDT_BASE_YEAR_REV + year
+	} else {
+		cid.ManufacturingYear = MMC_MDT_BASE_YEAR + year
+	}
+
+	return
+}
+
+// parseCardCID updates the card information with the last read CID register.
+func (hw *USDHC) parseCardCID() {
+	var rev uint8
+
+	if hw.card.MMC && len(hw.extCSD) > EXT_CSD_REV {
+		rev = hw.extCSD[EXT_CSD_REV]
+	}
+
+	hw.card.CID = parseCID(hw.cid, hw.card.MMC, rev)
+}
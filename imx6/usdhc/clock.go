@@ -0,0 +1,220 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"fmt"
+
+	"github.com/f-secure-foundry/tamago/bits"
+	"github.com/f-secure-foundry/tamago/imx6"
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// Clock constants
+const (
+	// maximum card identification frequency (4.2 Card Identification
+	// Mode, SD-PL-7.10)
+	ID_FREQ = 400000
+
+	// maximum SD High Speed mode frequency (3.9 Bus Speed Modes,
+	// SD-PL-7.10)
+	SD_HS_FREQ = 50000000
+	// maximum SD UHS-I SDR50 mode frequency (3.9 Bus Speed Modes,
+	// SD-PL-7.10)
+	SD_SDR50_FREQ = 100000000
+	// maximum SD UHS-I SDR104 mode frequency (3.9 Bus Speed Modes,
+	// SD-PL-7.10)
+	SD_SDR104_FREQ = 208000000
+	// maximum eMMC High Speed mode frequency (5.3.2 Bus Speed Modes,
+	// JESD84-B51)
+	MMC_HS_FREQ = 52000000
+	// maximum eMMC HS200 mode frequency (5.3.2 Bus Speed Modes,
+	// JESD84-B51)
+	MMC_HS200_FREQ = 200000000
+)
+
+// TRAN_SPEED time values, in tenths, for SD (5.3.2 CSD Register, SD-PL-7.10)
+// and eMMC (7.3.10 TRAN_SPEED [103:96], JESD84-B51) cards.
+var (
+	sdTimeValue  = [16]uint32{0, 10, 12, 13, 15, 20, 25, 30, 35, 40, 45, 50, 55, 60, 70, 80}
+	mmcTimeValue = [16]uint32{0, 10, 12, 13, 15, 20, 26, 30, 35, 40, 45, 52, 55, 60, 70, 80}
+)
+
+// baseClock returns the uSDHC root clock frequency, as derived from the PLL2
+// PFD selected and divided for the controller instance (18.3 CCM Clock Tree,
+// IMX6ULLRM).
+func (hw *USDHC) baseClock() uint32 {
+	var sel, podf int
+	var frac uint32
+
+	switch hw.n {
+	case 1:
+		sel = imx6.CSCMR1_USDHC1_CLK_SEL
+		podf = imx6.CSCDR1_USDHC1_PODF
+	case 2:
+		sel = imx6.CSCMR1_USDHC2_CLK_SEL
+		podf = imx6.CSCDR1_USDHC2_PODF
+	default:
+		return 0
+	}
+
+	if reg.Get(imx6.CCM_CSCMR1, sel, 1) == 1 {
+		frac = reg.Get(imx6.CCM_ANALOG_PFD_528, imx6.PFD0_FRAC, 0b111111)
+	} else {
+		frac = reg.Get(imx6.CCM_ANALOG_PFD_528, imx6.PFD2_FRAC, 0b111111)
+	}
+
+	if frac == 0 {
+		return 0
+	}
+
+	// PFD = PLL2 * 18 / FRAC
+	pfd := uint64(imx6.PLL2_FREQ) * 18 / uint64(frac)
+
+	return uint32(pfd / uint64(reg.Get(imx6.CCM_CSCDR1, podf, 0b111)+1))
+}
+
+// clockDivider returns the divisor (DVS) and prescaler (SDCLKFS) settings
+// which, for the argument base clock, result in the highest card clock
+// frequency not exceeding the argument one, in Single Data Rate mode. The
+// prescaler divides by 1 to 256 in powers of 2, the divisor by 1 to 16.
+func clockDivider(base uint32, freq uint32) (dvs int, sdclkfs int) {
+	best := 0
+
+	for p := 1; p <= 256; p <<= 1 {
+		for d := 1; d <= 16; d++ {
+			// base / (p * d) <= freq
+			if uint64(base) > uint64(freq)*uint64(p*d) {
+				continue
+			}
+
+			if best == 0 || p*d < best {
+				best = p * d
+				dvs = d - 1
+				sdclkfs = p >> 1
+			}
+
+			break
+		}
+	}
+
+	if best == 0 {
+		// slowest possible clock
+		return 0xf, 0x80
+	}
+
+	return
+}
+
+// idClock returns the divisor and prescaler settings for the identification
+// frequency, falling back to DVS_ID and SDCLKFS_ID when the base clock cannot
+// be determined.
+func (hw *USDHC) idClock() (dvs int, sdclkfs int) {
+	base := hw.baseClock()
+
+	if base == 0 {
+		return DVS_ID, SDCLKFS_ID
+	}
+
+	freq := hw.IdentificationFrequency
+
+	if freq == 0 || freq > ID_FREQ {
+		freq = ID_FREQ
+	}
+
+	return clockDivider(base, freq)
+}
+
+// tranSpeed returns the maximum card clock frequency (Hz) encoded in the
+// argument CSD TRAN_SPEED value, 0 is returned for reserved encodings.
+func tranSpeed(val uint32, mmc bool) uint32 {
+	unit := val & 0b111
+	tv := sdTimeValue[(val>>3)&0xf]
+
+	if mmc {
+		tv = mmcTimeValue[(val>>3)&0xf]
+	}
+
+	if unit > 3 {
+		return 0
+	}
+
+	// 100 kbit/s, in tenths
+	rate := uint32(10000)
+
+	for i := uint32(0); i < unit; i++ {
+		rate *= 10
+	}
+
+	return rate * tv
+}
+
+// cardClock returns the card clock frequency (Hz) resulting from the argument
+// divisor and prescaler settings, 0 is returned if the base clock cannot be
+// determined.
+func (hw *USDHC) cardClock(dvs int, sdclkfs int, ddr bool) uint32 {
+	base := hw.baseClock()
+	pre := uint32(1)
+
+	if sdclkfs > 0 {
+		pre = uint32(sdclkfs) * 2
+	}
+
+	if ddr {
+		// the prescaler divides by twice its value in Dual Data Rate mode
+		pre *= 2
+	}
+
+	return base / (pre * uint32(dvs+1))
+}
+
+// verifyClock returns an error if the card clock resulting from the argument
+// divisor and prescaler settings exceeds the argument maximum frequency (Hz)
+// supported by the card.
+func (hw *USDHC) verifyClock(dvs int, sdclkfs int, ddr bool, max uint32) error {
+	if max == 0 {
+		return fmt.Errorf("invalid card maximum frequency")
+	}
+
+	freq := hw.cardClock(dvs, sdclkfs, ddr)
+
+	if freq > max {
+		return fmt.Errorf("card clock %d Hz exceeds card maximum frequency %d Hz", freq, max)
+	}
+
+	return nil
+}
+
+// clock returns the current card clock frequency (Hz), as derived from the
+// uSDHC root clock and the programmed divisor and prescaler, or 0 when the
+// clock is not enabled.
+func (hw *USDHC) clock() uint32 {
+	if hw.cg == 0 || reg.Get(hw.sys_ctrl, SYS_CTRL_SDCLKEN, 1) != 1 {
+		return 0
+	}
+
+	sys := reg.Read(hw.sys_ctrl)
+	dvs := int(bits.Get(&sys, SYS_CTRL_DVS, 0xf))
+	sdclkfs := int(bits.Get(&sys, SYS_CTRL_SDCLKFS, 0xff))
+
+	return hw.cardClock(dvs, sdclkfs, reg.Get(hw.mix_ctrl, MIX_CTRL_DDR_EN, 1) == 1)
+}
+
+// Clock returns the current card clock frequency (Hz), accounting for Dual
+// Data Rate mode, as programmed in the controller. It allows to verify the
+// bus speed mode selected during card detection.
+func (hw *USDHC) Clock() uint32 {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.clock()
+}
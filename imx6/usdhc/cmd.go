@@ -12,6 +12,7 @@
 package usdhc
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -29,80 +30,201 @@ const (
 
 	// p131, Table 4-42 : Card Status, SD-PL-7.10
 	// p160, Table 68 - Device Status, JESD84-B51
-	STATUS_CURRENT_STATE = 9
-	STATUS_APP_CMD       = 5
-	CURRENT_STATE_IDENT  = 2
-	CURRENT_STATE_TRAN   = 4
+	STATUS_OUT_OF_RANGE       = 31
+	STATUS_ADDRESS_ERROR      = 30
+	STATUS_BLOCK_LEN_ERROR    = 29
+	STATUS_ERASE_SEQ_ERROR    = 28
+	STATUS_ERASE_PARAM        = 27
+	STATUS_WP_VIOLATION       = 26
+	STATUS_CARD_IS_LOCKED     = 25
+	STATUS_LOCK_UNLOCK_FAILED = 24
+	STATUS_COM_CRC_ERROR      = 23
+	STATUS_ILLEGAL_COMMAND    = 22
+	STATUS_CARD_ECC_FAILED    = 21
+	STATUS_CC_ERROR           = 20
+	STATUS_ERROR              = 19
+	STATUS_UNDERRUN           = 18
+	STATUS_OVERRUN            = 17
+	STATUS_CSD_OVERWRITE      = 16
+	STATUS_WP_ERASE_SKIP      = 15
+	STATUS_CARD_ECC_DISABLED  = 14
+	STATUS_ERASE_RESET        = 13
+	STATUS_CURRENT_STATE      = 9
+	STATUS_READY_FOR_DATA     = 8
+	STATUS_SWITCH_ERROR       = 7
+	STATUS_EXCEPTION_EVENT    = 6
+	STATUS_APP_CMD            = 5
+	STATUS_AKE_SEQ_ERROR      = 3
+
+	// p134, Table 4-43 : Card Status Field/Command - Cross Reference, SD-PL-7.10
+	// p161, Table 69 - Device state transitions, JESD84-B51
+	CURRENT_STATE_IDLE  = 0
+	CURRENT_STATE_READY = 1
+	CURRENT_STATE_IDENT = 2
+	CURRENT_STATE_STBY  = 3
+	CURRENT_STATE_TRAN  = 4
+	CURRENT_STATE_DATA  = 5
+	CURRENT_STATE_RCV   = 6
+	CURRENT_STATE_PRG   = 7
+	CURRENT_STATE_DIS   = 8
+
+	// card status error bits (OUT_OF_RANGE to ERROR, excluding
+	// CARD_IS_LOCKED)
+	STATUS_ERROR_MASK = 0xfdf80000
 
 	WRITE = 0
 	READ  = 1
 
+	// Response types, with respective card responses and applicable
+	// command index (CICEN) and CRC (CCCEN) checks:
+	//
+	//   RSP_NONE          - no response        - no checks
+	//   RSP_136           - R2                 - CRC only
+	//   RSP_48            - R1, R5, R6, R7     - index and CRC
+	//                     - R3, R4             - no checks
+	//   RSP_48_CHECK_BUSY - R1b, R5b           - index and CRC
+	//
+	// Commands without response only signal command completion (CC) once
+	// sent, which is therefore the only completion event waited for.
 	RSP_NONE          = 0b00
 	RSP_136           = 0b01
 	RSP_48            = 0b10
 	RSP_48_CHECK_BUSY = 0b11
 
+	// Response register layout, for RSP_136 responses the uSDHC maps
+	// response bits R[127:8], least significant word first, as follows
+	// (see uSDHCx_CMD_RSP0 to uSDHCx_CMD_RSP3 registers, IMX6ULLRM):
+	//
+	//   CMD_RSP3[23:0]  - R[127:104]
+	//   CMD_RSP2[31:0]  - R[103:72]
+	//   CMD_RSP1[31:0]  - R[71:40]
+	//   CMD_RSP0[31:0]  - R[39:8]
+	//
+	// while for RSP_48 responses CMD_RSP0 holds R[39:8] (card status or
+	// OCR) and, for Auto CMD12 responses, CMD_RSP3 holds R[39:8].
+	//
 	// SEND_CSD response contains CSD[127:8],
 	CSD_RSP_OFF = -8
 
 	DEFAULT_CMD_TIMEOUT = 10 * time.Millisecond
+
+	// card status polling interval during data transfers
+	TRANSFER_POLL_INTERVAL = 1 * time.Millisecond
+
+	// default number of retries on transient command errors
+	COMMAND_RETRIES = 3
+	// delay before retrying a command, doubled on each attempt
+	COMMAND_RETRY_DELAY = 1 * time.Millisecond
 )
 
-// cmd sends an SD / MMC command as described in
-// p349, 35.4.3 Send command to card flow chart, IMX6FG
-func (hw *USDHC) cmd(index uint32, dtd uint32, arg uint32, res uint32, cic bool, ccc bool, dma bool, timeout time.Duration) (err error) {
-	if timeout == 0 {
-		timeout = DEFAULT_CMD_TIMEOUT
+// Command errors
+var (
+	// ErrCommandInhibit is returned when the command line is not released
+	// by the controller before issuing a command.
+	ErrCommandInhibit = errors.New("command inhibit")
+	// ErrDataInhibit is returned when the data line is not released by the
+	// controller before issuing a command with data transfer.
+	ErrDataInhibit = errors.New("data inhibit")
+	// ErrWriteCRC is returned when the card reports a CRC error on written
+	// data.
+	ErrWriteCRC = errors.New("write CRC error")
+	// ErrTimeout is returned when a command, or its data transfer, does
+	// not complete in time, such condition is possibly transient.
+	ErrTimeout = errors.New("timeout")
+	// ErrAddressOutOfRange is returned when a data transfer addresses
+	// blocks beyond the card capacity.
+	ErrAddressOutOfRange = errors.New("address out of range")
+	// ErrClockUnstable is returned when the card clock is not stable
+	// before issuing a command.
+	ErrClockUnstable = errors.New("clock not stable")
+	// ErrCommandCRC is returned when a command response fails CRC or end
+	// bit checks, such condition is possibly transient.
+	ErrCommandCRC = errors.New("command CRC error")
+	// ErrAutoCMD12 is returned when the Auto CMD12, stopping a multiple
+	// block transfer, fails along with its software retry, the error
+	// includes the Auto CMD12 Error Status register value.
+	ErrAutoCMD12 = errors.New("Auto CMD12 error")
+)
+
+// waitClock re-enables the card clock, if gated, and waits for it to be
+// stable, as required before issuing the first command after an idle period
+// when clock auto-gating is in use.
+func (hw *USDHC) waitClock(timeout time.Duration) error {
+	if reg.Get(hw.sys_ctrl, SYS_CTRL_SDCLKEN, 1) == 0 {
+		reg.Set(hw.sys_ctrl, SYS_CTRL_SDCLKEN)
 	}
 
-	// clear interrupt status
-	reg.Write(hw.int_status, 0xffffffff)
+	if !reg.WaitFor(timeout, hw.pres_state, PRES_STATE_SDSTB, 1, 1) {
+		return ErrClockUnstable
+	}
 
-	// enable interrupt status
-	reg.Write(hw.int_status_en, 0xffffffff)
+	return nil
+}
 
-	// wait for command inhibit to be clear
+// waitInhibit waits for the command inhibit, and optionally data inhibit,
+// present state bits to be cleared as required before issuing a command
+// (p4026, 58.8.6 Present State (uSDHCx_PRES_STATE), IMX6ULLRM).
+func (hw *USDHC) waitInhibit(data bool, timeout time.Duration) error {
 	if !reg.WaitFor(timeout, hw.pres_state, PRES_STATE_CIHB, 1, 0) {
-		return fmt.Errorf("CMD%d command inhibit", index)
+		return ErrCommandInhibit
 	}
 
-	// wait for data inhibit to be clear
-	if dma && !reg.WaitFor(timeout, hw.pres_state, PRES_STATE_CDIHB, 1, 0) {
-		return fmt.Errorf("CMD%d data inhibit", index)
+	if data && !reg.WaitFor(timeout, hw.pres_state, PRES_STATE_CDIHB, 1, 0) {
+		return ErrDataInhibit
 	}
 
-	// clear interrupts status
-	reg.Write(hw.int_status, 0xffffffff)
+	return nil
+}
 
-	if dtd == WRITE && reg.Get(hw.pres_state, PRES_STATE_WPSPL, 1) == 0 {
-		// The uSDHC merely reports on WP, it doesn't really act on it
-		// despite IMX6ULLRM suggesting otherwise (e.g. p4017).
-		return fmt.Errorf("card is write protected")
+// resetCmdLine performs a software reset of the controller command line
+// circuit (SYS_CTRL RSTC), to recover from command errors.
+func (hw *USDHC) resetCmdLine() error {
+	reg.Set(hw.sys_ctrl, SYS_CTRL_RSTC)
+
+	if !reg.WaitFor(RESET_TIMEOUT, hw.sys_ctrl, SYS_CTRL_RSTC, 1, 0) {
+		return fmt.Errorf("uSDHC%d command line reset timeout", hw.n)
 	}
 
-	defer func() {
-		if err != nil {
-			reg.Clear(hw.pres_state, PRES_STATE_CIHB)
-			reg.Clear(hw.pres_state, PRES_STATE_CDIHB)
-			reg.Set(hw.sys_ctrl, SYS_CTRL_RSTC)
-		}
-	}()
+	return nil
+}
 
-	dmasel := uint32(DMASEL_NONE)
+// resetDataLine performs a software reset of the controller data line
+// circuit (SYS_CTRL RSTD), to recover from data transfer errors, which also
+// aborts any transfer in progress.
+func (hw *USDHC) resetDataLine() error {
+	reg.Set(hw.sys_ctrl, SYS_CTRL_RSTD)
 
-	if dma {
-		dmasel = DMASEL_ADMA2
-		reg.Write(hw.int_signal_en, 0xffffffff)
+	if !reg.WaitFor(RESET_TIMEOUT, hw.sys_ctrl, SYS_CTRL_RSTD, 1, 0) {
+		return fmt.Errorf("uSDHC%d data line reset timeout", hw.n)
 	}
 
-	// select DMA mode
-	reg.SetN(hw.prot_ctrl, PROT_CTRL_DMASEL, 0b11, dmasel)
+	return nil
+}
 
-	// set command arguments
-	reg.Write(hw.cmd_arg, arg)
+// CommandOpts represents the options for sending a command to the card.
+type CommandOpts struct {
+	// Write sets the data transfer direction from host to card
+	Write bool
+	// Response type (RSP_NONE, RSP_136, RSP_48, RSP_48_CHECK_BUSY)
+	Response uint32
+	// CheckIndex enables command index verification on the response
+	CheckIndex bool
+	// CheckCRC enables CRC verification on the response
+	CheckCRC bool
+	// Data indicates that the command is followed by a data transfer
+	Data bool
+	// Timeout for command completion (DEFAULT_CMD_TIMEOUT when 0)
+	Timeout time.Duration
+}
 
-	xfr := reg.Read(hw.cmd_xfr)
-	mix := reg.Read(hw.mix_ctrl)
+// commandTransferType returns the argument Command Transfer Type register
+// (CMD_XFR_TYP) value updated for the argument command index and options.
+//
+// Command index and CRC checks are never enabled on commands without
+// response (RSP_NONE), as there is no response to verify.
+func commandTransferType(xfr uint32, index uint32, opts CommandOpts) uint32 {
+	cic := opts.CheckIndex && opts.Response != RSP_NONE
+	ccc := opts.CheckCRC && opts.Response != RSP_NONE
 
 	// set command index
 	bits.SetN(&xfr, CMD_XFR_TYP_CMDINX, 0b111111, index)
@@ -124,7 +246,95 @@ func (hw *USDHC) cmd(index uint32, dtd uint32, arg uint32, res uint32, cic bool,
 	}
 
 	// set response type
-	bits.SetN(&xfr, CMD_XFR_TYP_RSPTYP, 0b11, res)
+	bits.SetN(&xfr, CMD_XFR_TYP_RSPTYP, 0b11, opts.Response)
+
+	// data presence
+	if opts.Data {
+		bits.Set(&xfr, CMD_XFR_TYP_DPSEL)
+	} else {
+		bits.Clear(&xfr, CMD_XFR_TYP_DPSEL)
+	}
+
+	return xfr
+}
+
+// cmd sends an SD / MMC command as described in
+// p349, 35.4.3 Send command to card flow chart, IMX6FG
+func (hw *USDHC) cmd(index uint32, arg uint32, opts CommandOpts) (err error) {
+	dtd := uint32(READ)
+
+	if opts.Write {
+		dtd = WRITE
+	}
+
+	dma := opts.Data
+	timeout := opts.Timeout
+
+	if timeout == 0 {
+		timeout = DEFAULT_CMD_TIMEOUT
+	}
+
+	// wait for card clock, after any change or un-gating, to be stable
+	if err = hw.waitClock(timeout); err != nil {
+		return fmt.Errorf("CMD%d %w", index, err)
+	}
+
+	// clear interrupt status, except card detection events
+	reg.Write(hw.int_status, ^uint32(CARD_DETECT_EVENTS))
+
+	// enable interrupt status
+	reg.Write(hw.int_status_en, 0xffffffff)
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		// recover the command line, which can be left in an
+		// inconsistent state, for subsequent commands
+		hw.resetCmdLine()
+
+		// recover the data line as well when its inhibit is stuck,
+		// failed transfers are instead recovered by transferSG() once
+		// their state has been reported
+		if errors.Is(err, ErrDataInhibit) {
+			hw.resetDataLine()
+		}
+	}()
+
+	// wait for command (and data) inhibit to be clear
+	if err = hw.waitInhibit(dma, timeout); err != nil {
+		return fmt.Errorf("CMD%d %w", index, err)
+	}
+
+	// clear interrupts status, except card detection events
+	reg.Write(hw.int_status, ^uint32(CARD_DETECT_EVENTS))
+
+	if dtd == WRITE && reg.Get(hw.pres_state, PRES_STATE_WPSPL, 1) == 0 {
+		// The uSDHC merely reports on WP, it doesn't really act on it
+		// despite IMX6ULLRM suggesting otherwise (e.g. p4017).
+		return fmt.Errorf("card is write protected")
+	}
+
+	dmasel := uint32(DMASEL_NONE)
+
+	// data transfers without DMA are performed with programmed I/O
+	pio := dma && hw.pioBuf != nil
+
+	if dma && !pio {
+		dmasel = DMASEL_ADMA2
+		reg.Write(hw.int_signal_en, 0xffffffff)
+	}
+
+	// select DMA mode
+	reg.SetN(hw.prot_ctrl, PROT_CTRL_DMASEL, 0b11, dmasel)
+
+	// set command arguments
+	reg.Write(hw.cmd_arg, arg)
+
+	xfr := commandTransferType(reg.Read(hw.cmd_xfr), index, opts)
+	mix := reg.Read(hw.mix_ctrl)
+
 	// set data transfer direction
 	bits.SetN(&mix, MIX_CTRL_DTDSEL, 1, dtd)
 
@@ -136,18 +346,24 @@ func (hw *USDHC) cmd(index uint32, dtd uint32, arg uint32, res uint32, cic bool,
 	}
 
 	if dma {
-		// enable data presence
-		bits.Set(&xfr, CMD_XFR_TYP_DPSEL)
 		// enable multiple blocks
 		bits.Set(&mix, MIX_CTRL_MSBSEL)
-		// enable automatic CMD12 to stop transactions
-		bits.Set(&mix, MIX_CTRL_AC12EN)
+		// enable automatic CMD12 to stop transactions, unless the block
+		// count is predefined (CMD23)
+		if hw.noAC12 {
+			bits.Clear(&mix, MIX_CTRL_AC12EN)
+		} else {
+			bits.Set(&mix, MIX_CTRL_AC12EN)
+		}
 		// enable block count
 		bits.Set(&mix, MIX_CTRL_BCEN)
 		// enable DMA
-		bits.Set(&mix, MIX_CTRL_DMAEN)
+		if pio {
+			bits.Clear(&mix, MIX_CTRL_DMAEN)
+		} else {
+			bits.Set(&mix, MIX_CTRL_DMAEN)
+		}
 	} else {
-		bits.Clear(&xfr, CMD_XFR_TYP_DPSEL)
 		bits.Clear(&mix, MIX_CTRL_MSBSEL)
 		bits.Clear(&mix, MIX_CTRL_AC12EN)
 		bits.Clear(&mix, MIX_CTRL_BCEN)
@@ -165,25 +381,40 @@ func (hw *USDHC) cmd(index uint32, dtd uint32, arg uint32, res uint32, cic bool,
 		int_status = INT_STATUS_TC
 	}
 
-	// wait for completion
-	if !reg.WaitFor(timeout, hw.int_status, int_status, 1, 1) {
-		err = fmt.Errorf("CMD%d:timeout pres_state:%#x int_status:%#x", index,
-			reg.Read(hw.pres_state),
-			reg.Read(hw.int_status))
-		// according to the IMX6FG flow chart we shouldn't return in
-		// case of error, but still go ahead and check status
+	if pio {
+		err = hw.pioTransfer(dtd, hw.pioBuf, timeout)
+	}
+
+	// wait for completion, unless programmed I/O already failed in which
+	// case go ahead and check status
+	if err == nil {
+		if dma && hw.PollStatus {
+			err = hw.waitTransfer(index, timeout)
+		} else if hw.CardDetect {
+			err = hw.waitCompletion(index, int_status, timeout)
+		} else if !reg.WaitFor(timeout, hw.int_status, int_status, 1, 1) {
+			err = fmt.Errorf("CMD%d:%w pres_state:%#x int_status:%#x", index, ErrTimeout,
+				reg.Read(hw.pres_state),
+				reg.Read(hw.int_status))
+			// according to the IMX6FG flow chart we shouldn't return in
+			// case of error, but still go ahead and check status
+		}
 	}
 
 	// mask all interrupts
 	reg.Write(hw.int_signal_en, 0)
 
+	if errors.Is(err, ErrCardRemoved) {
+		return
+	}
+
 	// read status
 	status := reg.Read(hw.int_status)
 
 	// p3997, 58.5.3.5.4 Auto CMD12 Error, IMX6ULLRM
 	if (status >> 16) == ((1 << INT_STATUS_AC12E) >> 16) {
 		// retry once CMD12 if the Auto one fails
-		if err := hw.cmd(12, WRITE, 0, RSP_NONE, true, true, false, hw.writeTimeout); err == nil {
+		if err := hw.cmd(12, 0, CommandOpts{Write: true, Response: RSP_NONE, CheckIndex: true, CheckCRC: true, Timeout: hw.writeTimeout}); err == nil {
 			bits.Clear(&status, INT_STATUS_AC12E)
 		}
 	}
@@ -195,7 +426,122 @@ func (hw *USDHC) cmd(index uint32, dtd uint32, arg uint32, res uint32, cic bool,
 			msg += fmt.Sprintf(" AC12:%#x", reg.Read(hw.ac12_err_status))
 		}
 
-		err = fmt.Errorf("CMD%d:error %s", index, msg)
+		switch {
+		case dtd == WRITE && bits.Get(&status, INT_STATUS_DCE, 1) == 1:
+			err = fmt.Errorf("CMD%d:error %s, %w", index, msg, ErrWriteCRC)
+		case bits.Get(&status, INT_STATUS_CTOE, 1) == 1 || bits.Get(&status, INT_STATUS_DTOE, 1) == 1:
+			err = fmt.Errorf("CMD%d:error %s, %w", index, msg, ErrTimeout)
+		case bits.Get(&status, INT_STATUS_CCE, 1) == 1 || bits.Get(&status, INT_STATUS_CEBE, 1) == 1:
+			err = fmt.Errorf("CMD%d:error %s, %w", index, msg, ErrCommandCRC)
+		case bits.Get(&status, INT_STATUS_AC12E, 1) == 1:
+			err = fmt.Errorf("CMD%d:error %s, %w", index, msg, ErrAutoCMD12)
+		default:
+			err = fmt.Errorf("CMD%d:error %s", index, msg)
+		}
+	}
+
+	return
+}
+
+// transient returns whether a command error is possibly transient, as
+// reported through interrupt status errors (e.g. timeout or CRC error) rather
+// than by the card logic.
+func transient(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrCommandCRC)
+}
+
+// retry invokes the argument function until it succeeds, fails with a non
+// transient error or the argument number of retries is exhausted, with an
+// exponential backoff starting at COMMAND_RETRY_DELAY.
+func retry(retries int, fn func() error) (err error) {
+	delay := COMMAND_RETRY_DELAY
+
+	for i := 0; ; i++ {
+		if err = fn(); err == nil || !transient(err) || i >= retries {
+			return
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// cmdRetry issues a command, retrying it up to the argument number of times on
+// transient errors.
+func (hw *USDHC) cmdRetry(index uint32, arg uint32, opts CommandOpts, retries int) error {
+	return retry(retries, func() error {
+		return hw.cmd(index, arg, opts)
+	})
+}
+
+// statusDuringTransfer issues CMD13 (SEND_STATUS) while a data transfer is in
+// progress, as allowed when only the command line is free.
+//
+// The data transfer settings (MIX_CTRL) and its interrupt status flags are left
+// untouched.
+func (hw *USDHC) statusDuringTransfer() (status uint32, err error) {
+	var xfr uint32
+
+	if !reg.WaitFor(DEFAULT_CMD_TIMEOUT, hw.pres_state, PRES_STATE_CIHB, 1, 0) {
+		return 0, ErrCommandInhibit
+	}
+
+	// clear command completion and command errors only
+	reg.Write(hw.int_status, 0xf<<INT_STATUS_CTOE|1<<INT_STATUS_CC)
+
+	reg.Write(hw.cmd_arg, hw.rca)
+
+	bits.SetN(&xfr, CMD_XFR_TYP_CMDINX, 0b111111, 13)
+	bits.Set(&xfr, CMD_XFR_TYP_CICEN)
+	bits.Set(&xfr, CMD_XFR_TYP_CCCEN)
+	bits.SetN(&xfr, CMD_XFR_TYP_RSPTYP, 0b11, RSP_48)
+
+	// CMD13 - SEND_STATUS - poll card status
+	reg.Write(hw.cmd_xfr, xfr)
+
+	if !reg.WaitFor(DEFAULT_CMD_TIMEOUT, hw.int_status, INT_STATUS_CC, 1, 1) {
+		return 0, errors.New("CMD13:timeout")
+	}
+
+	if int_status := reg.Get(hw.int_status, INT_STATUS_CTOE, 0xf); int_status != 0 {
+		err = fmt.Errorf("CMD13:error int_status:%#x", int_status<<INT_STATUS_CTOE)
+	}
+
+	reg.Write(hw.int_status, 0xf<<INT_STATUS_CTOE|1<<INT_STATUS_CC)
+
+	return hw.rsp(0), err
+}
+
+// waitTransfer waits for a data transfer completion while polling the card
+// status, aborting the transfer as soon as the card reports an error.
+func (hw *USDHC) waitTransfer(index uint32, timeout time.Duration) (err error) {
+	start := time.Now()
+
+	for !reg.WaitFor(TRANSFER_POLL_INTERVAL, hw.int_status, INT_STATUS_TC, 1, 1) {
+		if time.Since(start) >= timeout {
+			return fmt.Errorf("CMD%d:%w pres_state:%#x int_status:%#x", index, ErrTimeout,
+				reg.Read(hw.pres_state),
+				reg.Read(hw.int_status))
+		}
+
+		if hw.removed() {
+			return hw.abortRemoved(index)
+		}
+
+		status, err := hw.statusDuringTransfer()
+
+		if err != nil {
+			continue
+		}
+
+		if status&STATUS_ERROR_MASK != 0 {
+			// abort transfer, waiting for the data line reset to complete
+			if err = hw.resetDataLine(); err != nil {
+				return fmt.Errorf("CMD%d:aborted %v, %v", index, parseCardStatus(status), err)
+			}
+
+			return fmt.Errorf("CMD%d:aborted %v", index, parseCardStatus(status))
+		}
 	}
 
 	return
@@ -209,9 +555,43 @@ func (hw *USDHC) rsp(i int) uint32 {
 	return reg.Read(hw.cmd_rsp + uint32(i*4))
 }
 
+// response returns all response registers, as used for RSP_136 responses,
+// with the least significant word first.
+func (hw *USDHC) response() (rsp [4]uint32) {
+	for i := range rsp {
+		rsp[i] = hw.rsp(i)
+	}
+
+	return
+}
+
 func (hw *USDHC) rspVal(pos int, mask int) (val uint32) {
-	val = hw.rsp(pos/32) >> (pos % 32)
+	return ResponseField(hw.response(), pos, mask)
+}
+
+// ResponseField extracts a field from a 136-bit (RSP_136) command response, as
+// held in the four uSDHC response registers (CMD_RSP0 to CMD_RSP3) with the
+// least significant word first (see CSD_RSP_OFF).
+//
+// The uSDHC strips the CRC from RSP_136 responses, therefore the field
+// position (of its least significant bit) must be adjusted accordingly when
+// decoding CID/CSD registers (see CSD_RSP_OFF). Fields spanning across
+// response registers are supported.
+func ResponseField(rsp [4]uint32, pos int, mask int) (val uint32) {
+	if pos < 0 || pos >= 128 {
+		return 0
+	}
+
+	i := pos / 32
+	r := uint64(rsp[i])
+
+	if i < 3 {
+		r |= uint64(rsp[i+1]) << 32
+	}
+
+	val = uint32(r >> (pos % 32))
 	val &= uint32(mask)
+
 	return
 }
 
@@ -220,7 +600,7 @@ func (hw *USDHC) waitState(state int, timeout time.Duration) (err error) {
 
 	for {
 		// CMD13 - SEND_STATUS - poll card status
-		if err = hw.cmd(13, READ, hw.rca, RSP_48, true, true, false, hw.writeTimeout); err != nil {
+		if err = hw.cmd(13, hw.rca, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true, Timeout: hw.writeTimeout}); err != nil {
 			continue
 		}
 
@@ -231,9 +611,76 @@ func (hw *USDHC) waitState(state int, timeout time.Duration) (err error) {
 		}
 
 		if time.Since(start) >= timeout {
-			return fmt.Errorf("expected card state %d, got %d", state, curState)
+			return fmt.Errorf("expected card state %s, got %s", StateName(uint32(state)), StateName(curState))
 		}
 	}
 
 	return
 }
+
+// StateName returns the name of a card state, as reported in the
+// CURRENT_STATE field of the card status.
+func StateName(state uint32) string {
+	switch state {
+	case CURRENT_STATE_IDLE:
+		return "idle"
+	case CURRENT_STATE_READY:
+		return "ready"
+	case CURRENT_STATE_IDENT:
+		return "ident"
+	case CURRENT_STATE_STBY:
+		return "stby"
+	case CURRENT_STATE_TRAN:
+		return "tran"
+	case CURRENT_STATE_DATA:
+		return "data"
+	case CURRENT_STATE_RCV:
+		return "rcv"
+	case CURRENT_STATE_PRG:
+		return "prg"
+	case CURRENT_STATE_DIS:
+		return "dis"
+	default:
+		return fmt.Sprintf("unknown (%d)", state)
+	}
+}
+
+// VendorCommand issues an arbitrary command, without data transfer, to the
+// card and returns its response registers. It is meant for manufacturer
+// specific commands (e.g. CMD62/CMD63), application specific ones can be
+// issued by preceding them with CMD55.
+//
+// This function is unsafe as the driver does not track any card state change
+// caused by the command, which might leave the card unusable until the next
+// detection.
+func (hw *USDHC) VendorCommand(index int, arg uint32, rspType int) (rsp [4]uint32, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.cg == 0 {
+		return rsp, errors.New("controller is not initialized")
+	}
+
+	if index < 0 || index > 63 {
+		return rsp, errors.New("invalid command index")
+	}
+
+	var cic, ccc bool
+
+	switch rspType {
+	case RSP_NONE:
+	case RSP_136:
+		ccc = true
+	case RSP_48, RSP_48_CHECK_BUSY:
+		cic = true
+		ccc = true
+	default:
+		return rsp, errors.New("invalid response type")
+	}
+
+	if err = hw.cmd(uint32(index), arg, CommandOpts{Response: uint32(rspType), CheckIndex: cic, CheckCRC: ccc}); err != nil {
+		return
+	}
+
+	return hw.response(), nil
+}
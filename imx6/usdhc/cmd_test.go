@@ -0,0 +1,48 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"testing"
+)
+
+func TestCommandTransferType(t *testing.T) {
+	for _, tc := range []struct {
+		xfr      uint32
+		index    uint32
+		opts     CommandOpts
+		expected uint32
+	}{
+		// CMD0 (GO_IDLE_STATE), checks requested but no response to verify
+		{0, 0, CommandOpts{Response: RSP_NONE, CheckIndex: true, CheckCRC: true}, 0x00000000},
+		// stale checks and data presence from a previous command are cleared
+		{0x3ffb0000, 0, CommandOpts{Response: RSP_NONE, CheckIndex: true, CheckCRC: true}, 0x00000000},
+		// CMD12 (STOP_TRANSMISSION) issued as abort without response
+		{0, 12, CommandOpts{Write: true, Response: RSP_NONE, CheckIndex: true, CheckCRC: true}, 0x0c000000},
+		// CMD2 (ALL_SEND_CID), R2 carries no command index
+		{0, 2, CommandOpts{Response: RSP_136, CheckCRC: true}, 0x02090000},
+		// CMD13 (SEND_STATUS)
+		{0, 13, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}, 0x0d1a0000},
+		// CMD7 (SELECT_CARD) with busy signaling
+		{0, 7, CommandOpts{Response: RSP_48_CHECK_BUSY, CheckIndex: true, CheckCRC: true}, 0x071b0000},
+		// CMD41 (SD_SEND_OP_COND), R3 carries neither index nor CRC
+		{0, 41, CommandOpts{Response: RSP_48}, 0x29020000},
+		// CMD17 (READ_SINGLE_BLOCK)
+		{0, 17, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true, Data: true}, 0x113a0000},
+		// special command type is cleared
+		{0x00c00000, 18, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true, Data: true}, 0x123a0000},
+	} {
+		if res := commandTransferType(tc.xfr, tc.index, tc.opts); res != tc.expected {
+			t.Errorf("commandTransferType(%#x, %d, %+v) = %#x, expected %#x", tc.xfr, tc.index, tc.opts, res, tc.expected)
+		}
+	}
+}
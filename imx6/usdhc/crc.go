@@ -0,0 +1,59 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// Vendor Specific register (58.8 uSDHC Memory Map/Register Definition,
+// IMX6ULLRM).
+const (
+	USDHCx_VEND_SPEC          = 0xc0
+	VEND_SPEC_CONFLICT_CHK_EN = 2
+)
+
+// setCRCConflictCheck configures the data CRC conflict check for the bus
+// speed mode, unless overridden with SetCRCConflictCheck().
+//
+// The check is recommended for legacy modes while, on i.MX uSDHC
+// controllers, it results in spurious CRC errors in tuned modes (SDR104,
+// HS200) and must be disabled.
+func (hw *USDHC) setCRCConflictCheck(tuned bool) {
+	enable := !tuned
+
+	if hw.crcCheckOverride {
+		enable = hw.crcCheck
+	}
+
+	if enable {
+		reg.Set(hw.vend_spec, VEND_SPEC_CONFLICT_CHK_EN)
+	} else {
+		reg.Clear(hw.vend_spec, VEND_SPEC_CONFLICT_CHK_EN)
+	}
+}
+
+// SetCRCConflictCheck enables or disables the controller data CRC conflict
+// check, overriding the setting otherwise automatically applied for each bus
+// speed mode.
+func (hw *USDHC) SetCRCConflictCheck(enable bool) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	hw.crcCheckOverride = true
+	hw.crcCheck = enable
+
+	if hw.cg != 0 {
+		// the override applies regardless of the bus speed mode
+		hw.setCRCConflictCheck(false)
+	}
+}
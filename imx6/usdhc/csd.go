@@ -0,0 +1,114 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"time"
+)
+
+// CSD registers
+const (
+	// 5.3.2 CSD Register (CSD Version 1.0), SD-PL-7.10
+	// 7.3 CSD register, JESD84-B51
+	CSD_TAAC             = 112 + CSD_RSP_OFF
+	CSD_NSAC             = 104 + CSD_RSP_OFF
+	CSD_CCC              = 84 + CSD_RSP_OFF
+	CSD_READ_BL_LEN      = 80 + CSD_RSP_OFF
+	CSD_READ_BL_PARTIAL  = 79 + CSD_RSP_OFF
+	CSD_WRITE_BL_LEN     = 22 + CSD_RSP_OFF
+	CSD_WRITE_BL_PARTIAL = 21 + CSD_RSP_OFF
+
+	// 5.3.2 CSD Register (CSD Version 1.0), SD-PL-7.10
+	SD_CSD_SECTOR_SIZE = 39 + CSD_RSP_OFF
+	SD_CSD_WP_GRP_SIZE = 32 + CSD_RSP_OFF
+
+	// 7.3 CSD register, JESD84-B51
+	MMC_CSD_ERASE_GRP_SIZE = 42 + CSD_RSP_OFF
+	MMC_CSD_ERASE_GRP_MULT = 37 + CSD_RSP_OFF
+	MMC_CSD_WP_GRP_SIZE    = 32 + CSD_RSP_OFF
+)
+
+// CSD represents the Card Specific Data register of SD/MMC cards.
+type CSD struct {
+	// CSD structure version (CSD_STRUCTURE)
+	Structure int
+	// Data read access time, asynchronous part (TAAC)
+	AccessTime time.Duration
+	// Data read access time, in card clock cycles (NSAC * 100)
+	AccessClocks int
+	// Maximum data transfer rate (TRAN_SPEED), as card clock frequency
+	// in Hz
+	TransferRate uint32
+	// Card Command Classes (CCC) bitmap
+	CCC uint16
+	// Erase sector (SD) or erase group (MMC) size in bytes
+	EraseSize int
+	// Write protect group size in bytes
+	WriteProtectGroupSize int
+
+	// Raw response words, least significant first (see ResponseField)
+	Raw [4]uint32
+}
+
+// accessTime decodes the argument CSD TAAC value, its time values are the
+// same for SD (5.3.2 CSD Register, SD-PL-7.10) and eMMC (7.3.5 TAAC [119:112],
+// JESD84-B51) cards, unlike TRAN_SPEED ones.
+func accessTime(val uint32) time.Duration {
+	tv := sdTimeValue[(val>>3)&0xf]
+
+	// 1ns, in tenths
+	t := time.Duration(tv)
+
+	for i := uint32(0); i < val&0b111; i++ {
+		t *= 10
+	}
+
+	return t * time.Nanosecond / 10
+}
+
+// parseCSD decodes the CSD register fields which are common across card
+// types and CSD versions, or relevant to them.
+func parseCSD(rsp [4]uint32, mmc bool) (csd CSD) {
+	csd.Raw = rsp
+	csd.Structure = int(ResponseField(rsp, SD_CSD_STRUCTURE, 0b11))
+	csd.AccessTime = accessTime(ResponseField(rsp, CSD_TAAC, 0xff))
+	csd.AccessClocks = int(ResponseField(rsp, CSD_NSAC, 0xff)) * 100
+	csd.TransferRate = tranSpeed(ResponseField(rsp, SD_CSD_TRAN_SPEED, 0xff), mmc)
+	csd.CCC = uint16(ResponseField(rsp, CSD_CCC, 0xfff))
+
+	blockSize := 1 << ResponseField(rsp, CSD_WRITE_BL_LEN, 0xf)
+
+	if mmc {
+		size := ResponseField(rsp, MMC_CSD_ERASE_GRP_SIZE, 0x1f) + 1
+		mult := ResponseField(rsp, MMC_CSD_ERASE_GRP_MULT, 0x1f) + 1
+		wp := ResponseField(rsp, MMC_CSD_WP_GRP_SIZE, 0x1f) + 1
+
+		csd.EraseSize = int(size*mult) * blockSize
+		csd.WriteProtectGroupSize = int(wp) * csd.EraseSize
+	} else {
+		size := ResponseField(rsp, SD_CSD_SECTOR_SIZE, 0x7f) + 1
+		wp := ResponseField(rsp, SD_CSD_WP_GRP_SIZE, 0x7f) + 1
+
+		csd.EraseSize = int(size) * blockSize
+		csd.WriteProtectGroupSize = int(wp) * csd.EraseSize
+	}
+
+	return
+}
+
+// CSD returns the decoded Card Specific Data register of the detected card.
+func (hw *USDHC) CSD() CSD {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.csdInfo
+}
@@ -0,0 +1,76 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"github.com/f-secure-foundry/tamago/bits"
+)
+
+// p89, 4.3.10 Switch Function Command, SD-PL-7.10
+const (
+	CURRENT_LIMIT_200MA = 0x0
+	CURRENT_LIMIT_400MA = 0x1
+	CURRENT_LIMIT_600MA = 0x2
+	CURRENT_LIMIT_800MA = 0x3
+
+	// default current limit (mA), the most conservative one
+	DEFAULT_CURRENT_LIMIT = 200
+)
+
+// currentLimits maps function group 4 functions to their current limit (mA).
+var currentLimits = []int{
+	CURRENT_LIMIT_200MA: 200,
+	CURRENT_LIMIT_400MA: 400,
+	CURRENT_LIMIT_600MA: 600,
+	CURRENT_LIMIT_800MA: 800,
+}
+
+// setCurrentLimitSD selects, through CMD6 function group 4, the highest card
+// current limit which is supported by the card and does not exceed the board
+// maximum (see USDHC.MaxCurrent).
+//
+// The current limit only applies to UHS-I SDR50, SDR104 and DDR50 modes, it
+// must therefore be set before switching to any of them.
+func (hw *USDHC) setCurrentLimitSD() (err error) {
+	max := hw.MaxCurrent
+
+	if max == 0 {
+		max = DEFAULT_CURRENT_LIMIT
+	}
+
+	status, err := hw.switchFunctionSD(MODE_CHECK, SD_SWITCH_CURRENT_LIMIT, 0xf)
+
+	if err != nil {
+		return
+	}
+
+	support := uint32(status[SD_SWITCH_STATUS_CURRENT_LIMIT_SUPPORT])<<8 | uint32(status[SD_SWITCH_STATUS_CURRENT_LIMIT_SUPPORT+1])
+	limit := uint32(CURRENT_LIMIT_200MA)
+
+	for fn := len(currentLimits) - 1; fn > CURRENT_LIMIT_200MA; fn-- {
+		if currentLimits[fn] <= max && bits.Get(&support, fn, 1) == 1 {
+			limit = uint32(fn)
+			break
+		}
+	}
+
+	if status, err = hw.switchFunctionSD(MODE_SWITCH, SD_SWITCH_CURRENT_LIMIT, limit); err != nil {
+		return
+	}
+
+	// a selection of 0xf indicates the switch was not performed
+	if fn := uint32(status[SD_SWITCH_STATUS_CURRENT_LIMIT_SELECTION] >> 4); int(fn) < len(currentLimits) {
+		hw.card.CurrentLimit = currentLimits[fn]
+	}
+
+	return
+}
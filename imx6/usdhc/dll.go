@@ -0,0 +1,123 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// DLL registers (58.8 uSDHC Memory Map/Register Definition, IMX6ULLRM).
+const (
+	USDHCx_DLL_CTRL        = 0x60
+	DLL_CTRL_SLV_FORCE_UPD = 2
+	DLL_CTRL_RESET         = 1
+	DLL_CTRL_ENABLE        = 0
+
+	USDHCx_DLL_STATUS = 0x64
+	DLL_STS_REF_LOCK  = 1
+	DLL_STS_SLV_LOCK  = 0
+)
+
+// DLL constants
+const (
+	DLL_LOCK_TIMEOUT = 10 * time.Millisecond
+)
+
+// dllEnabled returns whether the delay line is in use.
+func (hw *USDHC) dllEnabled() bool {
+	return reg.Get(hw.dll_ctrl, DLL_CTRL_ENABLE, 1) == 1
+}
+
+// dllLocked returns whether both the reference and slave delay lines are
+// locked.
+func (hw *USDHC) dllLocked() bool {
+	return reg.Get(hw.dll_status, DLL_STS_REF_LOCK, 1) == 1 &&
+		reg.Get(hw.dll_status, DLL_STS_SLV_LOCK, 1) == 1
+}
+
+// DLLLocked returns whether the controller delay line (DLL), when in use, is
+// locked. A lock loss, caused by temperature or voltage drift, results in CRC
+// errors on high speed data transfers.
+func (hw *USDHC) DLLLocked() bool {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return !hw.dllEnabled() || hw.dllLocked()
+}
+
+// relockDLL resets the delay line and waits for it to lock again, falling
+// back to Single Data Rate mode when that fails.
+func (hw *USDHC) relockDLL() (err error) {
+	reg.Set(hw.dll_ctrl, DLL_CTRL_RESET)
+	reg.Clear(hw.dll_ctrl, DLL_CTRL_RESET)
+
+	reg.Set(hw.dll_ctrl, DLL_CTRL_ENABLE)
+	reg.Set(hw.dll_ctrl, DLL_CTRL_SLV_FORCE_UPD)
+	reg.Clear(hw.dll_ctrl, DLL_CTRL_SLV_FORCE_UPD)
+
+	if reg.WaitFor(DLL_LOCK_TIMEOUT, hw.dll_status, DLL_STS_REF_LOCK, 1, 1) &&
+		reg.WaitFor(DLL_LOCK_TIMEOUT, hw.dll_status, DLL_STS_SLV_LOCK, 1, 1) {
+		return
+	}
+
+	if !hw.card.DDR {
+		return errors.New("DLL lock timeout")
+	}
+
+	return hw.disableDDR()
+}
+
+// disableDDR switches the card and controller from Dual Data Rate to Single
+// Data Rate mode.
+func (hw *USDHC) disableDDR() (err error) {
+	if hw.card.MMC {
+		if err = hw.setBusWidthMMC(hw.card.Width, false); err != nil {
+			return
+		}
+	}
+
+	// clear clock
+	hw.setClock(0, 0)
+	// set high speed frequency
+	hw.setClock(DVS_HS, SDCLKFS_HS_SDR)
+
+	hw.card.DDR = false
+
+	return
+}
+
+// recoverDLL is invoked on transfer errors to re-lock the delay line, if in
+// use and unlocked.
+func (hw *USDHC) recoverDLL() (err error) {
+	if !hw.dllEnabled() || hw.dllLocked() {
+		return
+	}
+
+	return hw.relockDLL()
+}
+
+// RelockDLL resets the controller delay line (DLL), if in use, and waits for
+// it to lock again. When the delay line fails to lock the card is switched
+// from Dual Data Rate to Single Data Rate mode, if applicable.
+func (hw *USDHC) RelockDLL() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.dllEnabled() {
+		return
+	}
+
+	return hw.relockDLL()
+}
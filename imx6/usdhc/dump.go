@@ -0,0 +1,104 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/f-secure-foundry/tamago/bits"
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// DumpRegisters returns a textual dump of controller registers, negotiated
+// card settings and decoded card registers, for diagnostic purposes.
+//
+// Card registers are reported as read during card detection (or, for the eMMC
+// Extended CSD and SD Status, as last read) therefore no command is issued to
+// the card.
+func (hw *USDHC) DumpRegisters() string {
+	var buf bytes.Buffer
+
+	hw.Lock()
+	defer hw.Unlock()
+
+	fmt.Fprintf(&buf, "uSDHC%d\n", hw.n)
+
+	if hw.cg == 0 {
+		fmt.Fprintf(&buf, "  controller is not initialized\n")
+		return buf.String()
+	}
+
+	sys := reg.Read(hw.sys_ctrl)
+
+	fmt.Fprintf(&buf, "  capabilities:  %#010x\n", reg.Read(hw.host_ctrl_cap))
+	fmt.Fprintf(&buf, "  present state: %#010x\n", reg.Read(hw.pres_state))
+	fmt.Fprintf(&buf, "  protocol ctrl: %#010x\n", reg.Read(hw.prot_ctrl))
+	fmt.Fprintf(&buf, "  system ctrl:   %#010x (DVS:%#x SDCLKFS:%#x)\n", sys,
+		bits.Get(&sys, SYS_CTRL_DVS, 0xf),
+		bits.Get(&sys, SYS_CTRL_SDCLKFS, 0xff))
+	fmt.Fprintf(&buf, "  mixer ctrl:    %#010x\n", reg.Read(hw.mix_ctrl))
+	fmt.Fprintf(&buf, "  DLL status:    %#010x\n", reg.Read(hw.dll_status))
+	fmt.Fprintf(&buf, "  card clock:    %d Hz\n", hw.clock())
+
+	card := hw.card
+
+	switch {
+	case card.SD:
+		fmt.Fprintf(&buf, "  card:          SD")
+	case card.MMC:
+		fmt.Fprintf(&buf, "  card:          MMC")
+	default:
+		fmt.Fprintf(&buf, "  card:          none\n")
+		return buf.String()
+	}
+
+	fmt.Fprintf(&buf, " HC:%v HS:%v DDR:%v width:%d\n", card.HC, card.HS, card.DDR, card.Width)
+	fmt.Fprintf(&buf, "  capacity:      %d blocks of %d bytes\n", card.Blocks, card.BlockSize)
+	fmt.Fprintf(&buf, "  RCA:           %#06x\n", hw.rca>>RCA_ADDR)
+
+	cid := card.CID
+
+	fmt.Fprintf(&buf, "  CID:           MID:%#04x OID:%#06x PNM:%q PRV:%d.%d PSN:%#010x MDT:%d/%02d\n",
+		cid.ManufacturerID, cid.OEMID, cid.ProductName,
+		cid.ProductRevision>>4, cid.ProductRevision&0xf,
+		cid.SerialNumber, cid.ManufacturingYear, cid.ManufacturingMonth)
+
+	csd := hw.csdInfo
+
+	fmt.Fprintf(&buf, "  CSD:           STRUCTURE:%d TAAC:%v NSAC:%d TRAN_SPEED:%d Hz CCC:%#05x erase:%d WP group:%d\n",
+		csd.Structure, csd.AccessTime, csd.AccessClocks, csd.TransferRate,
+		csd.CCC, csd.EraseSize, csd.WriteProtectGroupSize)
+
+	if card.SD {
+		scr := card.SCR
+
+		fmt.Fprintf(&buf, "  SCR:           STRUCTURE:%d spec:%s 1-bit:%v 4-bit:%v erased:%d CMD_SUPPORT:%#x\n",
+			scr.Structure, scr.Spec, scr.BusWidth1, scr.BusWidth4, scr.ErasedValue, scr.CMDSupport)
+	}
+
+	if status := hw.sdStatusInfo; status.Raw != nil {
+		fmt.Fprintf(&buf, "  SD_STATUS:     speed class:%d UHS grade:%d video class:%d AU:%d bytes\n",
+			status.SpeedClass, status.UHSSpeedGrade, status.VideoSpeedClass, status.AUSize)
+	}
+
+	if len(hw.extCSD) > EXT_CSD_BOOT_SIZE_MULT {
+		fmt.Fprintf(&buf, "  EXT_CSD:       REV:%d BUS_WIDTH:%#x HS_TIMING:%#x SEC_COUNT:%d\n",
+			hw.extCSD[EXT_CSD_REV],
+			hw.extCSD[EXT_CSD_BUS_WIDTH],
+			hw.extCSD[EXT_CSD_HS_TIMING],
+			binary.LittleEndian.Uint32(hw.extCSD[EXT_CSD_SEC_COUNT:]))
+	}
+
+	return buf.String()
+}
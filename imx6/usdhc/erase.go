@@ -0,0 +1,471 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/f-secure-foundry/tamago/imx6"
+)
+
+// Erase constants
+const (
+	// 4.3.5 Erase, SD-PL-7.10
+	SD_ERASE = 0x00000000
+
+	// 6.6.9 Erase, JESD84-B51
+	MMC_ERASE        = 0x00000000
+	MMC_TRIM         = 0x00000001
+	MMC_DISCARD      = 0x00000003
+	MMC_SECURE_ERASE = 0x80000000
+
+	// SEC_FEATURE_SUPPORT [231], JESD84-B51
+	SEC_ER_EN    = 0
+	SEC_GB_CL_EN = 4
+	SEC_SANITIZE = 6
+
+	// The sanitize operation has no specified timeout (6.6.24 Sanitize,
+	// JESD84-B51), it can take several minutes on large devices.
+	MMC_SANITIZE_TIMEOUT = 10 * time.Minute
+
+	// TRIM_MULT [232] and ERASE_TIMEOUT_MULT [223] unit, JESD84-B51
+	MMC_ERASE_TIMEOUT_UNIT = 300 * time.Millisecond
+
+	// number of blocks read back for erase verification
+	ERASE_VERIFY_SAMPLES = 16
+)
+
+// ErrEraseAlignment is returned when an eMMC erase range is not aligned to
+// erase groups, which are always erased entirely.
+var ErrEraseAlignment = errors.New("erase range not aligned to erase groups")
+
+// eraseGroupSize returns the eMMC erase group size in blocks, either the high
+// capacity one (HC_ERASE_GRP_SIZE) when enabled (ERASE_GROUP_DEF) or the CSD
+// one (ERASE_GRP_SIZE, ERASE_GRP_MULT).
+func (hw *USDHC) eraseGroupSize() (blocks int, err error) {
+	extCSD, err := hw.extCSDCached()
+
+	if err != nil {
+		return
+	}
+
+	size := hw.csdInfo.EraseSize
+
+	if extCSD[EXT_CSD_ERASE_GROUP_DEF]&1 == 1 {
+		// 7.4.101 HC_ERASE_GRP_SIZE [224], JESD84-B51
+		size = int(extCSD[EXT_CSD_HC_ERASE_GRP_SIZE]) * 512 * 1024
+	}
+
+	if blocks = size / hw.card.BlockSize; blocks == 0 {
+		blocks = 1
+	}
+
+	return
+}
+
+// checkEraseAlignment verifies, on eMMC cards, that the inclusive range
+// between start and end is aligned to erase groups for operations erasing
+// them entirely (ERASE, SECURE_ERASE), as any group partially in range would
+// be erased as a whole.
+func (hw *USDHC) checkEraseAlignment(start int, end int, arg uint32) (err error) {
+	if !hw.card.MMC || (arg != MMC_ERASE && arg != MMC_SECURE_ERASE) {
+		return
+	}
+
+	grp, err := hw.eraseGroupSize()
+
+	if err != nil {
+		return
+	}
+
+	// the last block of the selected partition
+	last := int(hw.partitionSize()/int64(hw.card.BlockSize)) - 1
+
+	// the range can end with the last, possibly incomplete, group
+	if start%grp != 0 || ((end+1)%grp != 0 && end != last) {
+		return fmt.Errorf("%w (%d blocks)", ErrEraseAlignment, grp)
+	}
+
+	return
+}
+
+// erase sends the erase sequence for the blocks in the inclusive range
+// between start and end, with the argument passed to CMD38 (ERASE).
+func (hw *USDHC) erase(start int, end int, arg uint32) (err error) {
+	var startIndex, endIndex uint32
+
+	if hw.cg == 0 {
+		return errors.New("controller is not initialized")
+	}
+
+	if hw.sleeping {
+		return errors.New("card is sleeping")
+	}
+
+	if hw.partitionUncertain {
+		return ErrPartitionUncertain
+	}
+
+	if start < 0 || end < start {
+		return errors.New("invalid erase range")
+	}
+
+	// the range is bound to the selected partition capacity
+	if err = hw.checkRange(start, end-start+1); err != nil {
+		return
+	}
+
+	switch {
+	case hw.card.SD:
+		// CMD32 - ERASE_WR_BLK_START
+		// CMD33 - ERASE_WR_BLK_END
+		startIndex = 32
+		endIndex = 33
+	case hw.card.MMC:
+		// CMD35 - ERASE_GROUP_START
+		// CMD36 - ERASE_GROUP_END
+		startIndex = 35
+		endIndex = 36
+	default:
+		return errors.New("no card detected")
+	}
+
+	if err = hw.checkEraseAlignment(start, end, arg); err != nil {
+		return
+	}
+
+	startAddress := uint32(start)
+	endAddress := uint32(end)
+
+	if !hw.card.HC {
+		// p102, 4.3.14 Command Functional Difference in Card Capacity Types, SD-PL-7.10
+		startAddress *= uint32(hw.card.BlockSize)
+		endAddress *= uint32(hw.card.BlockSize)
+	}
+
+	if hw.MeasureLatency {
+		defer func(start time.Time) { hw.measure(&hw.latencies.Erase, start, err) }(time.Now())
+	}
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, 1*time.Millisecond); err != nil {
+		return
+	}
+
+	if err = hw.cmd(startIndex, startAddress, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	if err = hw.cmd(endIndex, endAddress, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	timeout := hw.eraseTimeout(start, end, arg)
+
+	// CMD38 - ERASE - erase selected blocks
+	if err = hw.cmd(38, arg, CommandOpts{Response: RSP_48_CHECK_BUSY, CheckIndex: true, CheckCRC: true, Timeout: timeout}); err != nil {
+		return
+	}
+
+	return hw.waitStateLong(CURRENT_STATE_TRAN, timeout)
+}
+
+// eraseTimeout returns the timeout for erasing the blocks in the inclusive
+// range between start and end, with the argument passed to CMD38 (ERASE).
+//
+// On eMMC cards timeouts are derived from the last read Extended CSD, per
+// erase group in range: ERASE_TIMEOUT_MULT for erases (scaled by
+// SEC_ERASE_MULT for secure ones) and TRIM_MULT for TRIM and DISCARD. The
+// write timeout is applied per erase group on devices not reporting them, and
+// per block on SD cards.
+func (hw *USDHC) eraseTimeout(start int, end int, arg uint32) time.Duration {
+	if !hw.card.MMC {
+		return hw.writeTimeout * time.Duration(end-start+1)
+	}
+
+	// erase group size in blocks
+	grp := hw.csdInfo.EraseSize / hw.card.BlockSize
+
+	if grp == 0 {
+		grp = 1
+	}
+
+	if len(hw.extCSD) <= EXT_CSD_TRIM_MULT {
+		return hw.writeTimeout * time.Duration(end/grp-start/grp+1)
+	}
+
+	var mult int
+
+	switch arg {
+	case MMC_TRIM, MMC_DISCARD:
+		mult = int(hw.extCSD[EXT_CSD_TRIM_MULT])
+	case MMC_ERASE:
+		mult = int(hw.extCSD[EXT_CSD_ERASE_TIMEOUT_MULT])
+	case MMC_SECURE_ERASE:
+		mult = int(hw.extCSD[EXT_CSD_ERASE_TIMEOUT_MULT])
+
+		if sec := int(hw.extCSD[EXT_CSD_SEC_ERASE_MULT]); sec > 0 {
+			mult *= sec
+		}
+	}
+
+	// high capacity erase group size in blocks (HC_ERASE_GRP_SIZE is in
+	// 512KiB units), the unit of the timeout multipliers
+	if hc := int(hw.extCSD[EXT_CSD_HC_ERASE_GRP_SIZE]) * 512 * 1024 / hw.card.BlockSize; hc > 0 && mult > 0 {
+		grp = hc
+	}
+
+	groups := end/grp - start/grp + 1
+
+	if mult == 0 {
+		return hw.writeTimeout * time.Duration(groups)
+	}
+
+	return MMC_ERASE_TIMEOUT_UNIT * time.Duration(mult*groups)
+}
+
+// erasedValue returns the value of erased memory bytes, as reported by the
+// SD Configuration Register (DATA_STAT_AFTER_ERASE) or eMMC Extended CSD
+// (ERASED_MEM_CONT), known is false when not available.
+func (hw *USDHC) erasedValue() (val byte, known bool) {
+	var one bool
+
+	switch {
+	case hw.card.SD && hw.card.SCR.Raw != 0:
+		one = hw.card.SCR.ErasedValue == 1
+	case hw.card.MMC:
+		extCSD, err := hw.extCSDCached()
+
+		if err != nil {
+			return
+		}
+
+		one = extCSD[EXT_CSD_ERASED_MEM_CONT]&1 == 1
+	default:
+		return
+	}
+
+	if one {
+		val = 0xff
+	}
+
+	return val, true
+}
+
+// verifyErase reads back a sample of blocks in the inclusive range between
+// start and end, returning those which are not uniformly filled with the
+// card erased value. When the erased value is unknown either all 0s or all 1s
+// are accepted for each block.
+func (hw *USDHC) verifyErase(start int, end int) (failed []int, err error) {
+	var lba []int
+
+	hw.Lock()
+	erased, known := hw.erasedValue()
+	hw.Unlock()
+
+	blocks := end - start + 1
+	step := 1
+
+	if blocks > ERASE_VERIFY_SAMPLES {
+		step = (blocks - 1) / (ERASE_VERIFY_SAMPLES - 1)
+	}
+
+	for i := start; i < end; i += step {
+		lba = append(lba, i)
+	}
+
+	lba = append(lba, end)
+
+	ref := make([]byte, hw.card.BlockSize)
+
+	for _, i := range lba {
+		var buf []byte
+
+		if buf, err = hw.ReadBlocks(i, 1); err != nil {
+			return
+		}
+
+		if !known {
+			erased = buf[0]
+
+			if erased != 0x00 && erased != 0xff {
+				failed = append(failed, i)
+				continue
+			}
+		}
+
+		for j := range ref {
+			ref[j] = erased
+		}
+
+		if imx6.ARM.Compare(buf, ref) != -1 {
+			failed = append(failed, i)
+		}
+	}
+
+	return
+}
+
+// Erase erases the blocks in the inclusive range between start and end
+// logical block addresses, waiting for the card to complete the operation.
+//
+// Erased blocks read back as either all 0s or all 1s, depending on the card.
+//
+// On eMMC cards erase groups are always erased entirely, therefore the range
+// must be aligned to them (see ErrEraseAlignment), Trim() can be used for
+// ranges which are not.
+func (hw *USDHC) Erase(start int, end int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	arg := uint32(SD_ERASE)
+
+	if hw.card.MMC {
+		arg = MMC_ERASE
+	}
+
+	return hw.erase(start, end, arg)
+}
+
+// trim sends the erase sequence for TRIM or DISCARD operations on eMMC cards,
+// after verifying their support.
+func (hw *USDHC) trim(start int, end int, arg uint32) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return ErrUnsupported
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	if (extCSD[EXT_CSD_SEC_FEATURE_SUPPORT]>>SEC_GB_CL_EN)&1 != 1 {
+		return ErrUnsupported
+	}
+
+	// DISCARD is only defined from Version 4.5
+	if arg == MMC_DISCARD && extCSD[EXT_CSD_REV] < EXT_CSD_REV_4_5 {
+		return ErrUnsupported
+	}
+
+	return hw.erase(start, end, arg)
+}
+
+// Trim marks the blocks in the inclusive range between start and end logical
+// block addresses for erasure (eMMC TRIM), the card erases them as part of its
+// background operations. Trimmed blocks read back as erased.
+//
+// ErrUnsupported is returned on cards not supporting it.
+func (hw *USDHC) Trim(start int, end int) error {
+	return hw.trim(start, end, MMC_TRIM)
+}
+
+// Discard marks the blocks in the inclusive range between start and end
+// logical block addresses as no longer in use (eMMC DISCARD), unlike Trim()
+// their content is undetermined until written again.
+//
+// ErrUnsupported is returned on cards not supporting it.
+func (hw *USDHC) Discard(start int, end int) error {
+	return hw.trim(start, end, MMC_DISCARD)
+}
+
+// SecureErase erases the blocks in the inclusive range between start and end
+// logical block addresses and verifies, by reading back a sample of them,
+// that they have been wiped.
+//
+// On eMMC cards a secure erase is performed, which requires the range to be
+// aligned to erase groups (see ErrEraseAlignment). Secure erase is obsolete
+// since eMMC Version 4.51, ErrUnsupported is returned on cards not reporting
+// its support (SEC_FEATURE_SUPPORT SEC_ER_EN). On SD cards (which lack a
+// ranged secure erase) a regular erase is performed.
+//
+// The logical block addresses of sampled blocks which failed verification,
+// if any, are returned along with an error.
+func (hw *USDHC) SecureErase(start int, end int) (failed []int, err error) {
+	if err = hw.secureErase(start, end); err != nil {
+		return
+	}
+
+	if failed, err = hw.verifyErase(start, end); err != nil {
+		return
+	}
+
+	if len(failed) > 0 {
+		err = fmt.Errorf("erase verification failed for %d blocks", len(failed))
+	}
+
+	return
+}
+
+// secureErase performs a secure erase on eMMC cards, after verifying its
+// support, or a regular erase on SD cards.
+func (hw *USDHC) secureErase(start int, end int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return hw.erase(start, end, SD_ERASE)
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	if (extCSD[EXT_CSD_SEC_FEATURE_SUPPORT]>>SEC_ER_EN)&1 != 1 {
+		return ErrUnsupported
+	}
+
+	return hw.erase(start, end, MMC_SECURE_ERASE)
+}
+
+// Sanitize physically removes data from the unmapped user address space of
+// eMMC cards (SANITIZE_START), including blocks previously erased, trimmed or
+// discarded, waiting for its completion.
+//
+// The operation can take several minutes, it fails if not completed within
+// the USDHC.SanitizeTimeout duration (MMC_SANITIZE_TIMEOUT when zero).
+//
+// ErrUnsupported is returned on cards not supporting it, including SD cards.
+func (hw *USDHC) Sanitize() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return ErrUnsupported
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	// SANITIZE is only defined from Version 4.5
+	if extCSD[EXT_CSD_REV] < EXT_CSD_REV_4_5 || (extCSD[EXT_CSD_SEC_FEATURE_SUPPORT]>>SEC_SANITIZE)&1 != 1 {
+		return ErrUnsupported
+	}
+
+	timeout := hw.SanitizeTimeout
+
+	if timeout == 0 {
+		timeout = MMC_SANITIZE_TIMEOUT
+	}
+
+	return hw.switchMMCLong(EXT_CSD_SANITIZE_START, 1, timeout)
+}
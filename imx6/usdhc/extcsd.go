@@ -0,0 +1,108 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"encoding/binary"
+)
+
+// Extended CSD registers (7.4 Extended CSD register, JESD84-B51)
+const (
+	EXT_CSD_CACHE_SIZE             = 249
+	EXT_CSD_PRE_EOL_INFO           = 267
+	EXT_CSD_DEVICE_LIFE_TIME_EST_A = 268
+	EXT_CSD_DEVICE_LIFE_TIME_EST_B = 269
+)
+
+// ExtCSDInfo represents a subset of the eMMC Extended CSD register fields.
+type ExtCSDInfo struct {
+	// Extended CSD revision (EXT_CSD_REV)
+	Revision int
+	// Supported bus speed modes bitmap (DEVICE_TYPE, formerly CARD_TYPE)
+	DeviceType uint8
+	// HS400 Enhanced Strobe support (STROBE_SUPPORT)
+	StrobeSupport bool
+	// Device life time estimation, in 10% steps of the estimated
+	// life time (1: 0-10%, ..., 10: 90-100%, 11: exceeded), for type A
+	// (SLC) and B (MLC) memory (DEVICE_LIFE_TIME_EST_TYP_A/B)
+	LifeTimeEstA int
+	LifeTimeEstB int
+	// Pre End of Life information (PRE_EOL_INFO), 1: normal, 2: warning
+	// (80% of reserved blocks consumed), 3: urgent
+	PreEOLInfo int
+	// Boot partition size in bytes (BOOT_SIZE_MULT)
+	BootSize int64
+	// RPMB partition size in bytes (RPMB_SIZE_MULT)
+	RPMBSize int64
+	// Volatile cache size in bytes (CACHE_SIZE)
+	CacheSize int64
+}
+
+// parseExtCSD decodes the eMMC Extended CSD register.
+func parseExtCSD(extCSD []byte) (info ExtCSDInfo) {
+	info.Revision = int(extCSD[EXT_CSD_REV])
+	info.DeviceType = extCSD[EXT_CSD_DEVICE_TYPE]
+	info.StrobeSupport = extCSD[EXT_CSD_STROBE_SUPPORT]&1 == 1
+	info.LifeTimeEstA = int(extCSD[EXT_CSD_DEVICE_LIFE_TIME_EST_A])
+	info.LifeTimeEstB = int(extCSD[EXT_CSD_DEVICE_LIFE_TIME_EST_B])
+	info.PreEOLInfo = int(extCSD[EXT_CSD_PRE_EOL_INFO])
+	info.BootSize = int64(extCSD[EXT_CSD_BOOT_SIZE_MULT]) * BOOT_SIZE_UNIT
+	info.RPMBSize = int64(extCSD[EXT_CSD_RPMB_SIZE_MULT]) * RPMB_SIZE_UNIT
+	info.CacheSize = int64(binary.LittleEndian.Uint32(extCSD[EXT_CSD_CACHE_SIZE:])) * 1024
+
+	return
+}
+
+// extCSDCached returns the last read Extended CSD, reading it only if not
+// previously done.
+func (hw *USDHC) extCSDCached() (extCSD []byte, err error) {
+	if !hw.card.MMC {
+		return nil, ErrUnsupported
+	}
+
+	if len(hw.extCSD) == MMC_DEFAULT_BLOCK_SIZE {
+		return hw.extCSD, nil
+	}
+
+	return hw.readExtCSD()
+}
+
+// ExtCSD returns a copy of the eMMC Extended CSD register, as last read by
+// the driver (e.g. during card detection), the register is read from the
+// card (CMD8) only if not previously done.
+func (hw *USDHC) ExtCSD() (buf []byte, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	extCSD, err := hw.extCSDCached()
+
+	if err != nil {
+		return
+	}
+
+	return append([]byte{}, extCSD...), nil
+}
+
+// ExtCSDInfo returns decoded eMMC Extended CSD register fields, from the
+// register as last read by the driver (see ExtCSD()).
+func (hw *USDHC) ExtCSDInfo() (info ExtCSDInfo, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	extCSD, err := hw.extCSDCached()
+
+	if err != nil {
+		return
+	}
+
+	return parseExtCSD(extCSD), nil
+}
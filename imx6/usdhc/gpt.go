@@ -0,0 +1,235 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"unicode/utf16"
+)
+
+// GUID Partition Table constants (5.3 GUID Partition Table (GPT) Disk Layout,
+// UEFI 2.8).
+const (
+	GPT_SIGNATURE = "EFI PART"
+
+	// header fields
+	GPT_HEADER_SIZE        = 12
+	GPT_HEADER_CRC32       = 16
+	GPT_MY_LBA             = 24
+	GPT_FIRST_USABLE_LBA   = 40
+	GPT_LAST_USABLE_LBA    = 48
+	GPT_DISK_GUID          = 56
+	GPT_ENTRIES_LBA        = 72
+	GPT_ENTRIES            = 80
+	GPT_ENTRY_SIZE         = 84
+	GPT_ENTRIES_CRC32      = 88
+	GPT_HEADER_MIN_SIZE    = 92
+	GPT_ENTRY_MIN_SIZE     = 128
+	GPT_MAX_ENTRIES_SIZE   = 1024 * 1024
+	GPT_PRIMARY_HEADER_LBA = 1
+
+	// partition entry fields
+	GPT_ENTRY_TYPE_GUID   = 0
+	GPT_ENTRY_UNIQUE_GUID = 16
+	GPT_ENTRY_FIRST_LBA   = 32
+	GPT_ENTRY_LAST_LBA    = 40
+	GPT_ENTRY_ATTRIBUTES  = 48
+	GPT_ENTRY_NAME        = 56
+	GPT_ENTRY_NAME_SIZE   = 72
+)
+
+// GUID represents a Globally Unique Identifier, in its on-disk (mixed
+// endian) encoding.
+type GUID [16]byte
+
+// String returns the GUID in its canonical textual representation.
+func (g GUID) String() string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10], g[10:16])
+}
+
+// GPTPartition represents a GUID Partition Table entry, its data is accessed
+// through the embedded Extent.
+type GPTPartition struct {
+	Extent
+
+	// Partition type
+	TypeGUID GUID
+	// Unique partition identifier
+	UniqueGUID GUID
+	// First and last (inclusive) sector addresses
+	FirstLBA uint64
+	LastLBA  uint64
+	// Attribute flags
+	Attributes uint64
+	// Partition name
+	Name string
+}
+
+// gptHeader represents the GPT header fields required to parse partition
+// entries.
+type gptHeader struct {
+	// partition entries array and entry size
+	entries   []byte
+	entrySize int
+	// first and last (inclusive) sector addresses usable by partitions
+	firstUsableLBA uint64
+	lastUsableLBA  uint64
+}
+
+// readGPT reads and verifies the GPT header at the argument sector address,
+// on a device with the argument number of sectors, along with its partition
+// entries array.
+func (d *Blockdev) readGPT(lba int64, sectors int64) (h gptHeader, err error) {
+	hdr := make([]byte, MBR_SECTOR_SIZE)
+
+	if _, err = d.ReadAt(hdr, lba*MBR_SECTOR_SIZE); err != nil {
+		return
+	}
+
+	if string(hdr[0:len(GPT_SIGNATURE)]) != GPT_SIGNATURE {
+		return h, errors.New("invalid GPT signature")
+	}
+
+	size := binary.LittleEndian.Uint32(hdr[GPT_HEADER_SIZE:])
+
+	if size < GPT_HEADER_MIN_SIZE || size > MBR_SECTOR_SIZE {
+		return h, errors.New("invalid GPT header size")
+	}
+
+	crc := binary.LittleEndian.Uint32(hdr[GPT_HEADER_CRC32:])
+	binary.LittleEndian.PutUint32(hdr[GPT_HEADER_CRC32:], 0)
+
+	if crc32.ChecksumIEEE(hdr[0:size]) != crc {
+		return h, errors.New("invalid GPT header CRC32")
+	}
+
+	if binary.LittleEndian.Uint64(hdr[GPT_MY_LBA:]) != uint64(lba) {
+		return h, errors.New("invalid GPT header location")
+	}
+
+	h.firstUsableLBA = binary.LittleEndian.Uint64(hdr[GPT_FIRST_USABLE_LBA:])
+	h.lastUsableLBA = binary.LittleEndian.Uint64(hdr[GPT_LAST_USABLE_LBA:])
+
+	if h.firstUsableLBA > h.lastUsableLBA || h.lastUsableLBA >= uint64(sectors) {
+		return h, errors.New("invalid GPT usable sectors")
+	}
+
+	n := int64(binary.LittleEndian.Uint32(hdr[GPT_ENTRIES:]))
+	entriesLBA := binary.LittleEndian.Uint64(hdr[GPT_ENTRIES_LBA:])
+	h.entrySize = int(binary.LittleEndian.Uint32(hdr[GPT_ENTRY_SIZE:]))
+
+	if entriesLBA >= uint64(sectors) {
+		return h, errors.New("invalid GPT partition entries location")
+	}
+
+	if h.entrySize < GPT_ENTRY_MIN_SIZE || h.entrySize%GPT_ENTRY_MIN_SIZE != 0 || n*int64(h.entrySize) > GPT_MAX_ENTRIES_SIZE {
+		return h, errors.New("invalid GPT partition entries")
+	}
+
+	h.entries = make([]byte, n*int64(h.entrySize))
+
+	if _, err = d.ReadAt(h.entries, int64(entriesLBA)*MBR_SECTOR_SIZE); err != nil {
+		return
+	}
+
+	if crc32.ChecksumIEEE(h.entries) != binary.LittleEndian.Uint32(hdr[GPT_ENTRIES_CRC32:]) {
+		return h, errors.New("invalid GPT partition entries CRC32")
+	}
+
+	return
+}
+
+// GPT parses the GUID Partition Table, returning its non-empty partition
+// entries. The primary header, following the protective MBR, is used unless
+// corrupted, in which case the backup one (at the last sector) is used
+// instead.
+//
+// Partition entries outside the header usable sectors are rejected.
+func (d *Blockdev) GPT() (partitions []*GPTPartition, err error) {
+	mbr, err := d.Partitions()
+
+	if err != nil {
+		return
+	}
+
+	protective := false
+
+	for _, p := range mbr {
+		protective = protective || p.Type == MBR_TYPE_PROTECTIVE
+	}
+
+	if !protective {
+		return nil, errors.New("missing GPT protective MBR")
+	}
+
+	size := d.Size()
+	sectors := size / MBR_SECTOR_SIZE
+	h, err := d.readGPT(GPT_PRIMARY_HEADER_LBA, sectors)
+
+	if err != nil {
+		var e error
+
+		if h, e = d.readGPT(sectors-1, sectors); e != nil {
+			return nil, fmt.Errorf("primary GPT: %v, backup GPT: %v", err, e)
+		}
+
+		err = nil
+	}
+
+	for off := 0; off < len(h.entries); off += h.entrySize {
+		entry := h.entries[off : off+h.entrySize]
+
+		p := &GPTPartition{
+			FirstLBA:   binary.LittleEndian.Uint64(entry[GPT_ENTRY_FIRST_LBA:]),
+			LastLBA:    binary.LittleEndian.Uint64(entry[GPT_ENTRY_LAST_LBA:]),
+			Attributes: binary.LittleEndian.Uint64(entry[GPT_ENTRY_ATTRIBUTES:]),
+		}
+
+		copy(p.TypeGUID[:], entry[GPT_ENTRY_TYPE_GUID:])
+		copy(p.UniqueGUID[:], entry[GPT_ENTRY_UNIQUE_GUID:])
+
+		if p.TypeGUID == (GUID{}) {
+			continue
+		}
+
+		if p.FirstLBA < h.firstUsableLBA || p.LastLBA > h.lastUsableLBA || p.LastLBA < p.FirstLBA {
+			return nil, fmt.Errorf("invalid GPT partition entry %d sectors", off/h.entrySize)
+		}
+
+		name := make([]uint16, 0, GPT_ENTRY_NAME_SIZE/2)
+
+		for i := 0; i < GPT_ENTRY_NAME_SIZE; i += 2 {
+			c := binary.LittleEndian.Uint16(entry[GPT_ENTRY_NAME+i:])
+
+			if c == 0 {
+				break
+			}
+
+			name = append(name, c)
+		}
+
+		p.Name = string(utf16.Decode(name))
+
+		p.Extent = d.extent(int64(p.FirstLBA)*MBR_SECTOR_SIZE, int64(p.LastLBA-p.FirstLBA+1)*MBR_SECTOR_SIZE, size)
+
+		partitions = append(partitions, p)
+	}
+
+	return
+}
@@ -0,0 +1,247 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnsupported is returned when a feature is not supported by the detected
+// card.
+var ErrUnsupported = errors.New("unsupported")
+
+const (
+	// p212, 5.2 CID register, SD-PL-7.10
+	// SEND_CID response contains CID[127:8], as for CSD.
+	CID_MID = 120 + CSD_RSP_OFF
+
+	// p110, 4.3.12 Command System, SD-PL-7.10
+	GEN_CMD_RD = 1
+
+	// DEVICE_LIFE_TIME_EST_TYP_A/B [268/269], JESD84-B51
+	LIFE_TIME_EST_STEP     = 10
+	LIFE_TIME_EST_EXCEEDED = 0x0b
+
+	// PRE_EOL_INFO [267], JESD84-B51
+	PRE_EOL_NORMAL  = 0x01
+	PRE_EOL_WARNING = 0x02
+	PRE_EOL_URGENT  = 0x03
+)
+
+// Health represents wear indicators reported by industrial grade cards, the
+// value of fields not reported by the card vendor is -1.
+type Health struct {
+	// Vendor name
+	Vendor string
+	// Percentage of the rated endurance which has been consumed
+	LifeUsed int
+	// Number of remaining spare blocks
+	SpareBlocks int
+	// Average block erase count
+	EraseCount int
+	// eMMC estimated life time consumed for type A (SLC) and B (MLC)
+	// memory, as the upper bound percentage of the reported 10% range (110
+	// when the estimated life time has been exceeded)
+	LifeTimeA int
+	LifeTimeB int
+	// eMMC reserved blocks consumption state (normal, warning, urgent)
+	PreEOL string
+	// Raw vendor specific health data
+	Raw []byte
+}
+
+// HealthDecoder represents a vendor specific method for retrieving card
+// health, through CMD56 (GEN_CMD) in read mode.
+type HealthDecoder struct {
+	// Vendor name
+	Vendor string
+	// CMD56 argument (vendor specific bits, RD/WR is set automatically)
+	Arg uint32
+	// Decode parses the data block returned by CMD56
+	Decode func(buf []byte) (*Health, error)
+}
+
+// HealthDecoders holds vendor specific health decoders indexed by CID
+// Manufacturer ID (MID), it can be extended to support additional vendors.
+var HealthDecoders = map[uint32]*HealthDecoder{
+	// SanDisk industrial
+	0x03: {
+		Vendor: "SanDisk",
+		Arg:    0x00000000,
+		Decode: decodeHealthSanDisk,
+	},
+}
+
+func decodeHealthSanDisk(buf []byte) (*Health, error) {
+	// "DS" or "DW" signature
+	if buf[0] != 'D' || (buf[1] != 'S' && buf[1] != 'W') {
+		return nil, ErrUnsupported
+	}
+
+	return &Health{
+		LifeUsed:    int(buf[8]),
+		SpareBlocks: -1,
+		EraseCount:  -1,
+		LifeTimeA:   -1,
+		LifeTimeB:   -1,
+	}, nil
+}
+
+// lifeTime decodes an eMMC device life time estimation (DEVICE_LIFE_TIME_EST),
+// returning -1 when not defined.
+func lifeTime(est byte) int {
+	if est == 0 || est > LIFE_TIME_EST_EXCEEDED {
+		return -1
+	}
+
+	return int(est) * LIFE_TIME_EST_STEP
+}
+
+// preEOL decodes the eMMC pre End of Life information (PRE_EOL_INFO).
+func preEOL(info byte) string {
+	switch info {
+	case PRE_EOL_NORMAL:
+		return "normal"
+	case PRE_EOL_WARNING:
+		return "warning"
+	case PRE_EOL_URGENT:
+		return "urgent"
+	default:
+		return ""
+	}
+}
+
+// healthMMC returns eMMC wear indicators from the Extended CSD device health
+// fields, defined from Version 5.0.
+func (hw *USDHC) healthMMC() (health *Health, err error) {
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	if extCSD[EXT_CSD_REV] < EXT_CSD_REV_5_0 {
+		return nil, ErrUnsupported
+	}
+
+	health = &Health{
+		SpareBlocks: -1,
+		EraseCount:  -1,
+		LifeTimeA:   lifeTime(extCSD[EXT_CSD_DEVICE_LIFE_TIME_EST_A]),
+		LifeTimeB:   lifeTime(extCSD[EXT_CSD_DEVICE_LIFE_TIME_EST_B]),
+		PreEOL:      preEOL(extCSD[EXT_CSD_PRE_EOL_INFO]),
+		Raw:         append([]byte{}, extCSD[EXT_CSD_PRE_EOL_INFO:EXT_CSD_DEVICE_LIFE_TIME_EST_B+1]...),
+	}
+
+	health.LifeUsed = health.LifeTimeA
+
+	if health.LifeTimeB > health.LifeUsed {
+		health.LifeUsed = health.LifeTimeB
+	}
+
+	return
+}
+
+// genCmd issues CMD56 (GEN_CMD) to transfer a single block of vendor specific
+// data to/from the card.
+func (hw *USDHC) genCmd(write bool, arg uint32, buf []byte) (err error) {
+	dtd := uint32(READ)
+	blockSize := hw.card.BlockSize
+
+	if len(buf) != blockSize {
+		return errors.New("invalid buffer size")
+	}
+
+	if write {
+		dtd = WRITE
+		arg &^= GEN_CMD_RD
+	} else {
+		arg |= GEN_CMD_RD
+	}
+
+	// the transfer completes on the single block count
+	hw.noAC12 = true
+	defer func() { hw.noAC12 = false }()
+
+	// CMD56 - GEN_CMD - general purpose data transfer
+	if err = hw.transferArg(56, dtd, arg, 1, uint32(blockSize), buf); err != nil {
+		return
+	}
+
+	if write {
+		err = hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout)
+	}
+
+	return
+}
+
+// GenCommand issues CMD56 (GEN_CMD) to read (write set to false) or write
+// (write set to true) a single block of vendor specific application data,
+// the buffer size must match the card block size.
+func (hw *USDHC) GenCommand(write bool, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.SD && !hw.card.MMC {
+		return errors.New("no card detected")
+	}
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, 1*time.Millisecond); err != nil {
+		return
+	}
+
+	return hw.genCmd(write, 0, buf)
+}
+
+// Health returns, on a best-effort basis, wear indicators for SD cards of
+// vendors listed in HealthDecoders. On eMMC cards the standard device health
+// fields of the Extended CSD (DEVICE_LIFE_TIME_EST_TYP_A/B, PRE_EOL_INFO) are
+// reported. ErrUnsupported is returned for unrecognized cards.
+func (hw *USDHC) Health() (health *Health, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.card.MMC {
+		return hw.healthMMC()
+	}
+
+	if !hw.card.SD {
+		return nil, ErrUnsupported
+	}
+
+	mid := ResponseField(hw.cid, CID_MID, 0xff)
+	dec, ok := HealthDecoders[mid]
+
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	buf := make([]byte, hw.card.BlockSize)
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, 1*time.Millisecond); err != nil {
+		return
+	}
+
+	if err = hw.genCmd(false, dec.Arg, buf); err != nil {
+		return
+	}
+
+	if health, err = dec.Decode(buf); err != nil {
+		return
+	}
+
+	health.Vendor = dec.Vendor
+	health.Raw = buf
+
+	return
+}
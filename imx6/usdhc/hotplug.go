@@ -0,0 +1,143 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+const (
+	// card detect polling interval
+	CARD_DETECT_POLL_INTERVAL = 100 * time.Millisecond
+
+	// card insertion and removal interrupt status flags, latched between
+	// card detect polls
+	CARD_DETECT_EVENTS = 1<<INT_STATUS_CINS | 1<<INT_STATUS_CRM
+)
+
+// ErrCardRemoved is returned when a command, or its data transfer, is
+// aborted due to card removal, as reported by the card detect signal (see
+// CardDetect).
+var ErrCardRemoved = errors.New("card removed")
+
+// removed returns whether card removal is reported by the card detect
+// signal, when wired.
+func (hw *USDHC) removed() bool {
+	return hw.CardDetect && reg.Get(hw.pres_state, PRES_STATE_CINS, 1) == 0
+}
+
+// abortRemoved aborts the command, and any data transfer, in progress on a
+// removed card.
+func (hw *USDHC) abortRemoved(index uint32) error {
+	hw.resetCmdLine()
+	hw.resetDataLine()
+
+	return fmt.Errorf("CMD%d:%w", index, ErrCardRemoved)
+}
+
+// waitCompletion waits for the argument interrupt status flag, aborting the
+// command as soon as the card is removed.
+func (hw *USDHC) waitCompletion(index uint32, pos int, timeout time.Duration) (err error) {
+	start := time.Now()
+
+	for !reg.WaitFor(TRANSFER_POLL_INTERVAL, hw.int_status, pos, 1, 1) {
+		if hw.removed() {
+			return hw.abortRemoved(index)
+		}
+
+		if time.Since(start) >= timeout {
+			return fmt.Errorf("CMD%d:%w pres_state:%#x int_status:%#x", index, ErrTimeout,
+				reg.Read(hw.pres_state),
+				reg.Read(hw.int_status))
+		}
+	}
+
+	return
+}
+
+// OnCardChange registers a function invoked on card insertion (true) and
+// removal (false), for removable card slots with the card detect signal
+// wired (see CardDetect). A nil function cancels any previous registration.
+//
+// The card detect status is polled every CARD_DETECT_POLL_INTERVAL by a
+// dedicated goroutine, which invokes the function without holding the
+// instance mutex, so that it can call any exported method (e.g. Detect() to
+// initialize an inserted card). Card insertion and removal events occurring
+// between polls, latched by the controller interrupt status (INT_STATUS
+// CINS/CRM), are also notified, so that a quick card swap is not missed.
+//
+// Commands in progress on card removal are aborted with ErrCardRemoved,
+// regardless of any registration.
+func (hw *USDHC) OnCardChange(fn func(present bool)) (err error) {
+	if !hw.CardDetect {
+		return errors.New("card detect signal not available")
+	}
+
+	hw.hotplug.Lock()
+	defer hw.hotplug.Unlock()
+
+	hw.cardChange = fn
+
+	if fn != nil && !hw.monitoring {
+		hw.monitoring = true
+		// discard stale events
+		reg.Write(hw.int_status, CARD_DETECT_EVENTS)
+		go hw.monitorCard(reg.Get(hw.pres_state, PRES_STATE_CINS, 1) == 1)
+	}
+
+	return
+}
+
+// monitorCard polls the card detect status, notifying its transitions to the
+// registered card change function, until its registration is canceled.
+func (hw *USDHC) monitorCard(present bool) {
+	for {
+		time.Sleep(CARD_DETECT_POLL_INTERVAL)
+
+		hw.hotplug.Lock()
+		fn := hw.cardChange
+
+		if fn == nil {
+			hw.monitoring = false
+			hw.hotplug.Unlock()
+			return
+		}
+
+		hw.hotplug.Unlock()
+
+		// clear latched events, before sampling the current status, so
+		// that later ones are reported by the next poll
+		events := reg.Read(hw.int_status) & CARD_DETECT_EVENTS
+		reg.Write(hw.int_status, events)
+
+		p := reg.Get(hw.pres_state, PRES_STATE_CINS, 1) == 1
+
+		if present && events&(1<<INT_STATUS_CRM) != 0 {
+			present = false
+			fn(present)
+		}
+
+		if !present && events&(1<<INT_STATUS_CINS) != 0 {
+			present = true
+			fn(present)
+		}
+
+		if p != present {
+			present = p
+			fn(present)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+// hs200MMC enables eMMC HS200 mode, returning whether it has been selected.
+// The card must be in transfer state with its data bus width already set in
+// Single Data Rate mode.
+//
+// HS200 requires sampling clock tuning, on its failure High Speed timing is
+// restored so that other modes can be selected instead.
+//
+// A.6.4 Bus timing specification in HS200 mode, JESD84-B51
+func (hw *USDHC) hs200MMC() (hs200 bool, err error) {
+	// the mode is not attempted if not allowed by the base clock
+	if hw.verifyClock(DVS_HS200, SDCLKFS_HS200, false, MMC_HS200_FREQ) != nil {
+		return
+	}
+
+	if err = hw.writeCardRegisterMMC(EXT_CSD_HS_TIMING, HS_TIMING_HS200); err != nil {
+		return
+	}
+
+	// clear clock
+	hw.setClock(0, 0)
+	// set HS200 frequency
+	hw.setClock(DVS_HS200, SDCLKFS_HS200)
+
+	// CMD21 - SEND_TUNING_BLOCK - sampling clock tuning
+	if hw.executeTuning(21) == nil {
+		hw.card.HS = true
+		hw.card.HS200 = true
+		return true, nil
+	}
+
+	// restore High Speed timing until another mode is selected
+	hw.setClock(0, 0)
+	hw.setClock(DVS_HS, SDCLKFS_HS_SDR)
+
+	err = hw.writeCardRegisterMMC(EXT_CSD_HS_TIMING, HS_TIMING_HS)
+
+	return
+}
@@ -0,0 +1,69 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"time"
+)
+
+// Latency holds latency measurements for a class of card operations.
+type Latency struct {
+	// number of measured operations
+	Count int
+	// shortest operation
+	Min time.Duration
+	// longest operation
+	Max time.Duration
+	// last operation
+	Last time.Duration
+}
+
+// Latencies holds per operation latency measurements, see
+// USDHC.MeasureLatency.
+type Latencies struct {
+	Read  Latency
+	Write Latency
+	Erase Latency
+}
+
+func (l *Latency) record(start time.Time) {
+	d := time.Since(start)
+
+	if l.Count == 0 || d < l.Min {
+		l.Min = d
+	}
+
+	if d > l.Max {
+		l.Max = d
+	}
+
+	l.Last = d
+	l.Count++
+}
+
+// measure records the latency of an operation started at the argument time,
+// unless it failed.
+func (hw *USDHC) measure(l *Latency, start time.Time, err error) {
+	if err == nil {
+		l.record(start)
+	}
+}
+
+// Latencies returns the latency measurements of successful read, write and
+// erase operations, performed since the controller initialization with
+// MeasureLatency set.
+func (hw *USDHC) Latencies() Latencies {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.latencies
+}
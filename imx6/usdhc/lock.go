@@ -0,0 +1,137 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Card Lock/Unlock constants (4.3.7 Card Lock/Unlock Operation, SD-PL-7.10).
+const (
+	// lock card data structure mode bits
+	LOCK_SET_PWD     = 0
+	LOCK_CLR_PWD     = 1
+	LOCK_LOCK_UNLOCK = 2
+	LOCK_ERASE       = 3
+
+	// maximum password length (PWDS_LEN)
+	LOCK_MAX_PWD_LEN = 16
+)
+
+// ErrCardLocked is returned on data access to a password locked card, until
+// it is unlocked (see UnlockCard()).
+var ErrCardLocked = errors.New("card is locked")
+
+// lockUnlock issues CMD42 (LOCK_UNLOCK) with the lock card data structure
+// built from the argument mode bits and password, which for password changes
+// consists of the old password followed by the new one.
+func (hw *USDHC) lockUnlock(mode uint8, pwd []byte) (err error) {
+	if !hw.card.SD {
+		return errors.New("card lock is only supported on SD cards")
+	}
+
+	max := LOCK_MAX_PWD_LEN
+
+	// password changes carry both old and new passwords
+	if (mode>>LOCK_SET_PWD)&1 == 1 {
+		max *= 2
+	}
+
+	if len(pwd) > max {
+		return fmt.Errorf("password length cannot exceed %d bytes", max)
+	}
+
+	buf := []byte{mode, uint8(len(pwd))}
+	buf = append(buf, pwd...)
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout); err != nil {
+		return
+	}
+
+	// CMD16 - SET_BLOCKLEN - define the lock card data structure length
+	if err = hw.cmd(16, uint32(len(buf)), CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	defer func() {
+		// CMD16 - SET_BLOCKLEN - restore the block length
+		if e := hw.cmd(16, uint32(hw.card.BlockSize), CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err == nil {
+			err = e
+		}
+	}()
+
+	// the transfer completes on the single block count
+	hw.noAC12 = true
+	defer func() { hw.noAC12 = false }()
+
+	// CMD42 - LOCK_UNLOCK - set/reset password or lock/unlock the card
+	if err = hw.transferArg(42, WRITE, 0, 1, uint32(len(buf)), buf); err != nil {
+		return
+	}
+
+	// wait for programming completion
+	if err = hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout); err != nil {
+		return
+	}
+
+	status := hw.rsp(0)
+	hw.card.Locked = (status>>STATUS_CARD_IS_LOCKED)&1 == 1
+
+	if (status>>STATUS_LOCK_UNLOCK_FAILED)&1 == 1 {
+		return errors.New("card lock/unlock failed")
+	}
+
+	return
+}
+
+// SetPassword sets, or replaces, the SD card password with the argument one,
+// the current password must be passed as old when already set (nil
+// otherwise). Passwords are limited to LOCK_MAX_PWD_LEN bytes.
+func (hw *USDHC) SetPassword(old []byte, password []byte) (err error) {
+	if len(old) > LOCK_MAX_PWD_LEN || len(password) > LOCK_MAX_PWD_LEN {
+		return fmt.Errorf("password length cannot exceed %d bytes", LOCK_MAX_PWD_LEN)
+	}
+
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.lockUnlock(1<<LOCK_SET_PWD, append(append([]byte{}, old...), password...))
+}
+
+// LockCard locks the SD card with the argument password, which must match
+// the one previously set (see SetPassword()). Once locked, also across power
+// cycles, data access is refused with ErrCardLocked until the card is
+// unlocked.
+func (hw *USDHC) LockCard(password []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.lockUnlock(1<<LOCK_LOCK_UNLOCK, password)
+}
+
+// UnlockCard unlocks the SD card with the argument password.
+func (hw *USDHC) UnlockCard(password []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.lockUnlock(0, password)
+}
+
+// ClearPassword removes the SD card password, which must match the argument
+// one.
+func (hw *USDHC) ClearPassword(password []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.lockUnlock(1<<LOCK_CLR_PWD, password)
+}
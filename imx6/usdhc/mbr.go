@@ -0,0 +1,170 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Master Boot Record constants
+const (
+	MBR_PARTITION_TABLE = 446
+	MBR_PARTITION_SIZE  = 16
+	MBR_PARTITIONS      = 4
+	MBR_SIGNATURE       = 510
+
+	// partition entry fields
+	MBR_STATUS    = 0
+	MBR_TYPE      = 4
+	MBR_FIRST_LBA = 8
+	MBR_SECTORS   = 12
+
+	MBR_STATUS_BOOTABLE = 0x80
+	MBR_TYPE_PROTECTIVE = 0xee
+	MBR_SECTOR_SIZE     = 512
+)
+
+// Extent implements io.ReaderAt and io.WriterAt over a byte range of a
+// Blockdev, accesses are bound to the range.
+type Extent struct {
+	dev   *Blockdev
+	start int64
+	size  int64
+}
+
+// Size returns the size in bytes of the range.
+func (e *Extent) Size() int64 {
+	return e.size
+}
+
+// bound limits an access to the range, returning the error to report when
+// truncated.
+func (e *Extent) bound(p []byte, off int64, truncated error) ([]byte, error) {
+	if off < 0 {
+		return nil, errors.New("negative offset")
+	}
+
+	if off >= e.size {
+		return nil, io.EOF
+	}
+
+	if max := e.size - off; int64(len(p)) > max {
+		return p[0:max], truncated
+	}
+
+	return p, nil
+}
+
+// ReadAt implements io.ReaderAt, the offset is relative to the start of the
+// range.
+func (e *Extent) ReadAt(p []byte, off int64) (n int, err error) {
+	if p, err = e.bound(p, off, io.EOF); p == nil {
+		return
+	}
+
+	n, rerr := e.dev.ReadAt(p, e.start+off)
+
+	if rerr != nil {
+		err = rerr
+	}
+
+	return
+}
+
+// WriteAt implements io.WriterAt, the offset is relative to the start of the
+// range.
+func (e *Extent) WriteAt(p []byte, off int64) (n int, err error) {
+	if p, err = e.bound(p, off, io.ErrUnexpectedEOF); p == nil {
+		return
+	}
+
+	n, werr := e.dev.WriteAt(p, e.start+off)
+
+	if werr != nil {
+		err = werr
+	}
+
+	return
+}
+
+// extent returns the Extent for a byte range, bound to the argument card
+// capacity.
+func (d *Blockdev) extent(start int64, size int64, capacity int64) Extent {
+	if start > capacity {
+		start = capacity
+	}
+
+	if size > capacity-start {
+		size = capacity - start
+	}
+
+	return Extent{
+		dev:   d,
+		start: start,
+		size:  size,
+	}
+}
+
+// MBRPartition represents a Master Boot Record primary partition entry, its
+// data is accessed through the embedded Extent.
+type MBRPartition struct {
+	Extent
+
+	// Partition type
+	Type uint8
+	// Active (bootable) partition
+	Bootable bool
+	// First sector address
+	FirstLBA uint32
+	// Number of sectors
+	Sectors uint32
+}
+
+// Partitions parses the Master Boot Record, returning its non-empty primary
+// partition entries. Extended partitions are returned as such, without
+// parsing logical partitions within them.
+func (d *Blockdev) Partitions() (partitions []*MBRPartition, err error) {
+	mbr := make([]byte, MBR_SECTOR_SIZE)
+
+	if _, err = d.ReadAt(mbr, 0); err != nil {
+		return
+	}
+
+	if mbr[MBR_SIGNATURE] != 0x55 || mbr[MBR_SIGNATURE+1] != 0xaa {
+		return nil, errors.New("invalid MBR signature")
+	}
+
+	size := d.Size()
+
+	for i := 0; i < MBR_PARTITIONS; i++ {
+		entry := mbr[MBR_PARTITION_TABLE+i*MBR_PARTITION_SIZE:]
+
+		p := &MBRPartition{
+			Type:     entry[MBR_TYPE],
+			Bootable: entry[MBR_STATUS] == MBR_STATUS_BOOTABLE,
+			FirstLBA: binary.LittleEndian.Uint32(entry[MBR_FIRST_LBA:]),
+			Sectors:  binary.LittleEndian.Uint32(entry[MBR_SECTORS:]),
+		}
+
+		if p.Type == 0 || p.Sectors == 0 {
+			continue
+		}
+
+		p.Extent = d.extent(int64(p.FirstLBA)*MBR_SECTOR_SIZE, int64(p.Sectors)*MBR_SECTOR_SIZE, size)
+
+		partitions = append(partitions, p)
+	}
+
+	return
+}
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/f-secure-foundry/tamago/bits"
+	"github.com/f-secure-foundry/tamago/imx6"
 )
 
 // MMC registers
@@ -47,23 +48,70 @@ const (
 	MMC_CSD_TRAN_SPEED  = 96 + CSD_RSP_OFF
 	MMC_CSD_SPEC_VERS   = 122 + CSD_RSP_OFF
 
-	// p186 TRAN_SPEED [103:96], JESD84-B51
-	TRAN_SPEED_26MHZ = 0x32
-
 	// p193, 7.4 Extended CSD register, JESD84-B51
-	EXT_CSD_BUS_WIDTH = 183
-	EXT_CSD_HS_TIMING = 185
-	EXT_CSD_SEC_COUNT = 212
+	EXT_CSD_POWER_OFF_NOTIFICATION  = 34
+	EXT_CSD_GP_SIZE_MULT            = 143
+	EXT_CSD_PARTITION_SETTING       = 155
+	EXT_CSD_SANITIZE_START          = 165
+	EXT_CSD_WR_REL_PARAM            = 166
+	EXT_CSD_WR_REL_SET              = 167
+	EXT_CSD_RPMB_SIZE_MULT          = 168
+	EXT_CSD_ERASE_GROUP_DEF         = 175
+	EXT_CSD_PARTITION_CONFIG        = 179
+	EXT_CSD_ERASED_MEM_CONT         = 181
+	EXT_CSD_BUS_WIDTH               = 183
+	EXT_CSD_STROBE_SUPPORT          = 184
+	EXT_CSD_HS_TIMING               = 185
+	EXT_CSD_REV                     = 192
+	EXT_CSD_DEVICE_TYPE             = 196
+	EXT_CSD_PARTITION_SWITCH_TIME   = 199
+	EXT_CSD_SEC_COUNT               = 212
+	EXT_CSD_SLEEP_NOTIFICATION_TIME = 216
+	EXT_CSD_S_A_TIMEOUT             = 217
+	EXT_CSD_HC_WP_GRP_SIZE          = 221
+	EXT_CSD_ERASE_TIMEOUT_MULT      = 223
+	EXT_CSD_HC_ERASE_GRP_SIZE       = 224
+	EXT_CSD_BOOT_SIZE_MULT          = 226
+	EXT_CSD_SEC_ERASE_MULT          = 230
+	EXT_CSD_SEC_FEATURE_SUPPORT     = 231
+	EXT_CSD_TRIM_MULT               = 232
+	EXT_CSD_GENERIC_CMD6_TIME       = 248
 
 	// p222, 7.4.65 HS_TIMING [185], JESD84-B51
 	HS_TIMING_HS    = 0x1
 	HS_TIMING_HS200 = 0x2
+	HS_TIMING_HS400 = 0x3
+
+	// 7.4.67 BUS_WIDTH [183], JESD84-B51
+	BUS_WIDTH_ENHANCED_STROBE = 7
+
+	// 7.4.53 DEVICE_TYPE [196], JESD84-B51
+	DEVICE_TYPE_HS_26  = 0
+	DEVICE_TYPE_HS_52  = 1
+	DEVICE_TYPE_DDR_52 = 2
+	DEVICE_TYPE_HS200  = 4
+	DEVICE_TYPE_HS400  = 6
+
+	// SPEC_VERS [125:122], 7.3 CSD register, JESD84-B51
+	SPEC_VERS_4 = 4
+
+	// EXT_CSD_REV [192], 7.4 Extended CSD register, JESD84-B51
+	EXT_CSD_REV_4_41 = 5
+	EXT_CSD_REV_4_5  = 6
+	EXT_CSD_REV_5_0  = 7
+	EXT_CSD_REV_5_1  = 8
 )
 
 // MMC constants
 const (
 	MMC_DETECT_TIMEOUT     = 1 * time.Second
 	MMC_DEFAULT_BLOCK_SIZE = 512
+
+	// CMD6 (SWITCH) timeout when not reported by the card
+	MMC_SWITCH_TIMEOUT = 500 * time.Millisecond
+	// GENERIC_CMD6_TIME [248] and PARTITION_SWITCH_TIME [199] unit,
+	// JESD84-B51
+	MMC_SWITCH_TIME_UNIT = 10 * time.Millisecond
 )
 
 // p352, 35.4.6 MMC voltage validation flow chart, IMX6FG
@@ -79,13 +127,14 @@ func (hw *USDHC) voltageValidationMMC() (mmc bool, hc bool) {
 	bits.SetN(&arg, MMC_OCR_VDD_HV_MIN, 0x1ff, 0x1ff)
 
 	// p46, 6.3.1 Device reset to Pre-idle state, JESD84-B51
-	time.Sleep(1 * time.Millisecond)
+	hw.powerUpDelay()
 
+	timeout := hw.detectTimeout(MMC_DETECT_TIMEOUT)
 	start := time.Now()
 
-	for time.Since(start) <= MMC_DETECT_TIMEOUT {
+	for time.Since(start) <= timeout {
 		// CMD1 - SEND_OP_COND - send operating conditions
-		if err := hw.cmd(1, READ, arg, RSP_48, false, false, false, 0); err != nil {
+		if err := hw.cmd(1, arg, CommandOpts{Response: RSP_48}); err != nil {
 			return false, false
 		}
 
@@ -99,13 +148,67 @@ func (hw *USDHC) voltageValidationMMC() (mmc bool, hc bool) {
 			hc = true
 		}
 
+		// retain accepted operating conditions
+		hw.ocr = rsp
+
 		return true, hc
 	}
 
 	return false, false
 }
 
+// switchTimeout returns the maximum duration of a CMD6 (SWITCH) operation on
+// the argument EXT_CSD register, as reported in the last read Extended CSD
+// (PARTITION_SWITCH_TIME for partition switches, GENERIC_CMD6_TIME
+// otherwise), MMC_SWITCH_TIMEOUT is returned when not available.
+func (hw *USDHC) switchTimeout(reg uint32) time.Duration {
+	if len(hw.extCSD) != MMC_DEFAULT_BLOCK_SIZE {
+		return MMC_SWITCH_TIMEOUT
+	}
+
+	var t byte
+
+	switch {
+	case reg == EXT_CSD_PARTITION_CONFIG && hw.extCSD[EXT_CSD_REV] >= EXT_CSD_REV_4_41:
+		t = hw.extCSD[EXT_CSD_PARTITION_SWITCH_TIME]
+	case hw.extCSD[EXT_CSD_REV] >= EXT_CSD_REV_4_5:
+		t = hw.extCSD[EXT_CSD_GENERIC_CMD6_TIME]
+	}
+
+	if t == 0 {
+		return MMC_SWITCH_TIMEOUT
+	}
+
+	return time.Duration(t) * MMC_SWITCH_TIME_UNIT
+}
+
 func (hw *USDHC) writeCardRegisterMMC(reg uint32, val uint32) (err error) {
+	return hw.switchMMC(reg, val, hw.switchTimeout(reg))
+}
+
+// switchMMC writes an EXT_CSD register, waiting for the card to return to
+// tran state within the argument timeout.
+func (hw *USDHC) switchMMC(reg uint32, val uint32, timeout time.Duration) (err error) {
+	if err = hw.switchCmdMMC(reg, val); err != nil {
+		return
+	}
+
+	return hw.waitState(CURRENT_STATE_TRAN, timeout)
+}
+
+// switchMMCLong writes an EXT_CSD register, as switchMMC(), for operations
+// taking long to complete, the card status is polled according to the
+// LongWait backoff policy.
+func (hw *USDHC) switchMMCLong(reg uint32, val uint32, timeout time.Duration) (err error) {
+	if err = hw.switchCmdMMC(reg, val); err != nil {
+		return
+	}
+
+	return hw.waitStateLong(CURRENT_STATE_TRAN, timeout)
+}
+
+// switchCmdMMC issues CMD6 to write an EXT_CSD register.
+func (hw *USDHC) switchCmdMMC(reg uint32, val uint32) (err error) {
 	var arg uint32
 
 	// write MMC_SWITCH_VALUE in register pointed in MMC_SWITCH_INDEX
@@ -116,28 +219,66 @@ func (hw *USDHC) writeCardRegisterMMC(reg uint32, val uint32) (err error) {
 	bits.SetN(&arg, MMC_SWITCH_VALUE, 0xff, val)
 
 	// CMD6 - SWITCH - switch mode of operation
-	err = hw.cmd(6, READ, arg, RSP_48, true, true, false, 0)
+	return hw.cmd(6, arg, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true})
+}
 
-	if err != nil {
+func (hw *USDHC) setBusWidthMMC(width int, ddr bool) (err error) {
+	var bus_width uint32
+
+	// p223, 7.4.67 BUS_WIDTH [183], JESD84-B51
+	switch width {
+	case 1:
+		bus_width = 0
+	case 4:
+		bus_width = 1
+	case 8:
+		bus_width = 2
+	default:
+		return errors.New("unsupported MMC bus width")
+	}
+
+	if ddr {
+		if width == 1 {
+			return errors.New("unsupported MMC bus width in DDR mode")
+		}
+
+		bus_width += 4
+	}
+
+	return hw.writeCardRegisterMMC(EXT_CSD_BUS_WIDTH, bus_width)
+}
+
+// readExtCSD reads the eMMC Extended CSD register.
+func (hw *USDHC) readExtCSD() (extCSD []byte, err error) {
+	extCSD = make([]byte, MMC_DEFAULT_BLOCK_SIZE)
+
+	// CMD8 - SEND_EXT_CSD - read extended device data
+	if err = hw.transferArg(8, READ, 0, 1, MMC_DEFAULT_BLOCK_SIZE, extCSD); err != nil {
 		return
 	}
 
-	// We could use EXT_CSD[GENERIC_CMD6_TIME] for a better tran state
-	// timeout, we rather choose to apply a generic timeout for now (as
-	// most drivers do).
-	return hw.waitState(CURRENT_STATE_TRAN, 500*time.Millisecond)
+	hw.extCSD = append(hw.extCSD[:0], extCSD...)
+
+	// the MMC CID manufacturing date encoding depends on EXT_CSD_REV
+	if hw.card.MMC {
+		hw.parseCardCID()
+	}
+
+	return
 }
 
 // p128, Table 39 — e•MMC internal sizes and related Units / Granularities, JESD84-B51
 func (hw *USDHC) detectCapacityMMC(blockSize int, c_size_mult uint32, c_size uint32, read_bl_len uint32) (err error) {
+	hw.csdInfo = parseCSD(hw.csd, true)
+
 	// density greater than 2GB
 	if c_size > 0xff {
 		// emulation mode is assumed for densities greater than 256GB
+		var extCSD []byte
+
 		hw.card.BlockSize = blockSize
-		extCSD := make([]byte, blockSize)
 
-		// CMD8 - SEND_EXT_CSD - read extended device data
-		if err = hw.transfer(8, READ, 0, 1, uint32(blockSize), extCSD); err != nil {
+		if extCSD, err = hw.readExtCSD(); err != nil {
 			return
 		}
 
@@ -155,53 +296,59 @@ func (hw *USDHC) detectCapacityMMC(blockSize int, c_size_mult uint32, c_size uin
 // p58, 6.4.4 Device identification process, JESD84-B51
 func (hw *USDHC) initMMC() (err error) {
 	var arg uint32
-	var bus_width uint32
 
 	// CMD2 - ALL_SEND_CID - get unique card identification
-	if err = hw.cmd(2, READ, arg, RSP_136, false, true, false, 0); err != nil {
+	if err = hw.cmdRetry(2, arg, CommandOpts{Response: RSP_136, CheckCRC: true}, hw.CommandRetries); err != nil {
 		return
 	}
 
+	hw.cid = hw.response()
+	hw.parseCardCID()
+
 	// Send CMD3 with a chosen RCA, with value greater than 1,
 	// p301, A.6.1 Bus initialization , JESD84-B51.
 	hw.rca = (uint32(hw.n) + 1) << RCA_ADDR
 
 	// CMD3 - SET_RELATIVE_ADDR - set relative card address (RCA),
-	if err = hw.cmd(3, READ, hw.rca, RSP_48, true, true, false, 0); err != nil {
+	if err = hw.cmdRetry(3, hw.rca, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}, hw.CommandRetries); err != nil {
 		return
 	}
 
 	if state := (hw.rsp(0) >> STATUS_CURRENT_STATE) & 0b1111; state != CURRENT_STATE_IDENT {
-		return fmt.Errorf("card not in ident state (%d)", state)
+		return fmt.Errorf("card not in ident state (%s)", StateName(state))
 	}
 
+	hw.phase(&hw.timings.Identification)
+
 	// CMD9 - SEND_CSD - read device data
-	if err = hw.cmd(9, READ, hw.rca, RSP_136, false, true, false, 0); err != nil {
+	if err = hw.cmd(9, hw.rca, CommandOpts{Response: RSP_136, CheckCRC: true}); err != nil {
 		return
 	}
 
+	hw.csd = hw.response()
+
 	// block count multiplier
 	c_size_mult := hw.rspVal(MMC_CSD_C_SIZE_MULT, 0b111)
 	// block count
 	c_size := hw.rspVal(MMC_CSD_C_SIZE, 0xfff)
 	// block size
 	read_bl_len := hw.rspVal(MMC_CSD_READ_BL_LEN, 0xf)
-	// operating frequency
-	mhz := hw.rspVal(MMC_CSD_TRAN_SPEED, 0xff)
+	// maximum operating frequency
+	tran_speed := hw.rspVal(MMC_CSD_TRAN_SPEED, 0xff)
 	// e•MMC specification version
 	ver := hw.rspVal(MMC_CSD_SPEC_VERS, 0xf)
 
-	if mhz == TRAN_SPEED_26MHZ {
-		// clear clock
-		hw.setClock(0, 0)
-		// set operating frequency
-		hw.setClock(DVS_OP, SDCLKFS_OP)
-	} else {
-		return fmt.Errorf("unexpected TRAN_SPEED %#x", mhz)
+	if err = hw.verifyClock(DVS_OP, SDCLKFS_OP, false, tranSpeed(tran_speed, true)); err != nil {
+		return
 	}
 
+	// clear clock
+	hw.setClock(0, 0)
+	// set operating frequency
+	hw.setClock(DVS_OP, SDCLKFS_OP)
+
 	// CMD7 - SELECT/DESELECT CARD - enter transfer state
-	if err = hw.cmd(7, READ, hw.rca, RSP_48_CHECK_BUSY, true, true, false, 0); err != nil {
+	if err = hw.cmd(7, hw.rca, CommandOpts{Response: RSP_48_CHECK_BUSY, CheckIndex: true, CheckCRC: true}); err != nil {
 		return
 	}
 
@@ -211,34 +358,83 @@ func (hw *USDHC) initMMC() (err error) {
 		return
 	}
 
-	// p223, 7.4.67 BUS_WIDTH [183], JESD84-B51
-	switch hw.width {
-	case 4:
-		bus_width = 1
-	case 8:
-		bus_width = 2
-	default:
-		return errors.New("unsupported MMC bus width")
+	hw.card.Width = hw.width
+	err = hw.setBusWidthMMC(hw.card.Width, false)
+
+	if err != nil {
+		return
+	}
+
+	// fall back to a 4-bit data bus when DAT[7:4] are not connected
+	if hw.card.Width == 8 {
+		if e := hw.busTest(8); e != nil {
+			if err = hw.setBusWidthMMC(4, false); err != nil {
+				return
+			}
+
+			if err = hw.setBusWidth(4); err != nil {
+				return
+			}
+
+			hw.card.Width = 4
+
+			if err = hw.busTest(4); err != nil {
+				return fmt.Errorf("%v, %v", e, err)
+			}
+		}
 	}
 
-	err = hw.writeCardRegisterMMC(EXT_CSD_BUS_WIDTH, bus_width)
+	err = hw.detectCapacityMMC(MMC_DEFAULT_BLOCK_SIZE, c_size_mult, c_size, read_bl_len)
 
 	if err != nil {
 		return
 	}
 
-	err = hw.detectCapacityMMC(MMC_DEFAULT_BLOCK_SIZE, c_size_mult, c_size, read_bl_len)
+	hw.phase(&hw.timings.Capacity)
+
+	// The Extended CSD is only available on Version 4.1 or above eMMC
+	// cards.
+	if ver < SPEC_VERS_4 || hw.card.Width == 1 {
+		return
+	}
+
+	extCSD, err := hw.readExtCSD()
 
 	if err != nil {
 		return
 	}
 
-	// Enable High Speed DDR (DDR104) mode only on Version 4.1 or above
-	// eMMC cards.
-	if ver < 4 {
+	features := mmcFeatures(ver, hw.card.Width, extCSD)
+
+	if hw.HS200 && features.HS200 {
+		if hs200, err := hw.hs200MMC(); err != nil || hs200 {
+			return err
+		}
+	}
+
+	if !features.DDR {
+		if features.HS {
+			err = hw.hsMMC()
+		}
+
+		return
+	}
+
+	if err = hw.verifyClock(DVS_HS, SDCLKFS_HS_DDR, true, MMC_HS_FREQ); err != nil {
 		return
 	}
 
+	var ref []byte
+
+	if hw.VerifyDDR {
+		ref = make([]byte, hw.card.BlockSize)
+
+		// read reference data in single data rate mode
+		if err = hw.transfer(18, READ, 0, 1, uint32(hw.card.BlockSize), ref); err != nil {
+			return
+		}
+	}
+
 	// p112, Dual Data Rate mode operation, JESD84-B51
 	err = hw.writeCardRegisterMMC(EXT_CSD_HS_TIMING, HS_TIMING_HS)
 
@@ -246,15 +442,7 @@ func (hw *USDHC) initMMC() (err error) {
 		return
 	}
 
-	// p223, 7.4.67 BUS_WIDTH [183], JESD84-B51
-	switch hw.width {
-	case 4:
-		bus_width = 5
-	case 8:
-		bus_width = 6
-	}
-
-	err = hw.writeCardRegisterMMC(EXT_CSD_BUS_WIDTH, bus_width)
+	err = hw.setBusWidthMMC(hw.card.Width, true)
 
 	if err != nil {
 		return
@@ -268,5 +456,99 @@ func (hw *USDHC) initMMC() (err error) {
 	hw.card.DDR = true
 	hw.card.HS = true
 
+	if hw.VerifyDDR {
+		err = hw.verifyDDR(ref)
+	}
+
+	return
+}
+
+// hsMMC switches the card and controller to High Speed Single Data Rate mode.
+func (hw *USDHC) hsMMC() (err error) {
+	if err = hw.verifyClock(DVS_HS, SDCLKFS_HS_SDR, false, MMC_HS_FREQ); err != nil {
+		return
+	}
+
+	// 6.6.2.1 High Speed mode selection, JESD84-B51
+	if err = hw.writeCardRegisterMMC(EXT_CSD_HS_TIMING, HS_TIMING_HS); err != nil {
+		return
+	}
+
+	// clear clock
+	hw.setClock(0, 0)
+	// set high speed frequency
+	hw.setClock(DVS_HS, SDCLKFS_HS_SDR)
+
+	hw.card.HS = true
+
+	return
+}
+
+// verifyDDR compares data read in Dual Data Rate mode against reference data
+// previously read in Single Data Rate mode, falling back to the latter on
+// mismatch.
+func (hw *USDHC) verifyDDR(ref []byte) (err error) {
+	buf := make([]byte, len(ref))
+
+	if err = hw.transfer(18, READ, 0, 1, uint32(len(ref)), buf); err == nil && imx6.ARM.Compare(buf, ref) == -1 {
+		hw.card.DDRVerified = true
+		return
+	}
+
+	return hw.disableDDR()
+}
+
+// mmcFeatureSet represents eMMC bus speed modes available to the driver.
+type mmcFeatureSet struct {
+	// High Speed (52MHz SDR)
+	HS bool
+	// High Speed Dual Data Rate (52MHz DDR)
+	DDR bool
+	// HS200 (200MHz SDR, 1.8V I/O)
+	HS200 bool
+	// HS400 (200MHz DDR, 1.8V I/O, 8-bit data bus)
+	HS400 bool
+	// HS400 Enhanced Strobe
+	HS400ES bool
+}
+
+// mmcFeatures returns the bus speed modes available for an eMMC card, given
+// its CSD SPEC_VERS, the data bus width and its Extended CSD (nil when not
+// read).
+//
+// High speed modes require Version 4.1 or above (SPEC_VERS 4) devices, which
+// report them in DEVICE_TYPE, and a 4-bit or 8-bit data bus for DDR and HS200.
+// DDR and HS200 are respectively only defined from Version 4.41 and 4.5
+// (EXT_CSD_REV), and are ignored on earlier revisions, HS400 requires Version
+// 5.0 and an 8-bit data bus, its Enhanced Strobe variant Version 5.1.
+//
+// HS400 is only reported, it is never selected by the driver as its data
+// strobe (DS) signal is not sampled by the i.MX6 uSDHC.
+func mmcFeatures(ver uint32, width int, extCSD []byte) (f mmcFeatureSet) {
+	if ver < SPEC_VERS_4 || len(extCSD) <= EXT_CSD_DEVICE_TYPE {
+		return
+	}
+
+	rev := extCSD[EXT_CSD_REV]
+	deviceType := uint32(extCSD[EXT_CSD_DEVICE_TYPE])
+
+	f.HS = bits.Get(&deviceType, DEVICE_TYPE_HS_52, 1) == 1
+
+	if width == 1 {
+		return
+	}
+
+	// Dual Data Rate mode is only enabled if supported at High Speed
+	// frequency.
+	f.DDR = rev >= EXT_CSD_REV_4_41 && bits.Get(&deviceType, DEVICE_TYPE_DDR_52, 1) == 1
+	f.HS200 = rev >= EXT_CSD_REV_4_5 && bits.Get(&deviceType, DEVICE_TYPE_HS200, 1) == 1
+
+	if width != 8 || len(extCSD) <= EXT_CSD_STROBE_SUPPORT {
+		return
+	}
+
+	f.HS400 = rev >= EXT_CSD_REV_5_0 && bits.Get(&deviceType, DEVICE_TYPE_HS400, 1) == 1
+	f.HS400ES = f.HS400 && rev >= EXT_CSD_REV_5_1 && extCSD[EXT_CSD_STROBE_SUPPORT]&1 == 1
+
 	return
 }
@@ -0,0 +1,69 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"testing"
+)
+
+// mockExtCSD returns an Extended CSD register with the argument revision,
+// device type and strobe support.
+func mockExtCSD(rev byte, deviceType byte, strobe byte) []byte {
+	extCSD := make([]byte, MMC_DEFAULT_BLOCK_SIZE)
+
+	extCSD[EXT_CSD_REV] = rev
+	extCSD[EXT_CSD_DEVICE_TYPE] = deviceType
+	extCSD[EXT_CSD_STROBE_SUPPORT] = strobe
+
+	return extCSD
+}
+
+func TestMMCFeatures(t *testing.T) {
+	const (
+		hs     = 1<<DEVICE_TYPE_HS_26 | 1<<DEVICE_TYPE_HS_52
+		ddr    = hs | 1<<DEVICE_TYPE_DDR_52
+		hs200  = ddr | 1<<DEVICE_TYPE_HS200
+		hs400  = hs200 | 1<<DEVICE_TYPE_HS400
+		v4_0   = 0
+		v4_1   = 1
+		v4_3   = 3
+		withES = 1
+	)
+
+	for _, tc := range []struct {
+		name   string
+		ver    uint32
+		width  int
+		extCSD []byte
+		exp    mmcFeatureSet
+	}{
+		{"v3", 3, 8, nil, mmcFeatureSet{}},
+		{"v3 with EXT_CSD", 3, 8, mockExtCSD(v4_0, hs, 0), mmcFeatureSet{}},
+		{"v4.x without EXT_CSD", SPEC_VERS_4, 8, nil, mmcFeatureSet{}},
+		{"v4.0", SPEC_VERS_4, 8, mockExtCSD(v4_0, hs, 0), mmcFeatureSet{HS: true}},
+		{"v4.0 reporting DDR", SPEC_VERS_4, 8, mockExtCSD(v4_0, ddr, 0), mmcFeatureSet{HS: true}},
+		{"v4.1", SPEC_VERS_4, 8, mockExtCSD(v4_1, hs, 0), mmcFeatureSet{HS: true}},
+		{"v4.1 26MHz only", SPEC_VERS_4, 8, mockExtCSD(v4_1, 1<<DEVICE_TYPE_HS_26, 0), mmcFeatureSet{}},
+		{"v4.3 reporting HS200", SPEC_VERS_4, 8, mockExtCSD(v4_3, hs200, 0), mmcFeatureSet{HS: true}},
+		{"v4.41", SPEC_VERS_4, 8, mockExtCSD(EXT_CSD_REV_4_41, ddr, 0), mmcFeatureSet{HS: true, DDR: true}},
+		{"v4.41 1-bit", SPEC_VERS_4, 1, mockExtCSD(EXT_CSD_REV_4_41, ddr, 0), mmcFeatureSet{HS: true}},
+		{"v4.5", SPEC_VERS_4, 4, mockExtCSD(EXT_CSD_REV_4_5, hs200, 0), mmcFeatureSet{HS: true, DDR: true, HS200: true}},
+		{"v5.0", SPEC_VERS_4, 8, mockExtCSD(EXT_CSD_REV_5_0, hs400, withES), mmcFeatureSet{HS: true, DDR: true, HS200: true, HS400: true}},
+		{"v5.0 4-bit", SPEC_VERS_4, 4, mockExtCSD(EXT_CSD_REV_5_0, hs400, 0), mmcFeatureSet{HS: true, DDR: true, HS200: true}},
+		{"v5.1", SPEC_VERS_4, 8, mockExtCSD(EXT_CSD_REV_5_1, hs400, withES), mmcFeatureSet{HS: true, DDR: true, HS200: true, HS400: true, HS400ES: true}},
+		{"v5.1 without ES", SPEC_VERS_4, 8, mockExtCSD(EXT_CSD_REV_5_1, hs400, 0), mmcFeatureSet{HS: true, DDR: true, HS200: true, HS400: true}},
+	} {
+		if res := mmcFeatures(tc.ver, tc.width, tc.extCSD); res != tc.exp {
+			t.Errorf("%s: mmcFeatures() = %+v, expected %+v", tc.name, res, tc.exp)
+		}
+	}
+}
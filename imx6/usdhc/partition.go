@@ -0,0 +1,216 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/f-secure-foundry/tamago/bits"
+)
+
+// Partition sizes units (7.4 Extended CSD register, JESD84-B51).
+const (
+	BOOT_SIZE_UNIT = 128 * 1024
+	RPMB_SIZE_UNIT = 128 * 1024
+	GP_SIZE_UNIT   = 512 * 1024
+)
+
+// Partition access (7.4.69 PARTITION_CONFIG [179], JESD84-B51).
+const (
+	PARTITION_CONFIG_ACCESS = 0
+
+	PARTITION_ACCESS_USER  = 0
+	PARTITION_ACCESS_BOOT1 = 1
+	PARTITION_ACCESS_BOOT2 = 2
+	PARTITION_ACCESS_RPMB  = 3
+	PARTITION_ACCESS_GP1   = 4
+)
+
+// ErrPartitionUncertain is returned on data access after a failed partition
+// switch, until a partition is successfully selected again.
+var ErrPartitionUncertain = errors.New("partition selection uncertain")
+
+// Partition describes a card physical partition.
+type Partition struct {
+	// Name (user, boot1, boot2, rpmb, gp1-gp4)
+	Name string
+	// Size in bytes
+	Size int64
+}
+
+// Partitions returns the physical partitions of the detected card, with
+// their respective sizes. On SD cards only the user area is available, on
+// eMMC cards boot, RPMB and general purpose partitions are also reported when
+// present.
+func (hw *USDHC) Partitions() (partitions []Partition, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.SD && !hw.card.MMC {
+		return nil, errors.New("no card detected")
+	}
+
+	partitions = append(partitions, Partition{
+		Name: "user",
+		Size: int64(hw.card.Blocks) * int64(hw.card.BlockSize),
+	})
+
+	if !hw.card.MMC {
+		return
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	partitions = append(partitions, mmcPartitions(extCSD)...)
+
+	return
+}
+
+// mmcPartitions returns the eMMC boot, RPMB and general purpose partitions
+// described by the Extended CSD register.
+func mmcPartitions(extCSD []byte) (partitions []Partition) {
+	if boot := int64(extCSD[EXT_CSD_BOOT_SIZE_MULT]) * BOOT_SIZE_UNIT; boot > 0 {
+		partitions = append(partitions,
+			Partition{Name: "boot1", Size: boot},
+			Partition{Name: "boot2", Size: boot},
+		)
+	}
+
+	if rpmb := int64(extCSD[EXT_CSD_RPMB_SIZE_MULT]) * RPMB_SIZE_UNIT; rpmb > 0 {
+		partitions = append(partitions, Partition{Name: "rpmb", Size: rpmb})
+	}
+
+	// GP_SIZE_MULT_GP0 - GP_SIZE_MULT_GP3 [154:143], JESD84-B51
+	grp := int64(extCSD[EXT_CSD_HC_WP_GRP_SIZE]) * int64(extCSD[EXT_CSD_HC_ERASE_GRP_SIZE]) * GP_SIZE_UNIT
+
+	for i := 0; i < 4; i++ {
+		off := EXT_CSD_GP_SIZE_MULT + i*3
+		mult := int64(extCSD[off+2])<<16 | int64(extCSD[off+1])<<8 | int64(extCSD[off])
+
+		if gp := mult * grp; gp > 0 {
+			partitions = append(partitions, Partition{Name: fmt.Sprintf("gp%d", i+1), Size: gp})
+		}
+	}
+
+	return
+}
+
+// partitionSize returns the size in bytes of the currently selected physical
+// partition, eMMC partitions other than the user area are sized from the
+// last read Extended CSD register.
+func (hw *USDHC) partitionSize() int64 {
+	if hw.partition == "user" {
+		return int64(hw.card.Blocks) * int64(hw.card.BlockSize)
+	}
+
+	if len(hw.extCSD) != MMC_DEFAULT_BLOCK_SIZE {
+		return 0
+	}
+
+	for _, p := range mmcPartitions(hw.extCSD) {
+		if p.Name == hw.partition {
+			return p.Size
+		}
+	}
+
+	return 0
+}
+
+// partitionAccess returns the PARTITION_ACCESS value for a partition name.
+func partitionAccess(name string) (access uint32, err error) {
+	switch name {
+	case "user":
+		return PARTITION_ACCESS_USER, nil
+	case "boot1":
+		return PARTITION_ACCESS_BOOT1, nil
+	case "boot2":
+		return PARTITION_ACCESS_BOOT2, nil
+	case "rpmb":
+		return PARTITION_ACCESS_RPMB, nil
+	case "gp1", "gp2", "gp3", "gp4":
+		return PARTITION_ACCESS_GP1 + uint32(name[2]-'1'), nil
+	}
+
+	return 0, fmt.Errorf("invalid partition %s", name)
+}
+
+// SelectPartition switches data access to the named eMMC physical partition
+// (see Partitions()). The switch is confirmed by reading back
+// PARTITION_CONFIG, on any failure or mismatch data access is refused, with
+// ErrPartitionUncertain, until a partition is successfully selected.
+func (hw *USDHC) SelectPartition(name string) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.selectPartition(name)
+}
+
+func (hw *USDHC) selectPartition(name string) (err error) {
+	if !hw.card.MMC {
+		return errors.New("partition selection is only supported on MMC cards")
+	}
+
+	access, err := partitionAccess(name)
+
+	if err != nil {
+		return
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	// preserve boot configuration bits
+	config := uint32(extCSD[EXT_CSD_PARTITION_CONFIG])
+	bits.SetN(&config, PARTITION_CONFIG_ACCESS, 0b111, access)
+
+	// from now on the selection is uncertain until confirmed
+	hw.partitionUncertain = true
+
+	if err = hw.writeCardRegisterMMC(EXT_CSD_PARTITION_CONFIG, config); err != nil {
+		return
+	}
+
+	if extCSD, err = hw.readExtCSD(); err != nil {
+		return
+	}
+
+	config = uint32(extCSD[EXT_CSD_PARTITION_CONFIG])
+
+	if current := bits.Get(&config, PARTITION_CONFIG_ACCESS, 0b111); current != access {
+		return fmt.Errorf("partition switch mismatch (PARTITION_ACCESS:%d, expected:%d)", current, access)
+	}
+
+	hw.partition = name
+	hw.partitionUncertain = false
+
+	return
+}
+
+// Partition returns the name of the currently selected physical partition.
+func (hw *USDHC) Partition() (name string, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.partitionUncertain {
+		return "", ErrPartitionUncertain
+	}
+
+	return hw.partition, nil
+}
@@ -0,0 +1,68 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// pioTransfer moves data between the buffer and the controller data port,
+// with programmed I/O, in chunks of the configured watermark level. It does
+// not require DMA memory nor any cache maintenance.
+func (hw *USDHC) pioTransfer(dtd uint32, buf []byte, timeout time.Duration) (err error) {
+	var wml uint32
+	var ready int
+
+	if dtd == WRITE {
+		wml = reg.Get(hw.wtmk_lvl, WTMK_LVL_WR_WML, 0xff)
+		ready = INT_STATUS_BWR
+	} else {
+		wml = reg.Get(hw.wtmk_lvl, WTMK_LVL_RD_WML, 0xff)
+		ready = INT_STATUS_BRR
+	}
+
+	if wml == 0 {
+		wml = 1
+	}
+
+	chunk := int(wml) * 4
+
+	for off := 0; off < len(buf); off += chunk {
+		// wait for buffer read/write ready
+		if !reg.WaitFor(timeout, hw.int_status, ready, 1, 1) {
+			return fmt.Errorf("PIO timeout at offset %d", off)
+		}
+
+		// clear buffer read/write ready
+		reg.Write(hw.int_status, 1<<ready)
+
+		end := off + chunk
+
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		for i := off; i < end; i += 4 {
+			if dtd == WRITE {
+				reg.Write(hw.data_buff, binary.LittleEndian.Uint32(buf[i:]))
+			} else {
+				binary.LittleEndian.PutUint32(buf[i:], reg.Read(hw.data_buff))
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,72 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// ErrPresenceUnknown is returned by Present() when card presence cannot be
+// determined without performing card initialization.
+var ErrPresenceUnknown = errors.New("card presence unknown")
+
+// Present returns whether a card is inserted, without performing card
+// initialization nor altering card or driver state, to allow polling of
+// removable card slots.
+//
+// When the card detect signal is wired (see CardDetect) the controller Card
+// Inserted status (PRES_STATE CINS) is returned. Otherwise an initialized
+// card is addressed with CMD13 (SEND_STATUS), which leaves its state
+// unchanged, while for uninitialized ones ErrPresenceUnknown is returned, as
+// probing them would require a card reset (CMD0) and identification commands
+// (CMD8, CMD1), which are not answered by all card types, altering their
+// state.
+func (hw *USDHC) Present() (present bool, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.cg == 0 {
+		return false, errors.New("controller is not initialized")
+	}
+
+	if hw.CardDetect {
+		return reg.Get(hw.pres_state, PRES_STATE_CINS, 1) == 1, nil
+	}
+
+	if !hw.ready {
+		return false, errors.New("controller is not ready")
+	}
+
+	if !hw.card.SD && !hw.card.MMC {
+		return false, ErrPresenceUnknown
+	}
+
+	// CMD13 - SEND_STATUS - poll card status
+	err = hw.cmd(13, hw.rca, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true})
+
+	return probeResult(err)
+}
+
+// probeResult converts a probe command error to card presence, a command
+// timeout indicates that no card responded.
+func probeResult(err error) (bool, error) {
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrTimeout):
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,214 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/f-secure-foundry/tamago/bits"
+)
+
+// Default Relative Card Addresses probed for cards initialized by a prior boot
+// stage, covering those commonly assigned by boot ROMs and bootloaders to eMMC
+// cards as well as the ones assigned by this driver. SD cards publish their
+// own, arbitrary, RCA and are not covered (see USDHC.ProbeRCAs).
+var probeRCAs = []uint32{1, 2, 3}
+
+// priorConfig holds the controller configuration found at initialization.
+type priorConfig struct {
+	valid bool
+
+	prot uint32
+	mix  uint32
+	sys  uint32
+}
+
+// width returns the data transfer width of the prior configuration.
+func (p *priorConfig) width() int {
+	switch bits.Get(&p.prot, PROT_CTRL_DTW, 0b11) {
+	case 0b01:
+		return 4
+	case 0b10:
+		return 8
+	default:
+		return 1
+	}
+}
+
+// probeRCA looks for a card, already identified, in stand-by or transfer
+// state.
+func (hw *USDHC) probeRCA() (state uint32, err error) {
+	rcas := hw.ProbeRCAs
+
+	if len(rcas) == 0 {
+		rcas = probeRCAs
+	}
+
+	for _, rca := range rcas {
+		hw.rca = rca << RCA_ADDR
+
+		// CMD13 - SEND_STATUS - poll card status
+		if hw.cmd(13, hw.rca, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}) != nil {
+			continue
+		}
+
+		state = (hw.rsp(0) >> STATUS_CURRENT_STATE) & 0b1111
+
+		if state == CURRENT_STATE_STBY || state == CURRENT_STATE_TRAN {
+			return
+		}
+	}
+
+	hw.rca = 0
+
+	return 0, errors.New("no initialized card found")
+}
+
+// ProbeExisting adopts a card left initialized by a prior boot stage (e.g. a
+// boot ROM leaving an eMMC in High Speed mode), rather than performing a
+// fresh identification with Detect().
+//
+// The controller data transfer width, data rate and clock found at Init()
+// are restored, the card is then looked up and its registers read to
+// determine its type, capacity and speed mode. The adopted configuration is
+// returned.
+//
+// The card is looked up at the Relative Card Addresses set in ProbeRCAs,
+// which default to those of eMMC cards, SD cards are only found when their
+// RCA is configured.
+func (hw *USDHC) ProbeExisting() (card CardInfo, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.cg == 0 {
+		return card, errors.New("controller is not initialized")
+	}
+
+	if !hw.prior.valid {
+		return card, errors.New("no prior controller configuration")
+	}
+
+	// clear card information
+	hw.card = CardInfo{}
+	hw.ocr = 0
+	hw.cid = [4]uint32{}
+	hw.csd = [4]uint32{}
+	hw.csdInfo = CSD{}
+	hw.extCSD = nil
+	hw.sdStatusInfo = SDStatus{}
+	hw.sleeping = false
+	hw.partition = "user"
+	hw.partitionUncertain = false
+
+	// restore prior controller configuration
+	width := hw.prior.width()
+
+	if err = hw.setBusWidth(width); err != nil {
+		return
+	}
+
+	hw.card.DDR = bits.Get(&hw.prior.mix, MIX_CTRL_DDR_EN, 1) == 1
+
+	hw.setClock(0, 0)
+	hw.setClock(int(bits.Get(&hw.prior.sys, SYS_CTRL_DVS, 0xf)), int(bits.Get(&hw.prior.sys, SYS_CTRL_SDCLKFS, 0xff)))
+
+	state, err := hw.probeRCA()
+
+	if err != nil {
+		return
+	}
+
+	if state == CURRENT_STATE_TRAN {
+		// CMD7 - SELECT/DESELECT CARD - enter stand-by state
+		if err = hw.cmd(7, 0, CommandOpts{Response: RSP_NONE}); err != nil {
+			return
+		}
+
+		if err = hw.waitState(CURRENT_STATE_STBY, 1*time.Millisecond); err != nil {
+			return
+		}
+	}
+
+	// CMD9 - SEND_CSD - read device data
+	if err = hw.cmd(9, hw.rca, CommandOpts{Response: RSP_136, CheckCRC: true}); err != nil {
+		return
+	}
+
+	hw.csd = hw.response()
+
+	// CMD10 - SEND_CID - read card identification
+	if err = hw.cmd(10, hw.rca, CommandOpts{Response: RSP_136, CheckCRC: true}); err != nil {
+		return
+	}
+
+	hw.cid = hw.response()
+
+	// CMD7 - SELECT/DESELECT CARD - enter transfer state
+	if err = hw.cmd(7, hw.rca, CommandOpts{Response: RSP_48_CHECK_BUSY, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, 1*time.Millisecond); err != nil {
+		return
+	}
+
+	// only eMMC cards support CMD8 (SEND_EXT_CSD) in transfer state
+	if extCSD, e := hw.readExtCSD(); e == nil {
+		hw.card.MMC = true
+		hw.card.HS = extCSD[EXT_CSD_HS_TIMING] != 0
+		hw.card.HS200 = extCSD[EXT_CSD_HS_TIMING] == HS_TIMING_HS200
+
+		c_size := ResponseField(hw.csd, MMC_CSD_C_SIZE, 0xfff)
+		c_size_mult := ResponseField(hw.csd, MMC_CSD_C_SIZE_MULT, 0b111)
+		read_bl_len := ResponseField(hw.csd, MMC_CSD_READ_BL_LEN, 0xf)
+
+		// sector addressing for densities greater than 2GB
+		hw.card.HC = c_size > 0xff
+
+		err = hw.detectCapacityMMC(MMC_DEFAULT_BLOCK_SIZE, c_size_mult, c_size, read_bl_len)
+	} else if !hw.card.DDR {
+		hw.card.SD = true
+		// CSD Version 2.0 and above are only used by SDHC/SDXC/SDUC cards
+		hw.card.HC = ResponseField(hw.csd, SD_CSD_STRUCTURE, 0b11) >= 1
+
+		if err = hw.capacitySD(); err != nil {
+			return
+		}
+
+		var status []byte
+
+		// read current access mode
+		if status, err = hw.switchSD(MODE_CHECK, 0xf); err != nil {
+			return
+		}
+
+		hw.card.HS = sdSwitchSelected(status) == ACCESS_MODE_HS
+
+		if hw.card.SCR, err = hw.readSCR(); err != nil {
+			return
+		}
+	} else {
+		err = fmt.Errorf("card type could not be determined (%v)", e)
+	}
+
+	if err != nil {
+		hw.card = CardInfo{}
+		return
+	}
+
+	hw.parseCardCID()
+	hw.card.Width = width
+
+	return hw.card, nil
+}
@@ -0,0 +1,67 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+// CID/CSD register size
+const REGISTER_SIZE = 16
+
+// crc7 computes the CRC7 checksum (generator polynomial x^7 + x^3 + 1) of the
+// argument data (4.5 Cyclic Redundancy Code, SD-PL-7.10).
+func crc7(data []byte) (crc uint8) {
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> i) & 1
+			msb := (crc >> 6) & 1
+
+			crc = (crc << 1) & 0x7f
+
+			if bit^msb == 1 {
+				crc ^= 0x09
+			}
+		}
+	}
+
+	return
+}
+
+// rawRegister returns a CID/CSD register, from its RSP_136 response words,
+// as its 16 bytes in transmission order (most significant byte first).
+//
+// As the uSDHC strips the CRC from RSP_136 responses, the last byte (CRC7
+// and end bit) is recomputed.
+func rawRegister(rsp [4]uint32) (buf [REGISTER_SIZE]byte) {
+	for i := 0; i < REGISTER_SIZE-1; i++ {
+		buf[i] = byte(ResponseField(rsp, (REGISTER_SIZE-2-i)*8, 0xff))
+	}
+
+	buf[REGISTER_SIZE-1] = crc7(buf[0:REGISTER_SIZE-1])<<1 | 1
+
+	return
+}
+
+// RawCID returns the Card Identification register of the detected card, as
+// last read with CMD2 (ALL_SEND_CID), most significant byte first.
+func (hw *USDHC) RawCID() [REGISTER_SIZE]byte {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return rawRegister(hw.cid)
+}
+
+// RawCSD returns the Card Specific Data register of the detected card, as
+// last read with CMD9 (SEND_CSD), most significant byte first.
+func (hw *USDHC) RawCSD() [REGISTER_SIZE]byte {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return rawRegister(hw.csd)
+}
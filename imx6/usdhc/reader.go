@@ -0,0 +1,131 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"io"
+)
+
+// Reader implements io.Reader, io.Seeker and io.ReaderAt over a range of
+// the card user area.
+type Reader struct {
+	hw *USDHC
+
+	start int64
+	size  int64
+	pos   int64
+}
+
+// NewReader returns a Reader over the whole card user area, as detected at
+// the time of the call.
+func (hw *USDHC) NewReader() *Reader {
+	return hw.NewReaderAt(0, int64(hw.card.Blocks)*int64(hw.card.BlockSize))
+}
+
+// NewReaderAt returns a Reader over size bytes of the card user area,
+// starting at the argument offset. The range is bound to the card capacity,
+// as detected at the time of the call.
+func (hw *USDHC) NewReaderAt(offset int64, size int64) *Reader {
+	capacity := int64(hw.card.Blocks) * int64(hw.card.BlockSize)
+
+	if offset < 0 || offset > capacity {
+		offset = capacity
+	}
+
+	if size < 0 || size > capacity-offset {
+		size = capacity - offset
+	}
+
+	return &Reader{
+		hw:    hw,
+		start: offset,
+		size:  size,
+	}
+}
+
+// Size returns the size of the range covered by the Reader.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt, the offset is relative to the start of the
+// range covered by the Reader.
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	if max := r.size - off; int64(len(p)) > max {
+		p = p[0:max]
+		err = io.EOF
+	}
+
+	if len(p) == 0 {
+		return
+	}
+
+	blockSize := int64(r.hw.card.BlockSize)
+
+	if blockSize == 0 {
+		return 0, errors.New("no card detected")
+	}
+
+	// read whole blocks covering the requested range
+	start := r.start + off
+	lba := start / blockSize
+	blocks := (start+int64(len(p))+blockSize-1)/blockSize - lba
+
+	buf, e := r.hw.ReadBlocks(int(lba), int(blocks))
+
+	if e != nil {
+		return 0, e
+	}
+
+	n = copy(p, buf[start-lba*blockSize:])
+
+	return
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+
+	return
+}
+
+// Seek implements io.Seeker, offsets are relative to the start of the range
+// covered by the Reader.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.pos = offset
+
+	return offset, nil
+}
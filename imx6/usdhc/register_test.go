@@ -0,0 +1,71 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"testing"
+	"time"
+)
+
+// The following tests decode the RSP_136 response registers defined in
+// response_test.go, verifying the bit-to-register mapping documented with
+// CSD_RSP_OFF.
+
+func TestParseCID(t *testing.T) {
+	for _, tc := range []struct {
+		rsp [4]uint32
+		mmc bool
+		rev uint8
+		exp CID
+	}{
+		{sdCID, false, 0, CID{0x03, 0x5344, "SL08G", 0x80, 0xf6b4b33c, 2012, 5}},
+		{mmcCID, true, 0, CID{0x15, 0x00, "BJTD4R", 0x05, 0x4cd3b5f4, 1998, 12}},
+		{mmcCID, true, 7, CID{0x15, 0x00, "BJTD4R", 0x05, 0x4cd3b5f4, 2014, 12}},
+	} {
+		if res := parseCID(tc.rsp, tc.mmc, tc.rev); res != tc.exp {
+			t.Errorf("parseCID(%#x, %v, %d) = %+v, expected %+v", tc.rsp, tc.mmc, tc.rev, res, tc.exp)
+		}
+	}
+}
+
+func TestParseCSD(t *testing.T) {
+	for _, tc := range []struct {
+		rsp [4]uint32
+		mmc bool
+		exp CSD
+	}{
+		{sdCSD, false, CSD{
+			Structure:             1,
+			AccessTime:            1 * time.Millisecond,
+			AccessClocks:          0,
+			TransferRate:          25000000,
+			CCC:                   0x5b5,
+			EraseSize:             128 * 512,
+			WriteProtectGroupSize: 128 * 512,
+			Raw:                   sdCSD,
+		}},
+		{mmcCSD, true, CSD{
+			Structure:             3,
+			AccessTime:            15 * time.Millisecond,
+			AccessClocks:          100,
+			TransferRate:          26000000,
+			CCC:                   0x0f5,
+			EraseSize:             32 * 32 * 512,
+			WriteProtectGroupSize: 16 * 32 * 32 * 512,
+			Raw:                   mmcCSD,
+		}},
+	} {
+		if res := parseCSD(tc.rsp, tc.mmc); res != tc.exp {
+			t.Errorf("parseCSD(%#x, %v) = %+v, expected %+v", tc.rsp, tc.mmc, res, tc.exp)
+		}
+	}
+}
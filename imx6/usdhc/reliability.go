@@ -0,0 +1,166 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Write reliability constants (WR_REL_PARAM [166], WR_REL_SET [167],
+// JESD84-B51).
+const (
+	WR_REL_PARAM_EN_RPMB_REL_WR = 4
+	WR_REL_PARAM_EN_REL_WR      = 2
+	WR_REL_PARAM_HS_CTRL_REL    = 0
+
+	WR_REL_SET_USER = 0
+	WR_REL_SET_GP1  = 1
+	WR_REL_SET_GP2  = 2
+	WR_REL_SET_GP3  = 3
+	WR_REL_SET_GP4  = 4
+
+	PARTITION_SETTING_COMPLETED = 0
+
+	// CMD23 argument (6.10.4 Detailed command description, JESD84-B51)
+	SET_BLOCK_COUNT_RELIABLE_WRITE = 31
+)
+
+// WriteReliability represents eMMC write reliability settings, which
+// determine whether data previously written to a partition is protected
+// against power failures during later write operations.
+type WriteReliability struct {
+	// Param is the write reliability parameter register (WR_REL_PARAM).
+	Param uint8
+	// Set is the write reliability setting register (WR_REL_SET), each bit
+	// enables write reliability for a partition (WR_REL_SET_USER to
+	// WR_REL_SET_GP4).
+	Set uint8
+	// Completed reports whether partitioning settings, including write
+	// reliability ones, have been finalized (PARTITION_SETTING_COMPLETED).
+	Completed bool
+}
+
+// WriteReliability returns the eMMC write reliability settings.
+func (hw *USDHC) WriteReliability() (rel WriteReliability, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return rel, errors.New("write reliability is only supported on MMC cards")
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	rel.Param = extCSD[EXT_CSD_WR_REL_PARAM]
+	rel.Set = extCSD[EXT_CSD_WR_REL_SET]
+	rel.Completed = (extCSD[EXT_CSD_PARTITION_SETTING]>>PARTITION_SETTING_COMPLETED)&1 == 1
+
+	return
+}
+
+// SetWriteReliability configures the eMMC write reliability settings
+// (WR_REL_SET), each bit of the argument enables write reliability for a
+// partition (WR_REL_SET_USER to WR_REL_SET_GP4).
+//
+// The setting is one-time programmable and is only allowed on cards which
+// support it (WR_REL_PARAM HS_CTRL_REL) and before partitioning settings are
+// finalized. It only becomes effective once PARTITION_SETTING_COMPLETED is set
+// and the card is power cycled, both of which are left to the caller.
+func (hw *USDHC) SetWriteReliability(set uint8) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return errors.New("write reliability is only supported on MMC cards")
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	if (extCSD[EXT_CSD_WR_REL_PARAM]>>WR_REL_PARAM_HS_CTRL_REL)&1 != 1 {
+		return errors.New("write reliability setting is not supported")
+	}
+
+	if (extCSD[EXT_CSD_PARTITION_SETTING]>>PARTITION_SETTING_COMPLETED)&1 == 1 {
+		return errors.New("partition settings already completed")
+	}
+
+	return hw.writeCardRegisterMMC(EXT_CSD_WR_REL_SET, uint32(set))
+}
+
+// WriteBlockReliable atomically writes a single block of data to an eMMC card,
+// using reliable write, so that on power failure the block content is either
+// the old or the new one. It is meant for critical data such as boot flags
+// in A/B update schemes.
+//
+// Cards which do not enable the enhanced reliable write definition
+// (WR_REL_PARAM EN_REL_WR) follow the legacy one, where atomicity is only
+// guaranteed for 512 bytes sectors, on such cards the block length must
+// therefore be 512 bytes.
+func (hw *USDHC) WriteBlockReliable(lba int, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return errors.New("reliable write is only supported on MMC cards")
+	}
+
+	blockSize := hw.card.BlockSize
+
+	if len(buf) != blockSize {
+		return fmt.Errorf("reliable write size must be %d bytes", blockSize)
+	}
+
+	if err = hw.checkRange(lba, 1); err != nil {
+		return
+	}
+
+	extCSD, err := hw.extCSDCached()
+
+	if err != nil {
+		return
+	}
+
+	if (extCSD[EXT_CSD_WR_REL_PARAM]>>WR_REL_PARAM_EN_REL_WR)&1 != 1 && blockSize != MMC_DEFAULT_BLOCK_SIZE {
+		return fmt.Errorf("legacy reliable write requires %d bytes blocks", MMC_DEFAULT_BLOCK_SIZE)
+	}
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, 1*time.Millisecond); err != nil {
+		return
+	}
+
+	// CMD23 - SET_BLOCK_COUNT - define reliable write of one block
+	if err = hw.cmd(23, 1<<SET_BLOCK_COUNT_RELIABLE_WRITE|1, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	// the transfer completes on the predefined block count
+	hw.noAC12 = true
+	defer func() { hw.noAC12 = false }()
+
+	// CMD25 - WRITE_MULTIPLE_BLOCK - write consecutive blocks
+	if err = hw.transfer(25, WRITE, uint64(lba)*uint64(blockSize), 1, uint32(blockSize), buf); err != nil {
+		return
+	}
+
+	// wait for programming completion
+	return hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout)
+}
@@ -0,0 +1,82 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"testing"
+)
+
+// RSP_136 response registers (CMD_RSP0 to CMD_RSP3), holding R[127:8], for
+// CMD9 (SEND_CSD) and CMD2 (ALL_SEND_CID) issued to an SDHC card
+// (CSD 400e00325b5900003b377f800a4040af, CID 035344534c30384780f6b4b33c00c53f)
+// and an eMMC card (CSD d02701320f5903fff6dbffef8e40400d,
+// CID 150100424a54443452054cd3b5f4c1e9).
+var (
+	sdCSD  = [4]uint32{0x800a4040, 0x003b377f, 0x325b5900, 0x00400e00}
+	sdCID  = [4]uint32{0xb33c00c5, 0x4780f6b4, 0x534c3038, 0x00035344}
+	mmcCSD = [4]uint32{0xef8e4040, 0xfff6dbff, 0x320f5903, 0x00d02701}
+	mmcCID = [4]uint32{0xd3b5f4c1, 0x3452054c, 0x424a5444, 0x00150100}
+)
+
+func TestResponseField(t *testing.T) {
+	for _, tc := range []struct {
+		rsp  [4]uint32
+		pos  int
+		mask int
+		exp  uint32
+	}{
+		{[4]uint32{0x00000001, 0, 0, 0}, 0, 1, 1},
+		{[4]uint32{0x80000000, 0, 0, 0}, 31, 1, 1},
+		{[4]uint32{0, 0, 0, 0x00800000}, 119, 1, 1},
+		{[4]uint32{0, 0, 0, 0x00800000}, 120, 1, 0},
+		{[4]uint32{0x12345678, 0, 0, 0}, 8, 0xff, 0x56},
+		{[4]uint32{0, 0x12345678, 0, 0}, 40, 0xff, 0x56},
+		// fields spanning across response registers
+		{[4]uint32{0xf0000000, 0x0000000f, 0, 0}, 28, 0xff, 0xff},
+		{[4]uint32{0, 0x80000000, 0x00000001, 0}, 63, 0b11, 0b11},
+		{[4]uint32{0, 0, 0xabcd0000, 0x00001234}, 80, 0xffffff, 0x34abcd},
+		// out of range positions
+		{[4]uint32{0xffffffff, 0xffffffff, 0xffffffff, 0xffffffff}, -1, 1, 0},
+		{[4]uint32{0xffffffff, 0xffffffff, 0xffffffff, 0xffffffff}, 128, 1, 0},
+		// SDHC card CSD
+		{sdCSD, SD_CSD_STRUCTURE, 0b11, 1},
+		{sdCSD, CSD_TAAC, 0xff, 0x0e},
+		{sdCSD, SD_CSD_TRAN_SPEED, 0xff, 0x32},
+		{sdCSD, CSD_CCC, 0xfff, 0x5b5},
+		{sdCSD, SD_CSD_READ_BL_LEN_2, 0xf, 9},
+		{sdCSD, SD_CSD_C_SIZE_2, 0x3fffff, 0x3b37},
+		{sdCSD, SD_CSD_SECTOR_SIZE, 0x7f, 0x7f},
+		// eMMC card CSD, C_SIZE [73:62] spans across CMD_RSP1 and CMD_RSP2
+		{mmcCSD, SD_CSD_STRUCTURE, 0b11, 3},
+		{mmcCSD, MMC_CSD_SPEC_VERS, 0xf, 4},
+		{mmcCSD, MMC_CSD_TRAN_SPEED, 0xff, 0x32},
+		{mmcCSD, MMC_CSD_READ_BL_LEN, 0xf, 9},
+		{mmcCSD, MMC_CSD_C_SIZE, 0xfff, 0xfff},
+		{mmcCSD, MMC_CSD_C_SIZE_MULT, 0b111, 7},
+		{mmcCSD, MMC_CSD_ERASE_GRP_SIZE, 0x1f, 31},
+		{mmcCSD, MMC_CSD_ERASE_GRP_MULT, 0x1f, 31},
+		// SD card CID
+		{sdCID, CID_MID, 0xff, 0x03},
+		{sdCID, SD_CID_OID, 0xffff, 0x5344},
+		{sdCID, SD_CID_PRV, 0xff, 0x80},
+		{sdCID, SD_CID_MDT, 0xfff, 0x0c5},
+		// eMMC card CID
+		{mmcCID, CID_MID, 0xff, 0x15},
+		{mmcCID, MMC_CID_CBX, 0b11, 1},
+		{mmcCID, MMC_CID_PRV, 0xff, 0x05},
+		{mmcCID, MMC_CID_MDT, 0xff, 0xc1},
+	} {
+		if res := ResponseField(tc.rsp, tc.pos, tc.mask); res != tc.exp {
+			t.Errorf("ResponseField(%#x, %d, %#x) = %#x, expected %#x", tc.rsp, tc.pos, tc.mask, res, tc.exp)
+		}
+	}
+}
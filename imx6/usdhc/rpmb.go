@@ -0,0 +1,328 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// RPMB data frame (6.6.22 Replay Protected Memory Block, JESD84-B51).
+const (
+	RPMB_FRAME_SIZE = 512
+	RPMB_KEY_SIZE   = 32
+	RPMB_DATA_SIZE  = 256
+
+	// frame field offsets
+	RPMB_KEY_MAC       = 196
+	RPMB_DATA          = 228
+	RPMB_NONCE         = 484
+	RPMB_WRITE_COUNTER = 500
+	RPMB_ADDRESS       = 504
+	RPMB_BLOCK_COUNT   = 506
+	RPMB_RESULT        = 508
+	RPMB_REQ_RESP      = 510
+
+	RPMB_NONCE_SIZE = 16
+
+	// request message types
+	RPMB_REQ_KEY           = 0x0001
+	RPMB_REQ_WRITE_COUNTER = 0x0002
+	RPMB_REQ_WRITE         = 0x0003
+	RPMB_REQ_READ          = 0x0004
+	RPMB_REQ_RESULT        = 0x0005
+
+	// response message types
+	RPMB_RESP_KEY           = 0x0100
+	RPMB_RESP_WRITE_COUNTER = 0x0200
+	RPMB_RESP_WRITE         = 0x0300
+	RPMB_RESP_READ          = 0x0400
+
+	// operation results
+	RPMB_RESULT_OK               = 0x00
+	RPMB_RESULT_GENERAL_FAILURE  = 0x01
+	RPMB_RESULT_AUTH_FAILURE     = 0x02
+	RPMB_RESULT_COUNTER_FAILURE  = 0x03
+	RPMB_RESULT_ADDRESS_FAILURE  = 0x04
+	RPMB_RESULT_WRITE_FAILURE    = 0x05
+	RPMB_RESULT_READ_FAILURE     = 0x06
+	RPMB_RESULT_NO_KEY           = 0x07
+	RPMB_RESULT_COUNTER_EXPIRED  = 0x80
+	RPMB_RESULT_OPERATION_RESULT = 0x7f
+)
+
+// ErrRPMBAuthentication is returned when the MAC of an RPMB response frame,
+// or of a request frame as verified by the card, does not match the one
+// computed with the authentication key.
+var ErrRPMBAuthentication = errors.New("RPMB authentication failure")
+
+// rpmbMAC computes the HMAC-SHA256 of an RPMB data frame, over the fields
+// following the key/MAC one.
+func rpmbMAC(key []byte, frame []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(frame[RPMB_DATA:RPMB_FRAME_SIZE])
+
+	return mac.Sum(nil)
+}
+
+// rpmbResult verifies an RPMB response frame type and operation result.
+func rpmbResult(frame []byte, resp uint16) (err error) {
+	if t := binary.BigEndian.Uint16(frame[RPMB_REQ_RESP:]); t != resp {
+		return fmt.Errorf("unexpected RPMB response type %#x", t)
+	}
+
+	result := binary.BigEndian.Uint16(frame[RPMB_RESULT:])
+
+	switch result & RPMB_RESULT_OPERATION_RESULT {
+	case RPMB_RESULT_OK:
+	case RPMB_RESULT_AUTH_FAILURE:
+		return ErrRPMBAuthentication
+	default:
+		return fmt.Errorf("RPMB operation failed (result:%#x)", result)
+	}
+
+	if result&RPMB_RESULT_COUNTER_EXPIRED != 0 {
+		return errors.New("RPMB write counter expired")
+	}
+
+	return
+}
+
+// rpmbRequest sends an RPMB request frame, reliable write is required for
+// authenticated data writes and key programming.
+func (hw *USDHC) rpmbRequest(frame []byte, reliable bool) (err error) {
+	arg := uint32(1)
+
+	if reliable {
+		arg |= 1 << SET_BLOCK_COUNT_RELIABLE_WRITE
+	}
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout); err != nil {
+		return
+	}
+
+	// CMD23 - SET_BLOCK_COUNT - define single frame transfer
+	if err = hw.cmd(23, arg, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	// the transfer completes on the predefined block count
+	hw.noAC12 = true
+	defer func() { hw.noAC12 = false }()
+
+	// CMD25 - WRITE_MULTIPLE_BLOCK - send request frame
+	if err = hw.transferArg(25, WRITE, 0, 1, RPMB_FRAME_SIZE, frame); err != nil {
+		return
+	}
+
+	// wait for programming completion
+	return hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout)
+}
+
+// rpmbResponse reads an RPMB response frame.
+func (hw *USDHC) rpmbResponse() (frame []byte, err error) {
+	frame = make([]byte, RPMB_FRAME_SIZE)
+
+	// CMD23 - SET_BLOCK_COUNT - define single frame transfer
+	if err = hw.cmd(23, 1, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	// the transfer completes on the predefined block count
+	hw.noAC12 = true
+	defer func() { hw.noAC12 = false }()
+
+	// CMD18 - READ_MULTIPLE_BLOCK - read response frame
+	err = hw.transferArg(18, READ, 0, 1, RPMB_FRAME_SIZE, frame)
+
+	return
+}
+
+// rpmbRead performs an RPMB read operation (write counter or data read),
+// authenticating the response frame with the argument key and a random
+// nonce.
+func (hw *USDHC) rpmbRead(req uint16, resp uint16, addr uint16, key []byte) (frame []byte, err error) {
+	frame = make([]byte, RPMB_FRAME_SIZE)
+	nonce := frame[RPMB_NONCE : RPMB_NONCE+RPMB_NONCE_SIZE]
+
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+
+	binary.BigEndian.PutUint16(frame[RPMB_ADDRESS:], addr)
+	binary.BigEndian.PutUint16(frame[RPMB_REQ_RESP:], req)
+
+	if err = hw.rpmbRequest(frame, false); err != nil {
+		return
+	}
+
+	res, err := hw.rpmbResponse()
+
+	if err != nil {
+		return
+	}
+
+	if err = rpmbResult(res, resp); err != nil {
+		return
+	}
+
+	if !hmac.Equal(res[RPMB_KEY_MAC:RPMB_DATA], rpmbMAC(key, res)) {
+		return nil, ErrRPMBAuthentication
+	}
+
+	if !bytes.Equal(res[RPMB_NONCE:RPMB_NONCE+RPMB_NONCE_SIZE], nonce) {
+		return nil, errors.New("RPMB nonce mismatch")
+	}
+
+	return res, nil
+}
+
+// rpmb runs an RPMB operation with the RPMB partition selected, restoring
+// the previously selected partition afterwards.
+func (hw *USDHC) rpmb(key []byte, fn func() error) (err error) {
+	if !hw.card.MMC {
+		return errors.New("RPMB is only supported on MMC cards")
+	}
+
+	if len(key) != RPMB_KEY_SIZE {
+		return fmt.Errorf("RPMB key size must be %d bytes", RPMB_KEY_SIZE)
+	}
+
+	if hw.partitionUncertain {
+		return ErrPartitionUncertain
+	}
+
+	partition := hw.partition
+
+	if err = hw.selectPartition("rpmb"); err != nil {
+		return
+	}
+
+	defer func() {
+		if e := hw.selectPartition(partition); err == nil {
+			err = e
+		}
+	}()
+
+	return fn()
+}
+
+// RPMBWriteCounter returns the eMMC Replay Protected Memory Block write
+// counter, authenticated with the argument key.
+func (hw *USDHC) RPMBWriteCounter(key []byte) (counter uint32, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	err = hw.rpmb(key, func() (err error) {
+		res, err := hw.rpmbRead(RPMB_REQ_WRITE_COUNTER, RPMB_RESP_WRITE_COUNTER, 0, key)
+
+		if err != nil {
+			return
+		}
+
+		counter = binary.BigEndian.Uint32(res[RPMB_WRITE_COUNTER:])
+
+		return
+	})
+
+	return
+}
+
+// RPMBRead performs an authenticated read of a 256 bytes half sector from the
+// eMMC Replay Protected Memory Block at the argument address. The response
+// is authenticated with the argument key, on MAC mismatch
+// ErrRPMBAuthentication is returned.
+func (hw *USDHC) RPMBRead(addr uint16, key []byte) (data []byte, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	err = hw.rpmb(key, func() (err error) {
+		res, err := hw.rpmbRead(RPMB_REQ_READ, RPMB_RESP_READ, addr, key)
+
+		if err != nil {
+			return
+		}
+
+		data = append([]byte{}, res[RPMB_DATA:RPMB_DATA+RPMB_DATA_SIZE]...)
+
+		return
+	})
+
+	return
+}
+
+// RPMBWrite performs an authenticated write of a 256 bytes half sector to the
+// eMMC Replay Protected Memory Block at the argument address. The write
+// counter is read, and authenticated, before the write and verified to be
+// incremented by the card afterwards. On MAC mismatch, either on the request
+// (as reported by the card) or response, ErrRPMBAuthentication is returned.
+func (hw *USDHC) RPMBWrite(addr uint16, data []byte, key []byte) (err error) {
+	if len(data) != RPMB_DATA_SIZE {
+		return fmt.Errorf("RPMB write size must be %d bytes", RPMB_DATA_SIZE)
+	}
+
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.rpmb(key, func() (err error) {
+		res, err := hw.rpmbRead(RPMB_REQ_WRITE_COUNTER, RPMB_RESP_WRITE_COUNTER, 0, key)
+
+		if err != nil {
+			return
+		}
+
+		counter := binary.BigEndian.Uint32(res[RPMB_WRITE_COUNTER:])
+
+		frame := make([]byte, RPMB_FRAME_SIZE)
+		copy(frame[RPMB_DATA:], data)
+		binary.BigEndian.PutUint32(frame[RPMB_WRITE_COUNTER:], counter)
+		binary.BigEndian.PutUint16(frame[RPMB_ADDRESS:], addr)
+		binary.BigEndian.PutUint16(frame[RPMB_BLOCK_COUNT:], 1)
+		binary.BigEndian.PutUint16(frame[RPMB_REQ_RESP:], RPMB_REQ_WRITE)
+		copy(frame[RPMB_KEY_MAC:], rpmbMAC(key, frame))
+
+		if err = hw.rpmbRequest(frame, true); err != nil {
+			return
+		}
+
+		// request the write operation result
+		req := make([]byte, RPMB_FRAME_SIZE)
+		binary.BigEndian.PutUint16(req[RPMB_REQ_RESP:], RPMB_REQ_RESULT)
+
+		if err = hw.rpmbRequest(req, false); err != nil {
+			return
+		}
+
+		if res, err = hw.rpmbResponse(); err != nil {
+			return
+		}
+
+		if err = rpmbResult(res, RPMB_RESP_WRITE); err != nil {
+			return
+		}
+
+		if !hmac.Equal(res[RPMB_KEY_MAC:RPMB_DATA], rpmbMAC(key, res)) {
+			return ErrRPMBAuthentication
+		}
+
+		if c := binary.BigEndian.Uint32(res[RPMB_WRITE_COUNTER:]); c != counter+1 {
+			return fmt.Errorf("RPMB write counter mismatch (%d, expected:%d)", c, counter+1)
+		}
+
+		return
+	})
+}
@@ -0,0 +1,94 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// vectorBlocks returns the overall number of blocks of a set of buffers, each
+// of which must be a non-empty multiple of the block size.
+func vectorBlocks(bufs [][]byte, blockSize int) (blocks int, err error) {
+	if blockSize == 0 {
+		return 0, errors.New("no card detected")
+	}
+
+	for _, buf := range bufs {
+		if len(buf) == 0 || len(buf)%blockSize != 0 {
+			return 0, fmt.Errorf("buffer size must be %d bytes aligned", blockSize)
+		}
+
+		blocks += len(buf) / blockSize
+	}
+
+	return
+}
+
+// ReadBlocksScatter transfers consecutive full blocks of data from the card,
+// starting at the argument block address, scattering them across the
+// argument buffers in order. Each buffer must be a multiple of the block
+// size and is transferred through its own ADMA2 descriptors, allowing large
+// transfers without a single contiguous allocation.
+//
+// The buffers are moved with a single multiple block transfer, therefore, as
+// opposed to ReadBlocks(), they cannot exceed MAX_TRANSFER_BLOCKS blocks in
+// total.
+func (hw *USDHC) ReadBlocksScatter(lba int, bufs [][]byte) (err error) {
+	blockSize := hw.card.BlockSize
+	offset := uint64(lba) * uint64(blockSize)
+	blocks, err := vectorBlocks(bufs, blockSize)
+
+	if err != nil || blocks == 0 {
+		return
+	}
+
+	hw.Lock()
+	defer hw.Unlock()
+
+	if err = hw.checkRange(lba, blocks); err != nil {
+		return
+	}
+
+	// CMD18 - READ_MULTIPLE_BLOCK - read consecutive blocks, stopped with
+	// Auto CMD12
+	return hw.transferBuffers(18, READ, offset, uint32(blocks), uint32(blockSize), bufs)
+}
+
+// WriteBlocksGather transfers consecutive full blocks of data to the card,
+// starting at the argument block address, gathering them from the argument
+// buffers in order (see ReadBlocksScatter()).
+func (hw *USDHC) WriteBlocksGather(lba int, bufs [][]byte) (err error) {
+	blockSize := hw.card.BlockSize
+	offset := uint64(lba) * uint64(blockSize)
+	blocks, err := vectorBlocks(bufs, blockSize)
+
+	if err != nil || blocks == 0 {
+		return
+	}
+
+	hw.Lock()
+	defer hw.Unlock()
+
+	if err = hw.checkRange(lba, blocks); err != nil {
+		return
+	}
+
+	// CMD25 - WRITE_MULTIPLE_BLOCK - write consecutive blocks, stopped
+	// with Auto CMD12
+	if err = hw.transferBuffers(25, WRITE, offset, uint32(blocks), uint32(blockSize), bufs); err != nil {
+		return
+	}
+
+	// wait for programming completion
+	return hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout)
+}
@@ -0,0 +1,118 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SD Configuration Register (5.6 SCR register, SD-PL-7.10)
+const (
+	SCR_SIZE = 8
+
+	SCR_STRUCTURE             = 60
+	SCR_SD_SPEC               = 56
+	SCR_DATA_STAT_AFTER_ERASE = 55
+	SCR_SD_BUS_WIDTHS         = 48
+	SCR_SD_SPEC3              = 47
+	SCR_SD_SPEC4              = 42
+	SCR_SD_SPECX              = 38
+	SCR_CMD_SUPPORT           = 32
+
+	// SD_BUS_WIDTHS
+	SD_BUS_WIDTH_1 = 0
+	SD_BUS_WIDTH_4 = 2
+
+	// CMD_SUPPORT
+	CMD_SUPPORT_SPEED_CLASS = 0
+	CMD_SUPPORT_CMD23       = 1
+	CMD_SUPPORT_EXT_REG     = 2
+	CMD_SUPPORT_EXT_REG_MB  = 3
+)
+
+// SCR represents the SD Configuration Register.
+type SCR struct {
+	// SCR structure version (SCR_STRUCTURE)
+	Structure int
+	// Physical Layer Specification version (SD_SPEC, SD_SPEC3, SD_SPEC4
+	// and SD_SPECX), e.g. "3.0x"
+	Spec string
+	// Data status after erase, 0 or 1 (DATA_STAT_AFTER_ERASE)
+	ErasedValue int
+	// Supported data bus widths (SD_BUS_WIDTHS)
+	BusWidth1 bool
+	BusWidth4 bool
+	// Command support bits (CMD_SUPPORT)
+	CMDSupport uint8
+
+	// Raw register value
+	Raw uint64
+}
+
+// sdSpec returns the Physical Layer Specification version from SCR version
+// fields (5.6 SCR register, SD-PL-7.10).
+func sdSpec(spec uint64, spec3 uint64, spec4 uint64, specX uint64) string {
+	switch {
+	case spec == 0:
+		return "1.0x"
+	case spec == 1:
+		return "1.10"
+	case spec == 2 && spec3 == 0:
+		return "2.00"
+	case spec == 2 && specX > 0:
+		return fmt.Sprintf("%d.xx", specX+4)
+	case spec == 2 && spec4 == 1:
+		return "4.xx"
+	case spec == 2:
+		return "3.0x"
+	default:
+		return "unknown"
+	}
+}
+
+// parseSCR decodes the SD Configuration Register, as transferred by ACMD51.
+func parseSCR(buf []byte) (scr SCR) {
+	r := binary.BigEndian.Uint64(buf)
+
+	scr.Raw = r
+	scr.Structure = int((r >> SCR_STRUCTURE) & 0xf)
+	scr.Spec = sdSpec((r>>SCR_SD_SPEC)&0xf, (r>>SCR_SD_SPEC3)&1, (r>>SCR_SD_SPEC4)&1, (r>>SCR_SD_SPECX)&0xf)
+	scr.ErasedValue = int((r >> SCR_DATA_STAT_AFTER_ERASE) & 1)
+	scr.BusWidth1 = (r>>(SCR_SD_BUS_WIDTHS+SD_BUS_WIDTH_1))&1 == 1
+	scr.BusWidth4 = (r>>(SCR_SD_BUS_WIDTHS+SD_BUS_WIDTH_4))&1 == 1
+	scr.CMDSupport = uint8((r >> SCR_CMD_SUPPORT) & 0xf)
+
+	return
+}
+
+// readSCR issues ACMD51 (SEND_SCR) to read the SD Configuration Register, the
+// card must be in transfer state.
+func (hw *USDHC) readSCR() (scr SCR, err error) {
+	buf := make([]byte, SCR_SIZE)
+
+	// the transfer completes on the single block count
+	hw.noAC12 = true
+	hw.acmd = true
+
+	defer func() {
+		hw.noAC12 = false
+		hw.acmd = false
+	}()
+
+	// ACMD51 - SEND_SCR - read SD configuration register
+	if err = hw.transferArg(51, READ, 0, 1, SCR_SIZE, buf); err != nil {
+		return
+	}
+
+	return parseSCR(buf), nil
+}
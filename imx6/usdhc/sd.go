@@ -40,8 +40,20 @@ const (
 	SD_OCR_VDD_LV     = 7
 
 	// p120, Table 4-32 : Switch Function Commands (class 10), SD-PL-7.10
-	SD_SWITCH_MODE        = 0
-	SD_SWITCH_ACCESS_MODE = 0
+	SD_SWITCH_MODE          = 31
+	SD_SWITCH_CURRENT_LIMIT = 12
+	SD_SWITCH_ACCESS_MODE   = 0
+
+	// p94, Table 4-13 : Status Data Structure, SD-PL-7.10
+	SD_SWITCH_STATUS_SIZE = 64
+	// Support Bits of Functions in Function Group 1 [415:400]
+	SD_SWITCH_STATUS_ACCESS_MODE_SUPPORT = 12
+	// Function Selection of Function Group 1 [379:376]
+	SD_SWITCH_STATUS_ACCESS_MODE_SELECTION = 16
+	// Support Bits of Functions in Function Group 4 [463:448]
+	SD_SWITCH_STATUS_CURRENT_LIMIT_SUPPORT = 6
+	// Function Selection of Function Group 4 [391:388]
+	SD_SWITCH_STATUS_CURRENT_LIMIT_SELECTION = 15
 
 	// p89, 4.3.10 Switch Function Command, SD-PL-7.10
 	MODE_CHECK         = 0
@@ -52,7 +64,8 @@ const (
 	ACCESS_MODE_SDR104 = 0x3
 
 	// p201 5.3.1 CSD_STRUCTURE, SD-PL-7.10
-	SD_CSD_STRUCTURE = 126 + CSD_RSP_OFF
+	SD_CSD_STRUCTURE  = 126 + CSD_RSP_OFF
+	SD_CSD_TRAN_SPEED = 96 + CSD_RSP_OFF
 
 	// p202 5.3.2 CSD Register (CSD Version 1.0), SD-PL-7.10
 	SD_CSD_C_SIZE_MULT_1 = 47 + CSD_RSP_OFF
@@ -72,79 +85,190 @@ const (
 const (
 	SD_DETECT_TIMEOUT     = 1 * time.Second
 	SD_DEFAULT_BLOCK_SIZE = 512
+
+	// maximum SDHC capacity (3.3 Capacity of Memory, SD-PL-7.10)
+	SDHC_MAX_SIZE = 32 * 1024 * 1024 * 1024
 )
 
-// p350, 35.4.4 SD voltage validation flow chart, IMX6FG
-func (hw *USDHC) voltageValidationSD() (sd bool, hc bool) {
-	var arg uint32
-	var hv bool
+// SD interface conditions, as classified by the response to CMD8
+// (p59, 4.2.3.1 Initialization Command (ACMD41), SD-PL-7.10).
+const (
+	// no response: Ver1.X SD card (or not an SD card)
+	SD_IF_COND_V1 = iota
+	// matching response with high voltage (2.7-3.6V) supply: Ver2.00 or
+	// later SD card
+	SD_IF_COND_V2
+	// matching response with low voltage range supply only: Ver2.00 or
+	// later low voltage SD card
+	SD_IF_COND_V2_LV
+	// mismatching response: unusable card
+	SD_IF_COND_UNUSABLE
+)
 
-	// CMD8 - SEND_IF_COND - read device data
-	// p101, 4.3.13 Send Interface Condition Command (CMD8), SD-PL-7.10
+// sdIfCond classifies an SD card from the outcome of CMD8 (SEND_IF_COND),
+// issued through the argument function, which returns the card response to
+// the given command argument.
+//
+// The high voltage supply is checked first, the low voltage range is only
+// checked when the card does not respond, as cards do not respond to CMD8
+// with a supply voltage they do not accept.
+func sdIfCond(sendIfCond func(arg uint32) (rsp uint32, err error)) int {
+	for _, vhs := range []uint32{VHS_HIGH, VHS_LOW} {
+		var arg uint32
 
-	bits.SetN(&arg, CMD8_ARG_VHS, 0b1111, VHS_HIGH)
-	bits.SetN(&arg, CMD8_ARG_CHECK_PATTERN, 0xff, CHECK_PATTERN)
+		bits.SetN(&arg, CMD8_ARG_VHS, 0b1111, vhs)
+		bits.SetN(&arg, CMD8_ARG_CHECK_PATTERN, 0xff, CHECK_PATTERN)
 
-	if hw.cmd(8, READ, arg, RSP_48, true, true, false, 0) == nil && hw.rsp(0) == arg {
-		// HC/LC HV SD 2.x
-		hc = true
-		hv = true
-	} else {
-		arg = VHS_LOW<<CMD8_ARG_VHS | CHECK_PATTERN
-
-		if hw.cmd(8, READ, arg, RSP_48, true, true, false, 0) == nil && hw.rsp(0) == arg {
-			// LC SD 1.x
-			hc = true
-		} else {
-			// LC SD 2.x
-			hv = true
+		rsp, err := sendIfCond(arg)
+
+		switch {
+		case err != nil:
+			continue
+		case rsp != arg:
+			return SD_IF_COND_UNUSABLE
+		case vhs == VHS_HIGH:
+			return SD_IF_COND_V2
+		default:
+			return SD_IF_COND_V2_LV
 		}
 	}
 
-	// ACMD41 - SD_SEND_OP_COND - read capacity information
-	// p59, 4.2.3.1 Initialization Command (ACMD41), SD-PL-7.10
-	//
-	// The ACMD41 full argument is the OCR, despite the standard
-	// confusingly naming OCR only bits 23-08 of it (which instead
-	// represents part of OCR register voltage window).
-	arg = 0
+	return SD_IF_COND_V1
+}
 
-	if hc {
+// sdOpCond returns the ACMD41 (SD_SEND_OP_COND) argument for an SD card
+// classified with sdIfCond().
+//
+// High Capacity support and Maximum Performance are only requested to Ver2.00
+// or later cards, when hcs is true, while the voltage window matches the
+// supply voltage accepted by the card.
+//
+// The ACMD41 full argument is the OCR, despite the standard confusingly
+// naming OCR only bits 23-08 of it (which instead represents part of OCR
+// register voltage window).
+func sdOpCond(ifCond int, hcs bool) (arg uint32) {
+	if ifCond != SD_IF_COND_V1 && hcs {
 		// SDHC or SDXC supported
 		bits.Set(&arg, SD_OCR_HCS)
 		// Maximum Performance
 		bits.Set(&arg, SD_OCR_XPC)
 	}
 
-	if hv {
+	if ifCond == SD_IF_COND_V2_LV {
+		// set LV range
+		bits.Set(&arg, SD_OCR_VDD_LV)
+	} else {
 		// set HV range
 		bits.SetN(&arg, SD_OCR_VDD_HV_MIN, 0x1ff, 0x1ff)
-	} else {
-		bits.Set(&arg, SD_OCR_VDD_LV)
 	}
 
-	start := time.Now()
+	return
+}
+
+// sdAppCmd issues CMD55 (APP_CMD) through the argument function, addressing
+// the card with the argument RCA, and verifies from the card status that the
+// next command is interpreted as application specific.
+//
+// The RCA must be the default one (0x0000) before identification, as cards
+// in idle, ready or identification state have no published RCA, and the
+// published one afterwards, as cards in stand-by or transfer state only
+// respond to CMD55 when addressed.
+func sdAppCmd(rca uint32, sendAppCmd func(arg uint32) (rsp uint32, err error)) (err error) {
+	rsp, err := sendAppCmd(rca)
+
+	if err != nil {
+		return
+	}
+
+	if bits.Get(&rsp, STATUS_APP_CMD, 1) != 1 {
+		return fmt.Errorf("card not expecting application command")
+	}
+
+	return
+}
 
-	for time.Since(start) <= SD_DETECT_TIMEOUT {
+// appCmd signals the card that the next command is an application specific
+// one (ACMD). The card is addressed with its RCA once assigned, while the
+// default RCA (0x0000) is used before identification.
+func (hw *USDHC) appCmd() (err error) {
+	return sdAppCmd(hw.rca, func(arg uint32) (uint32, error) {
 		// CMD55 - APP_CMD - next command is application specific
-		if hw.cmd(55, READ, 0, RSP_48, true, true, false, 0) != nil {
+		err := hw.cmd(55, arg, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true})
+		return hw.rsp(0), err
+	})
+}
+
+// sdOpCondStatus returns whether an SD card completed its power up procedure,
+// from its ACMD41 (SD_SEND_OP_COND) response, and its Card Capacity Status
+// which distinguishes SDHC/SDXC from SDSC cards.
+func sdOpCondStatus(rsp uint32) (ready bool, ccs bool) {
+	if bits.Get(&rsp, SD_OCR_BUSY, 1) == 0 {
+		return
+	}
+
+	return true, bits.Get(&rsp, SD_OCR_HCS, 1) == 1
+}
+
+// p350, 35.4.4 SD voltage validation flow chart, IMX6FG
+func (hw *USDHC) voltageValidationSD() (sd bool, hc bool) {
+	// CMD8 - SEND_IF_COND - read device data
+	// p101, 4.3.13 Send Interface Condition Command (CMD8), SD-PL-7.10
+	ifCond := sdIfCond(func(arg uint32) (uint32, error) {
+		err := hw.cmd(8, arg, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true})
+		return hw.rsp(0), err
+	})
+
+	switch ifCond {
+	case SD_IF_COND_V1:
+		// Ver1.X SD cards, which do not support high capacity, are
+		// optionally ignored
+		if hw.DisableSDv1 {
 			return false, false
 		}
+	case SD_IF_COND_UNUSABLE:
+		return false, false
+	}
+
+	// ACMD41 - SD_SEND_OP_COND - read capacity information
+	// p59, 4.2.3.1 Initialization Command (ACMD41), SD-PL-7.10
+	arg := sdOpCond(ifCond, !hw.DisableHCS)
 
-		// ACMD41 - SD_SEND_OP_COND - send operating conditions
-		if err := hw.cmd(41, READ, arg, RSP_48, false, false, false, 0); err != nil {
+	if hw.UHS && bits.Get(&hw.cap, HOST_CTRL_CAP_VS18, 1) == 1 {
+		// request 1.8V signaling
+		bits.Set(&arg, SD_OCR_S18R)
+	}
+
+	// p43, 6.4.1 Power up, SD-PL-7.10
+	hw.powerUpDelay()
+
+	timeout := hw.detectTimeout(SD_DETECT_TIMEOUT)
+	start := time.Now()
+
+	for time.Since(start) <= timeout {
+		err := retry(hw.CommandRetries, func() (err error) {
+			if err = hw.appCmd(); err != nil {
+				return
+			}
+
+			// ACMD41 - SD_SEND_OP_COND - send operating conditions
+			return hw.cmd(41, arg, CommandOpts{Response: RSP_48})
+		})
+
+		if err != nil {
 			return false, false
 		}
 
 		rsp := hw.rsp(0)
+		ready, ccs := sdOpCondStatus(rsp)
 
-		if bits.Get(&rsp, SD_OCR_BUSY, 1) == 0 {
+		if !ready {
 			continue
 		}
 
-		if bits.Get(&rsp, SD_OCR_HCS, 1) == 1 {
-			hc = true
-		}
+		hc = ccs
+
+		// retain accepted operating conditions
+		hw.ocr = rsp
 
 		return true, hc
 	}
@@ -154,34 +278,43 @@ func (hw *USDHC) voltageValidationSD() (sd bool, hc bool) {
 
 func (hw *USDHC) detectCapacitySD(blockSize uint32) (err error) {
 	// CMD9 - SEND_CSD - read device data
-	if err = hw.cmd(9, READ, hw.rca, RSP_136, false, true, false, 0); err != nil {
+	if err = hw.cmd(9, hw.rca, CommandOpts{Response: RSP_136, CheckCRC: true}); err != nil {
 		return
 	}
 
-	ver := hw.rspVal(SD_CSD_STRUCTURE, 0b11)
+	hw.csd = hw.response()
+
+	return hw.capacitySD()
+}
+
+// capacitySD sets the card capacity from the last read CSD register.
+func (hw *USDHC) capacitySD() (err error) {
+	csd := hw.csd
+	hw.csdInfo = parseCSD(csd, false)
+	ver := ResponseField(csd, SD_CSD_STRUCTURE, 0b11)
 
 	switch ver {
 	case 0:
 		// CSD Version 1.0
-		c_size_mult := hw.rspVal(SD_CSD_C_SIZE_MULT_1, 0b111)
-		c_size := hw.rspVal(SD_CSD_C_SIZE_1, 0xfff)
-		read_bl_len := hw.rspVal(SD_CSD_READ_BL_LEN_1, 0xf)
+		c_size_mult := ResponseField(csd, SD_CSD_C_SIZE_MULT_1, 0b111)
+		c_size := ResponseField(csd, SD_CSD_C_SIZE_1, 0xfff)
+		read_bl_len := ResponseField(csd, SD_CSD_READ_BL_LEN_1, 0xf)
 
 		// p205, C_SIZE, SD-PL-7.10
 		hw.card.BlockSize = 2 << (read_bl_len - 1)
 		hw.card.Blocks = int((c_size + 1) * (2 << (c_size_mult + 2)))
 	case 1:
 		// CSD Version 2.0
-		c_size := hw.rspVal(SD_CSD_C_SIZE_2, 0x3fffff)
-		read_bl_len := hw.rspVal(SD_CSD_READ_BL_LEN_2, 0xf)
+		c_size := ResponseField(csd, SD_CSD_C_SIZE_2, 0x3fffff)
+		read_bl_len := ResponseField(csd, SD_CSD_READ_BL_LEN_2, 0xf)
 
 		// p210, C_SIZE, SD-PL-7.10
 		hw.card.BlockSize = 2 << (read_bl_len - 1)
 		hw.card.Blocks = int(c_size+1) * 1024
 	case 2:
 		// CSD Version 3.0
-		c_size := hw.rspVal(SD_CSD_C_SIZE_2, 0xfffffff)
-		read_bl_len := hw.rspVal(SD_CSD_READ_BL_LEN_2, 0xf)
+		c_size := ResponseField(csd, SD_CSD_C_SIZE_2, 0xfffffff)
+		read_bl_len := ResponseField(csd, SD_CSD_READ_BL_LEN_2, 0xf)
 
 		// p213, C_SIZE, SD-PL-7.10
 		hw.card.BlockSize = 2 << (read_bl_len - 1)
@@ -193,24 +326,119 @@ func (hw *USDHC) detectCapacitySD(blockSize uint32) (err error) {
 	return
 }
 
+func (hw *USDHC) setBusWidthSD(width int) (err error) {
+	var bus_width uint32
+
+	// p118, Table 4-31, SD-PL-7.10
+	switch width {
+	case 1:
+		bus_width = 0b00
+	case 4:
+		bus_width = 0b10
+	default:
+		return errors.New("unsupported SD bus width")
+	}
+
+	if err = hw.appCmd(); err != nil {
+		return
+	}
+
+	// ACMD6 - SET_BUS_WIDTH - define the card data bus width
+	return hw.cmd(6, bus_width, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true})
+}
+
+// switchSD issues CMD6 (SWITCH_FUNC) to check or switch the function group 1
+// (access mode), returning the switch function status.
+func (hw *USDHC) switchSD(mode uint32, accessMode uint32) (status []byte, err error) {
+	return hw.switchFunctionSD(mode, SD_SWITCH_ACCESS_MODE, accessMode)
+}
+
+// switchFunctionSD issues CMD6 (SWITCH_FUNC) to check or switch the function
+// group at the argument offset (SD_SWITCH_ACCESS_MODE, SD_SWITCH_CURRENT_LIMIT),
+// returning the switch function status.
+func (hw *USDHC) switchFunctionSD(mode uint32, group int, function uint32) (status []byte, err error) {
+	// set `no influence` (0xf) for all functions except changed ones
+	arg := uint32(0x00ffffff)
+	// set mode check or switch
+	bits.SetN(&arg, SD_SWITCH_MODE, 1, mode)
+	// set function
+	bits.SetN(&arg, group, 0b1111, function)
+
+	status = make([]byte, SD_SWITCH_STATUS_SIZE)
+
+	// the transfer completes on the single block count
+	hw.noAC12 = true
+	defer func() { hw.noAC12 = false }()
+
+	// CMD6 - SWITCH - switch mode of operation
+	err = hw.transferArg(6, READ, arg, 1, SD_SWITCH_STATUS_SIZE, status)
+
+	return
+}
+
+// sdSwitchSupported returns whether the switch function status reports
+// support for an access mode.
+func sdSwitchSupported(status []byte, accessMode uint32) bool {
+	support := uint32(status[SD_SWITCH_STATUS_ACCESS_MODE_SUPPORT])<<8 | uint32(status[SD_SWITCH_STATUS_ACCESS_MODE_SUPPORT+1])
+	return bits.Get(&support, int(accessMode), 1) == 1
+}
+
+// sdSwitchSelected returns the access mode selected in the switch function
+// status, 0xf indicates an error or an unsupported function.
+func sdSwitchSelected(status []byte) uint32 {
+	return uint32(status[SD_SWITCH_STATUS_ACCESS_MODE_SELECTION] & 0xf)
+}
+
+// sdSwitchHS switches an SD card to High Speed mode, through the argument CMD6
+// (SWITCH_FUNC) function, returning whether the switch took place.
+//
+// The switch is only attempted if the card reports High Speed support in its
+// function group 1 check status, a failed check is treated as lack of
+// support.
+func sdSwitchHS(switchSD func(mode uint32, accessMode uint32) (status []byte, err error)) (hs bool, err error) {
+	status, err := switchSD(MODE_CHECK, ACCESS_MODE_HS)
+
+	if err != nil {
+		return false, nil
+	}
+
+	if !sdSwitchSupported(status, ACCESS_MODE_HS) {
+		return
+	}
+
+	if status, err = switchSD(MODE_SWITCH, ACCESS_MODE_HS); err != nil {
+		return
+	}
+
+	return sdSwitchSelected(status) == ACCESS_MODE_HS, nil
+}
+
 // p351, 35.4.5 SD card initialization flow chart, IMX6FG
 // p57, 4.2.3 Card Initialization and Identification Process, SD-PL-7.10
 func (hw *USDHC) initSD() (err error) {
 	var arg uint32
-	var bus_width uint32
+
+	if hw.UHS && bits.Get(&hw.ocr, SD_OCR_S18R, 1) == 1 {
+		if err = hw.switchVoltage(); err != nil {
+			return
+		}
+	}
 
 	// CMD2 - ALL_SEND_CID - get unique card identification
-	if err = hw.cmd(2, READ, arg, RSP_136, false, true, false, 0); err != nil {
+	if err = hw.cmdRetry(2, arg, CommandOpts{Response: RSP_136, CheckCRC: true}, hw.CommandRetries); err != nil {
 		return
 	}
 
+	hw.cid = hw.response()
+	hw.parseCardCID()
+
 	// CMD3 - SEND_RELATIVE_ADDR - get relative card address (RCA)
-	if err = hw.cmd(3, READ, arg, RSP_48, true, true, false, 0); err != nil {
+	if err = hw.cmdRetry(3, arg, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}, hw.CommandRetries); err != nil {
 		return
 	}
 
 	if state := (hw.rsp(0) >> STATUS_CURRENT_STATE) & 0b1111; state != CURRENT_STATE_IDENT {
-		return fmt.Errorf("card not in ident state (%d)", state)
+		return fmt.Errorf("card not in ident state (%s)", StateName(state))
 	}
 
 	// clear clock
@@ -221,14 +449,23 @@ func (hw *USDHC) initSD() (err error) {
 	// set relative card address
 	hw.rca = hw.rsp(0) & (0xffff << RCA_ADDR)
 
+	hw.phase(&hw.timings.Identification)
+
 	err = hw.detectCapacitySD(SD_DEFAULT_BLOCK_SIZE)
 
 	if err != nil {
 		return
 	}
 
+	hw.phase(&hw.timings.Capacity)
+
+	// verify operating frequency against the card maximum one
+	if err = hw.verifyClock(DVS_OP, SDCLKFS_OP, false, tranSpeed(hw.rspVal(SD_CSD_TRAN_SPEED, 0xff), false)); err != nil {
+		return
+	}
+
 	// CMD7 - SELECT/DESELECT CARD - enter transfer state
-	if err = hw.cmd(7, READ, hw.rca, RSP_48_CHECK_BUSY, true, true, false, 0); err != nil {
+	if err = hw.cmd(7, hw.rca, CommandOpts{Response: RSP_48_CHECK_BUSY, CheckIndex: true, CheckCRC: true}); err != nil {
 		return
 	}
 
@@ -238,50 +475,72 @@ func (hw *USDHC) initSD() (err error) {
 		return
 	}
 
-	// CMD55 - APP_CMD - next command is application specific
-	if err = hw.cmd(55, READ, hw.rca, RSP_48, true, true, false, 0); err != nil {
+	hw.card.Locked = (hw.rsp(0)>>STATUS_CARD_IS_LOCKED)&1 == 1
+
+	// the card data bus is 1-bit wide until configured
+	if err = hw.setBusWidth(1); err != nil {
 		return
 	}
 
-	if ((hw.rsp(0) >> STATUS_APP_CMD) & 1) != 1 {
-		return fmt.Errorf("card not expecting application command")
+	if hw.card.SCR, err = hw.readSCR(); err != nil {
+		return
 	}
 
-	// p118, Table 4-31, SD-PL-7.10
-	switch hw.width {
-	case 1:
-		bus_width = 0b00
-	case 4:
-		bus_width = 0b10
-	default:
-		return errors.New("unsupported SD bus width")
+	hw.card.Width = hw.width
+
+	if hw.card.Width != 1 && !hw.card.SCR.BusWidth4 {
+		// the card lacks 4-bit support, fall back to 1-bit width
+		hw.card.Width = 1
 	}
 
-	// ACMD6 - SET_BUS_WIDTH - define the card data bus width
-	if err = hw.cmd(6, READ, uint32(bus_width), RSP_48, true, true, false, 0); err != nil {
+	if err = hw.setBusWidth(hw.card.Width); err != nil {
 		return
 	}
 
-	// Enable High Speed (HS) mode.
+	if err = hw.setBusWidthSD(hw.card.Width); err != nil {
+		return
+	}
+
+	// Enable UHS-I SDR104 or SDR50 mode, if supported, only available
+	// with 1.8V signaling and 4-bit data bus.
+	if hw.signaling18V && hw.card.Width == 4 {
+		var uhs bool
+
+		if uhs, err = hw.uhsSD(); err != nil || uhs {
+			return
+		}
+	}
+
+	// Enable High Speed (HS) mode, if supported.
 	//
-	// We do this unconditionally for now as only Non UHS SDXC/SDUC cards
-	// have optional HS mode support, while mandatory for all others.
+	// Only Non UHS SDXC/SDUC cards have optional HS mode support, while
+	// mandatory for all others, support is nonetheless verified as some
+	// cards misreport it and hang on the switch.
 	//
 	// p46, Table 3-10 : Bus Speed Mode Option / Mandatory, SD-PL-7.10
+	if (hw.card.SCR.Raw>>SCR_SD_SPEC)&0xf == 0 {
+		// Ver1.0 cards lack CMD6 support
+		return
+	}
 
-	// set `no influence` (0xf) for all functions except changed ones
-	arg = 0xffffffff
-	// set mode switch
-	bits.SetN(&arg, SD_SWITCH_MODE, 1, MODE_SWITCH)
-	// set HS access mode
-	bits.SetN(&arg, SD_SWITCH_ACCESS_MODE, 0b1111, ACCESS_MODE_HS)
+	hs, err := sdSwitchHS(func(mode uint32, accessMode uint32) (status []byte, err error) {
+		if status, err = hw.switchSD(mode, accessMode); err != nil || mode != MODE_SWITCH {
+			return
+		}
+
+		err = hw.waitState(CURRENT_STATE_TRAN, 500*time.Millisecond)
 
-	// CMD6 - SWITCH - switch mode of operation
-	if err = hw.cmd(6, READ, arg, RSP_48, true, true, false, 0); err != nil {
+		return
+	})
+
+	if err != nil || !hs {
+		// stay at default speed
 		return
 	}
 
-	err = hw.waitState(CURRENT_STATE_TRAN, 500*time.Millisecond)
+	if err = hw.verifyClock(DVS_HS, SDCLKFS_HS_SDR, false, SD_HS_FREQ); err != nil {
+		return
+	}
 
 	// clear clock
 	hw.setClock(0, 0)
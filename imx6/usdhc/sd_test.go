@@ -0,0 +1,221 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/f-secure-foundry/tamago/bits"
+)
+
+// synthetic SD card responses to CMD8 (SEND_IF_COND), CMD55 (APP_CMD) and
+// ACMD41 (SD_SEND_OP_COND)
+type sdCard struct {
+	// CMD8 support
+	v2 bool
+	// accepted CMD8 supply voltage (VHS)
+	vhs uint32
+	// CMD8 check pattern echo override
+	pattern uint32
+	// ACMD41 response
+	ocr uint32
+	// published RCA, 0 before identification
+	rca uint32
+	// CMD55 rejection
+	noAppCmd bool
+}
+
+func (c *sdCard) sendIfCond(arg uint32) (uint32, error) {
+	if !c.v2 || (arg>>CMD8_ARG_VHS)&0b1111 != c.vhs {
+		return 0, errors.New("CMD8:timeout")
+	}
+
+	if c.pattern != 0 {
+		return c.vhs<<CMD8_ARG_VHS | c.pattern, nil
+	}
+
+	return arg, nil
+}
+
+func (c *sdCard) sendAppCmd(arg uint32) (uint32, error) {
+	var status uint32
+
+	// identified cards only respond when addressed with their RCA
+	if c.rca != 0 && arg>>RCA_ADDR != c.rca {
+		return 0, errors.New("CMD55:timeout")
+	}
+
+	if c.rca == 0 {
+		bits.SetN(&status, STATUS_CURRENT_STATE, 0b1111, CURRENT_STATE_IDLE)
+	} else {
+		bits.SetN(&status, STATUS_CURRENT_STATE, 0b1111, CURRENT_STATE_STBY)
+	}
+
+	if !c.noAppCmd {
+		bits.Set(&status, STATUS_APP_CMD)
+	}
+
+	return status, nil
+}
+
+func TestSDAppCmd(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		card sdCard
+		rca  uint32
+		ok   bool
+	}{
+		{"before identification", sdCard{}, 0, true},
+		{"after identification", sdCard{rca: 0xaaaa}, 0xaaaa << RCA_ADDR, true},
+		{"default RCA after identification", sdCard{rca: 0xaaaa}, 0, false},
+		{"wrong RCA after identification", sdCard{rca: 0xaaaa}, 0x1234 << RCA_ADDR, false},
+		{"rejected", sdCard{rca: 0xaaaa, noAppCmd: true}, 0xaaaa << RCA_ADDR, false},
+	} {
+		var arg uint32
+
+		err := sdAppCmd(tc.rca, func(a uint32) (uint32, error) {
+			arg = a
+			return tc.card.sendAppCmd(a)
+		})
+
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%s: sdAppCmd(%#x) = %v, expected success %v", tc.name, tc.rca, err, tc.ok)
+		}
+
+		if arg != tc.rca {
+			t.Errorf("%s: CMD55 argument %#x, expected %#x", tc.name, arg, tc.rca)
+		}
+	}
+}
+
+func TestSDIfCond(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		card   sdCard
+		hcs    bool
+		ifCond int
+		arg    uint32
+		hc     bool
+	}{
+		{"1.x", sdCard{ocr: 0x80ff8000}, true, SD_IF_COND_V1, 0x00ff8000, false},
+		{"2.x-LC", sdCard{v2: true, vhs: VHS_HIGH, ocr: 0x80ff8000}, true, SD_IF_COND_V2, 0x50ff8000, false},
+		{"2.x-HC", sdCard{v2: true, vhs: VHS_HIGH, ocr: 0xc0ff8000}, true, SD_IF_COND_V2, 0x50ff8000, true},
+		{"2.x-HC without HCS", sdCard{v2: true, vhs: VHS_HIGH, ocr: 0x80ff8000}, false, SD_IF_COND_V2, 0x00ff8000, false},
+		{"2.x-LV", sdCard{v2: true, vhs: VHS_LOW, ocr: 0xc0000080}, true, SD_IF_COND_V2_LV, 0x50000080, true},
+		{"unusable", sdCard{v2: true, vhs: VHS_HIGH, pattern: 0x55}, true, SD_IF_COND_UNUSABLE, 0, false},
+	} {
+		ifCond := sdIfCond(tc.card.sendIfCond)
+
+		if ifCond != tc.ifCond {
+			t.Errorf("%s: sdIfCond() = %d, expected %d", tc.name, ifCond, tc.ifCond)
+			continue
+		}
+
+		if ifCond == SD_IF_COND_UNUSABLE {
+			continue
+		}
+
+		if arg := sdOpCond(ifCond, tc.hcs); arg != tc.arg {
+			t.Errorf("%s: sdOpCond() = %#x, expected %#x", tc.name, arg, tc.arg)
+		}
+
+		ready, hc := sdOpCondStatus(tc.card.ocr)
+
+		if !ready || hc != tc.hc {
+			t.Errorf("%s: sdOpCondStatus(%#x) = %v, %v, expected true, %v", tc.name, tc.card.ocr, ready, hc, tc.hc)
+		}
+	}
+}
+
+func TestSDOpCondBusy(t *testing.T) {
+	if ready, _ := sdOpCondStatus(0x40ff8000); ready {
+		t.Errorf("sdOpCondStatus(%#x) reports completed power up", 0x40ff8000)
+	}
+}
+
+// synthetic SD card switch function status for function group 1 (access mode)
+type sdSwitchCard struct {
+	// Support Bits of Functions in Function Group 1
+	support uint16
+	// function selected on switch, regardless of support
+	selected uint32
+	// CMD6 switch mode failure
+	hang bool
+	// CMD6 check mode failure
+	noCheck bool
+	// issued CMD6 modes
+	modes []uint32
+}
+
+func (c *sdSwitchCard) switchSD(mode uint32, accessMode uint32) ([]byte, error) {
+	status := make([]byte, SD_SWITCH_STATUS_SIZE)
+
+	c.modes = append(c.modes, mode)
+
+	if mode == MODE_SWITCH && c.hang {
+		return nil, errors.New("CMD6:timeout")
+	}
+
+	if mode == MODE_CHECK && c.noCheck {
+		return nil, errors.New("CMD6:timeout")
+	}
+
+	status[SD_SWITCH_STATUS_ACCESS_MODE_SUPPORT] = byte(c.support >> 8)
+	status[SD_SWITCH_STATUS_ACCESS_MODE_SUPPORT+1] = byte(c.support)
+
+	switch {
+	case mode == MODE_SWITCH && c.selected != 0:
+		status[SD_SWITCH_STATUS_ACCESS_MODE_SELECTION] = byte(c.selected)
+	case c.support&(1<<accessMode) != 0:
+		status[SD_SWITCH_STATUS_ACCESS_MODE_SELECTION] = byte(accessMode)
+	default:
+		status[SD_SWITCH_STATUS_ACCESS_MODE_SELECTION] = 0xf
+	}
+
+	return status, nil
+}
+
+func TestSDSwitchHS(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		card  sdSwitchCard
+		hs    bool
+		err   bool
+		modes []uint32
+	}{
+		{"supported", sdSwitchCard{support: 0x8003}, true, false, []uint32{MODE_CHECK, MODE_SWITCH}},
+		{"UHS-I", sdSwitchCard{support: 0x800f}, true, false, []uint32{MODE_CHECK, MODE_SWITCH}},
+		{"unsupported", sdSwitchCard{support: 0x8001}, false, false, []uint32{MODE_CHECK}},
+		{"switch error", sdSwitchCard{support: 0x8003, selected: 0xf}, false, false, []uint32{MODE_CHECK, MODE_SWITCH}},
+		{"switch hang", sdSwitchCard{support: 0x8003, hang: true}, false, true, []uint32{MODE_CHECK, MODE_SWITCH}},
+		{"check error", sdSwitchCard{support: 0x8003, noCheck: true}, false, false, []uint32{MODE_CHECK}},
+	} {
+		hs, err := sdSwitchHS(tc.card.switchSD)
+
+		if hs != tc.hs || (err != nil) != tc.err {
+			t.Errorf("%s: sdSwitchHS() = %v, %v, expected %v (error %v)", tc.name, hs, err, tc.hs, tc.err)
+		}
+
+		if len(tc.card.modes) != len(tc.modes) {
+			t.Errorf("%s: CMD6 modes %v, expected %v", tc.name, tc.card.modes, tc.modes)
+			continue
+		}
+
+		for i, mode := range tc.modes {
+			if tc.card.modes[i] != mode {
+				t.Errorf("%s: CMD6 modes %v, expected %v", tc.name, tc.card.modes, tc.modes)
+				break
+			}
+		}
+	}
+}
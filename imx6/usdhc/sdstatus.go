@@ -0,0 +1,182 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+)
+
+// SD Status register (4.10.2 SD Status, SD-PL-7.10)
+const (
+	SD_STATUS_SIZE = 64
+
+	// SPEED_CLASS [447:440]
+	SD_STATUS_SPEED_CLASS = 8
+	// AU_SIZE [431:428]
+	SD_STATUS_AU_SIZE = 10
+	// ERASE_SIZE [423:408]
+	SD_STATUS_ERASE_SIZE = 11
+	// ERASE_TIMEOUT [407:402], ERASE_OFFSET [401:400]
+	SD_STATUS_ERASE_TIMEOUT = 13
+	// UHS_SPEED_GRADE [399:396], UHS_AU_SIZE [395:392]
+	SD_STATUS_UHS_SPEED_GRADE = 14
+	// VIDEO_SPEED_CLASS [391:384]
+	SD_STATUS_VIDEO_SPEED_CLASS = 15
+)
+
+// SPEED_CLASS field values (4.10.2.2 SPEED_CLASS, SD-PL-7.10)
+var speedClasses = []int{0, 2, 4, 6, 10}
+
+// SDStatus represents the SD Status register.
+type SDStatus struct {
+	// Speed Class (0, 2, 4, 6 or 10), 0 for Class 0 or undefined
+	SpeedClass int
+	// UHS Speed Grade (0, 1 or 3)
+	UHSSpeedGrade int
+	// Video Speed Class (e.g. 6 for V6)
+	VideoSpeedClass int
+	// Allocation Unit size (bytes), 0 when not defined
+	AUSize int
+	// Number of AUs erased at once, 0 when erase timeout calculation is
+	// not supported
+	EraseSize int
+	// Timeout (seconds) for erasing EraseSize AUs
+	EraseTimeout int
+	// Fixed offset (seconds) to be added to erase timeouts
+	EraseOffset int
+
+	// Raw register value
+	Raw []byte
+}
+
+// parseSDStatus decodes the SD Status register, as transferred by ACMD13.
+func parseSDStatus(status []byte) (s SDStatus) {
+	if class := int(status[SD_STATUS_SPEED_CLASS]); class < len(speedClasses) {
+		s.SpeedClass = speedClasses[class]
+	}
+
+	s.UHSSpeedGrade = int(status[SD_STATUS_UHS_SPEED_GRADE] >> 4)
+	s.VideoSpeedClass = int(status[SD_STATUS_VIDEO_SPEED_CLASS])
+	s.AUSize = auSize(status)
+	s.EraseSize = int(status[SD_STATUS_ERASE_SIZE])<<8 | int(status[SD_STATUS_ERASE_SIZE+1])
+	s.EraseTimeout = int(status[SD_STATUS_ERASE_TIMEOUT] >> 2)
+	s.EraseOffset = int(status[SD_STATUS_ERASE_TIMEOUT] & 0b11)
+	s.Raw = status
+
+	return
+}
+
+// AU_SIZE field values (4.10.2.4 AU_SIZE, SD-PL-7.10)
+var auSizes = [16]int{
+	0,
+	16 * 1024, 32 * 1024, 64 * 1024, 128 * 1024,
+	256 * 1024, 512 * 1024, 1024 * 1024, 2 * 1024 * 1024,
+	4 * 1024 * 1024, 8 * 1024 * 1024, 12 * 1024 * 1024, 16 * 1024 * 1024,
+	24 * 1024 * 1024, 32 * 1024 * 1024, 64 * 1024 * 1024,
+}
+
+// sdStatus issues ACMD13 (SD_STATUS) to read the SD Status register.
+func (hw *USDHC) sdStatus() (status []byte, err error) {
+	status = make([]byte, SD_STATUS_SIZE)
+
+	// the transfer completes on the single block count
+	hw.noAC12 = true
+	hw.acmd = true
+
+	defer func() {
+		hw.noAC12 = false
+		hw.acmd = false
+	}()
+
+	// ACMD13 - SD_STATUS - read SD status
+	err = hw.transferArg(13, READ, 0, 1, SD_STATUS_SIZE, status)
+
+	return
+}
+
+// readSDStatus reads and decodes the SD Status register, which is retained for
+// DumpRegisters().
+//
+// The register is transferred as a single 64 bytes block, the transfer block
+// size is programmed for this command only and the card block length (CMD16)
+// is unaffected.
+func (hw *USDHC) readSDStatus() (s SDStatus, err error) {
+	status, err := hw.sdStatus()
+
+	if err != nil {
+		return
+	}
+
+	hw.sdStatusInfo = parseSDStatus(status)
+
+	return hw.sdStatusInfo, nil
+}
+
+// ReadSDStatus returns the SD Status register of the detected SD card,
+// reporting its performance classes and erase characteristics.
+func (hw *USDHC) ReadSDStatus() (s SDStatus, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.SD {
+		return s, ErrUnsupported
+	}
+
+	return hw.readSDStatus()
+}
+
+// auSize returns the Allocation Unit size (bytes) reported in the SD Status,
+// 0 is returned when not defined.
+func auSize(status []byte) int {
+	return auSizes[status[SD_STATUS_AU_SIZE]>>4]
+}
+
+// OptimalTransferSize returns a recommended size (bytes), and alignment, for
+// data transfers to minimize write amplification and maximize sustained
+// throughput.
+//
+// On SD cards the Allocation Unit (AU_SIZE) reported in the SD Status is
+// returned, as cards are optimized for writes which fill entire AUs. On eMMC
+// cards the high capacity erase group size is returned. In both cases the
+// card block size is returned when no such information is available.
+func (hw *USDHC) OptimalTransferSize() (size int, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	switch {
+	case hw.card.SD:
+		var status []byte
+
+		if status, err = hw.sdStatus(); err != nil {
+			return
+		}
+
+		size = auSize(status)
+	case hw.card.MMC:
+		var extCSD []byte
+
+		if extCSD, err = hw.readExtCSD(); err != nil {
+			return
+		}
+
+		// 7.4.101 HC_ERASE_GRP_SIZE [224], JESD84-B51
+		size = int(extCSD[EXT_CSD_HC_ERASE_GRP_SIZE]) * 512 * 1024
+	default:
+		return 0, errors.New("no card detected")
+	}
+
+	if size == 0 {
+		size = hw.card.BlockSize
+	}
+
+	return
+}
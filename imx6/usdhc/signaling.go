@@ -0,0 +1,86 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/f-secure-foundry/tamago/bits"
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+const (
+	VEND_SPEC_VSELECT = 1
+
+	// DAT[3:0] line signal level (PRES_STATE DLSL[3:0])
+	PRES_STATE_DLSL = 24
+
+	// Signal Voltage Switch Sequence, SD-PL-7.10
+	VOLTAGE_SWITCH_DELAY  = 5 * time.Millisecond
+	VOLTAGE_SWITCH_SETTLE = 1 * time.Millisecond
+)
+
+// setSignaling18V selects the controller I/O signaling voltage (VSELECT),
+// either 1.8V (true) or 3.3V (false), the VSELECT signal must be wired to
+// the board I/O voltage regulator.
+func (hw *USDHC) setSignaling18V(enable bool) {
+	if enable {
+		reg.Set(hw.vend_spec, VEND_SPEC_VSELECT)
+	} else {
+		reg.Clear(hw.vend_spec, VEND_SPEC_VSELECT)
+	}
+}
+
+// switchVoltage performs the SD signal voltage switch sequence, from 3.3V to
+// 1.8V signaling, which must take place in ready state (right after voltage
+// validation) on cards which accepted it (ACMD41 S18A).
+//
+// On failure the card must be power cycled before any further attempt.
+//
+// Signal Voltage Switch Sequence, SD-PL-7.10
+func (hw *USDHC) switchVoltage() (err error) {
+	if bits.Get(&hw.ocr, SD_OCR_S18R, 1) != 1 {
+		return errors.New("1.8V signaling not accepted")
+	}
+
+	// CMD11 - VOLTAGE_SWITCH - switch to 1.8V bus signaling level
+	if err = hw.cmd(11, 0, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	// stop the card clock
+	reg.Clear(hw.sys_ctrl, SYS_CTRL_SDCLKEN)
+
+	// the card drives DAT[3:0] low until the clock is restarted
+	if reg.Get(hw.pres_state, PRES_STATE_DLSL, 0xf) != 0 {
+		reg.Set(hw.sys_ctrl, SYS_CTRL_SDCLKEN)
+		return errors.New("voltage switch not acknowledged")
+	}
+
+	hw.setSignaling18V(true)
+	time.Sleep(VOLTAGE_SWITCH_DELAY)
+
+	// restart the card clock
+	reg.Set(hw.sys_ctrl, SYS_CTRL_SDCLKEN)
+	time.Sleep(VOLTAGE_SWITCH_SETTLE)
+
+	// the card drives DAT[3:0] high on voltage switch completion
+	if reg.Get(hw.pres_state, PRES_STATE_DLSL, 0xf) != 0xf {
+		hw.setSignaling18V(false)
+		return errors.New("voltage switch failed")
+	}
+
+	hw.signaling18V = true
+
+	return
+}
@@ -0,0 +1,161 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"time"
+)
+
+// eMMC sleep constants (6.6.21 Sleep (CMD5), JESD84-B51)
+const (
+	SLEEP_AWAKE_SLEEP = 15
+
+	// 7.4.7 POWER_OFF_NOTIFICATION [34], JESD84-B51
+	POWERED_ON         = 0x01
+	SLEEP_NOTIFICATION = 0x04
+)
+
+// sleepAwakeTimeout returns the eMMC sleep/awake transition timeout
+// (7.4.94 S_A_TIMEOUT [217], JESD84-B51).
+func sleepAwakeTimeout(extCSD []byte) time.Duration {
+	val := extCSD[EXT_CSD_S_A_TIMEOUT]
+
+	if val == 0 || val > 0x17 {
+		return 0
+	}
+
+	// 100ns * 2^S_A_TIMEOUT
+	return 100 * time.Nanosecond * (1 << val)
+}
+
+// sleepNotificationTimeout returns the eMMC sleep notification timeout
+// (7.4.93 SLEEP_NOTIFICATION_TIME [216], JESD84-B51).
+func sleepNotificationTimeout(extCSD []byte) time.Duration {
+	val := extCSD[EXT_CSD_SLEEP_NOTIFICATION_TIME]
+
+	if val == 0 || val > 0x17 {
+		return 0
+	}
+
+	// 10us * 2^SLEEP_NOTIFICATION_TIME
+	return 10 * time.Microsecond * (1 << val)
+}
+
+// sleepAwake issues CMD5 (SLEEP_AWAKE) to transition the eMMC card between
+// stand-by and sleep states.
+func (hw *USDHC) sleepAwake(sleep bool, timeout time.Duration) (err error) {
+	arg := hw.rca
+
+	if sleep {
+		arg |= 1 << SLEEP_AWAKE_SLEEP
+	}
+
+	// CMD5 - SLEEP_AWAKE - toggle sleep state
+	return hw.cmd(5, arg, CommandOpts{Response: RSP_48_CHECK_BUSY, CheckIndex: true, CheckCRC: true, Timeout: timeout})
+}
+
+// Sleep moves the eMMC card to its low power sleep state, the card is
+// deselected before entering it.
+//
+// No data access is possible until Awake() is invoked.
+func (hw *USDHC) Sleep() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return errors.New("sleep is only supported on MMC cards")
+	}
+
+	if hw.sleeping {
+		return errors.New("card is already sleeping")
+	}
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, 1*time.Millisecond); err != nil {
+		return
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	timeout := sleepAwakeTimeout(extCSD)
+
+	if timeout == 0 {
+		timeout = hw.writeTimeout
+	}
+
+	if extCSD[EXT_CSD_POWER_OFF_NOTIFICATION] == POWERED_ON {
+		notificationTimeout := sleepNotificationTimeout(extCSD)
+
+		if notificationTimeout == 0 {
+			notificationTimeout = hw.writeTimeout
+		}
+
+		if err = hw.switchMMC(EXT_CSD_POWER_OFF_NOTIFICATION, SLEEP_NOTIFICATION, notificationTimeout); err != nil {
+			return
+		}
+	}
+
+	// CMD7 - SELECT/DESELECT CARD - enter stand-by state
+	if err = hw.cmd(7, 0, CommandOpts{Response: RSP_NONE}); err != nil {
+		return
+	}
+
+	if err = hw.waitState(CURRENT_STATE_STBY, 1*time.Millisecond); err != nil {
+		return
+	}
+
+	if err = hw.sleepAwake(true, timeout); err != nil {
+		return
+	}
+
+	hw.sleeping = true
+
+	return
+}
+
+// Awake moves the eMMC card out of its sleep state, the card is reselected
+// after leaving it.
+func (hw *USDHC) Awake() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.sleeping {
+		return errors.New("card is not sleeping")
+	}
+
+	timeout := sleepAwakeTimeout(hw.extCSD)
+
+	if timeout == 0 {
+		timeout = hw.writeTimeout
+	}
+
+	if err = hw.sleepAwake(false, timeout); err != nil {
+		return
+	}
+
+	if err = hw.waitState(CURRENT_STATE_STBY, 1*time.Millisecond); err != nil {
+		return
+	}
+
+	hw.sleeping = false
+
+	// CMD7 - SELECT/DESELECT CARD - enter transfer state
+	if err = hw.cmd(7, hw.rca, CommandOpts{Response: RSP_48_CHECK_BUSY, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	return hw.waitState(CURRENT_STATE_TRAN, 1*time.Millisecond)
+}
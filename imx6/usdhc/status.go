@@ -0,0 +1,167 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CardStatus represents the card status, as returned in R1 responses (p131,
+// Table 4-42 : Card Status, SD-PL-7.10, p160, Table 68 - Device Status,
+// JESD84-B51). Bits defined for a single card type are always reported as
+// zero by the other one.
+type CardStatus struct {
+	// Error bits
+	OutOfRange       bool
+	AddressError     bool
+	BlockLenError    bool
+	EraseSeqError    bool
+	EraseParam       bool
+	WPViolation      bool
+	LockUnlockFailed bool
+	ComCRCError      bool
+	IllegalCommand   bool
+	CardECCFailed    bool
+	CCError          bool
+	Error            bool
+	CSDOverwrite     bool
+	// Error bits, MMC only
+	Underrun    bool
+	Overrun     bool
+	SwitchError bool
+	// Error bits, SD only
+	AKESeqError bool
+
+	// Status bits
+	CardIsLocked bool
+	WPEraseSkip  bool
+	EraseReset   bool
+	ReadyForData bool
+	AppCmd       bool
+	// Status bits, SD only
+	CardECCDisabled bool
+	// Extension functions event (FX_EVENT) on SD cards, exception event
+	// (EXCEPTION_EVENT) on MMC cards
+	Event bool
+
+	// Current card state (see StateName())
+	State uint32
+
+	// Raw register value
+	Raw uint32
+}
+
+// statusFlags maps card status bits to their names, in descending order.
+var statusFlags = []struct {
+	pos  int
+	name string
+}{
+	{STATUS_OUT_OF_RANGE, "OUT_OF_RANGE"},
+	{STATUS_ADDRESS_ERROR, "ADDRESS_ERROR"},
+	{STATUS_BLOCK_LEN_ERROR, "BLOCK_LEN_ERROR"},
+	{STATUS_ERASE_SEQ_ERROR, "ERASE_SEQ_ERROR"},
+	{STATUS_ERASE_PARAM, "ERASE_PARAM"},
+	{STATUS_WP_VIOLATION, "WP_VIOLATION"},
+	{STATUS_CARD_IS_LOCKED, "CARD_IS_LOCKED"},
+	{STATUS_LOCK_UNLOCK_FAILED, "LOCK_UNLOCK_FAILED"},
+	{STATUS_COM_CRC_ERROR, "COM_CRC_ERROR"},
+	{STATUS_ILLEGAL_COMMAND, "ILLEGAL_COMMAND"},
+	{STATUS_CARD_ECC_FAILED, "CARD_ECC_FAILED"},
+	{STATUS_CC_ERROR, "CC_ERROR"},
+	{STATUS_ERROR, "ERROR"},
+	{STATUS_UNDERRUN, "UNDERRUN"},
+	{STATUS_OVERRUN, "OVERRUN"},
+	{STATUS_CSD_OVERWRITE, "CSD_OVERWRITE"},
+	{STATUS_WP_ERASE_SKIP, "WP_ERASE_SKIP"},
+	{STATUS_CARD_ECC_DISABLED, "CARD_ECC_DISABLED"},
+	{STATUS_ERASE_RESET, "ERASE_RESET"},
+	{STATUS_READY_FOR_DATA, "READY_FOR_DATA"},
+	{STATUS_SWITCH_ERROR, "SWITCH_ERROR"},
+	{STATUS_EXCEPTION_EVENT, "EVENT"},
+	{STATUS_APP_CMD, "APP_CMD"},
+	{STATUS_AKE_SEQ_ERROR, "AKE_SEQ_ERROR"},
+}
+
+// parseCardStatus decodes the card status.
+func parseCardStatus(r uint32) (s CardStatus) {
+	bit := func(pos int) bool {
+		return (r>>pos)&1 == 1
+	}
+
+	s.Raw = r
+	s.OutOfRange = bit(STATUS_OUT_OF_RANGE)
+	s.AddressError = bit(STATUS_ADDRESS_ERROR)
+	s.BlockLenError = bit(STATUS_BLOCK_LEN_ERROR)
+	s.EraseSeqError = bit(STATUS_ERASE_SEQ_ERROR)
+	s.EraseParam = bit(STATUS_ERASE_PARAM)
+	s.WPViolation = bit(STATUS_WP_VIOLATION)
+	s.CardIsLocked = bit(STATUS_CARD_IS_LOCKED)
+	s.LockUnlockFailed = bit(STATUS_LOCK_UNLOCK_FAILED)
+	s.ComCRCError = bit(STATUS_COM_CRC_ERROR)
+	s.IllegalCommand = bit(STATUS_ILLEGAL_COMMAND)
+	s.CardECCFailed = bit(STATUS_CARD_ECC_FAILED)
+	s.CCError = bit(STATUS_CC_ERROR)
+	s.Error = bit(STATUS_ERROR)
+	s.Underrun = bit(STATUS_UNDERRUN)
+	s.Overrun = bit(STATUS_OVERRUN)
+	s.CSDOverwrite = bit(STATUS_CSD_OVERWRITE)
+	s.WPEraseSkip = bit(STATUS_WP_ERASE_SKIP)
+	s.CardECCDisabled = bit(STATUS_CARD_ECC_DISABLED)
+	s.EraseReset = bit(STATUS_ERASE_RESET)
+	s.State = (r >> STATUS_CURRENT_STATE) & 0b1111
+	s.ReadyForData = bit(STATUS_READY_FOR_DATA)
+	s.SwitchError = bit(STATUS_SWITCH_ERROR)
+	s.Event = bit(STATUS_EXCEPTION_EVENT)
+	s.AppCmd = bit(STATUS_APP_CMD)
+	s.AKESeqError = bit(STATUS_AKE_SEQ_ERROR)
+
+	return
+}
+
+// String returns the card status value, state and set bit names.
+func (s CardStatus) String() string {
+	var flags []string
+
+	for _, f := range statusFlags {
+		if (s.Raw>>f.pos)&1 == 1 {
+			flags = append(flags, f.name)
+		}
+	}
+
+	return fmt.Sprintf("card status:%#x state:%s [%s]", s.Raw, StateName(s.State), strings.Join(flags, " "))
+}
+
+// cardStatus issues CMD13 (SEND_STATUS) to read the card status.
+func (hw *USDHC) cardStatus() (s CardStatus, err error) {
+	// CMD13 - SEND_STATUS - poll card status
+	if err = hw.cmd(13, hw.rca, CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true}); err != nil {
+		return
+	}
+
+	return parseCardStatus(hw.rsp(0)), nil
+}
+
+// Status returns the detected card status, as read with CMD13
+// (SEND_STATUS). Error bits are cleared by the card once reported, except
+// for those reflecting its current condition (e.g. CARD_IS_LOCKED).
+func (hw *USDHC) Status() (s CardStatus, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.SD && !hw.card.MMC {
+		return s, errors.New("no card detected")
+	}
+
+	return hw.cardStatus()
+}
@@ -0,0 +1,57 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+// TemperatureDecoder represents a vendor specific method for retrieving the
+// device temperature from the eMMC EXT_CSD register vendor specific fields.
+type TemperatureDecoder struct {
+	// Vendor name
+	Vendor string
+	// Decode returns the device temperature, in degrees Celsius, from the
+	// EXT_CSD register
+	Decode func(extCSD []byte) (int, error)
+}
+
+// TemperatureDecoders holds vendor specific temperature decoders indexed by
+// CID Manufacturer ID (MID).
+//
+// The JEDEC standard does not define a temperature field, therefore no
+// decoder is provided by default and vendor documented ones can be added for
+// the parts in use.
+var TemperatureDecoders = map[uint32]*TemperatureDecoder{}
+
+// Temperature returns, on a best-effort basis, the device temperature (in
+// degrees Celsius) reported by eMMC cards of vendors listed in
+// TemperatureDecoders. ErrUnsupported is returned for unrecognized cards.
+func (hw *USDHC) Temperature() (celsius int, err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if !hw.card.MMC {
+		return 0, ErrUnsupported
+	}
+
+	mid := ResponseField(hw.cid, CID_MID, 0xff)
+	dec, ok := TemperatureDecoders[mid]
+
+	if !ok {
+		return 0, ErrUnsupported
+	}
+
+	extCSD, err := hw.readExtCSD()
+
+	if err != nil {
+		return
+	}
+
+	return dec.Decode(extCSD)
+}
@@ -0,0 +1,166 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// controller transfer, as issued by splitTransfer
+type xfr struct {
+	offset uint64
+	blocks uint32
+	size   int
+}
+
+func TestSplitTransfer(t *testing.T) {
+	const blockSize = 512
+
+	for _, tc := range []struct {
+		blocks uint32
+		exp    []xfr
+	}{
+		{1, []xfr{{0, 1, 512}}},
+		{MAX_TRANSFER_BLOCKS, []xfr{{0, MAX_TRANSFER_BLOCKS, MAX_TRANSFER_BLOCKS * blockSize}}},
+		{65536, []xfr{
+			{0, MAX_TRANSFER_BLOCKS, MAX_TRANSFER_BLOCKS * blockSize},
+			{MAX_TRANSFER_BLOCKS * blockSize, 1, blockSize},
+		}},
+		{2*MAX_TRANSFER_BLOCKS + 2, []xfr{
+			{0, MAX_TRANSFER_BLOCKS, MAX_TRANSFER_BLOCKS * blockSize},
+			{MAX_TRANSFER_BLOCKS * blockSize, MAX_TRANSFER_BLOCKS, MAX_TRANSFER_BLOCKS * blockSize},
+			{2 * MAX_TRANSFER_BLOCKS * blockSize, 2, 2 * blockSize},
+		}},
+	} {
+		var res []xfr
+
+		buf := make([]byte, int(tc.blocks)*blockSize)
+
+		// mark each block with its index, to verify that the buffer
+		// portions match the transfers
+		for i := 0; i < int(tc.blocks); i++ {
+			buf[i*blockSize] = byte(i)
+		}
+
+		err := splitTransfer(0, tc.blocks, blockSize, buf, func(offset uint64, blocks uint32, buf []byte) error {
+			if buf[0] != byte(offset/blockSize) {
+				t.Errorf("%d blocks: buffer at offset %#x starts with block %d", tc.blocks, offset, buf[0])
+			}
+
+			res = append(res, xfr{offset, blocks, len(buf)})
+			return nil
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res) != len(tc.exp) {
+			t.Errorf("%d blocks: %d transfers, expected %d", tc.blocks, len(res), len(tc.exp))
+			continue
+		}
+
+		for i := range res {
+			if res[i] != tc.exp[i] {
+				t.Errorf("%d blocks: transfer %d = %+v, expected %+v", tc.blocks, i, res[i], tc.exp[i])
+			}
+		}
+	}
+}
+
+func TestOutOfRange(t *testing.T) {
+	for _, tc := range []struct {
+		rsp      uint32
+		ac12Rsp  uint32
+		ac12     bool
+		expected bool
+	}{
+		{0x00000900, 0x00000900, true, false},
+		{0x80000900, 0x00000900, false, true},
+		{0x00000900, 0x80000b00, true, true},
+		{0x00000900, 0x80000b00, false, false},
+	} {
+		if res := outOfRange(tc.rsp, tc.ac12Rsp, tc.ac12); res != tc.expected {
+			t.Errorf("outOfRange(%#x, %#x, %v) = %v, expected %v", tc.rsp, tc.ac12Rsp, tc.ac12, res, tc.expected)
+		}
+	}
+}
+
+func TestBlocksOutOfRange(t *testing.T) {
+	const blocks = 1024
+
+	extCSD := make([]byte, MMC_DEFAULT_BLOCK_SIZE)
+	// 128 KiB boot partitions
+	extCSD[EXT_CSD_BOOT_SIZE_MULT] = 1
+
+	hw := &USDHC{
+		card:      CardInfo{MMC: true, BlockSize: 512, Blocks: blocks},
+		partition: "user",
+		extCSD:    extCSD,
+	}
+
+	for _, tc := range []struct {
+		lba    int
+		blocks int
+	}{
+		{blocks, 1},
+		{blocks - 4, 8},
+		{-1, 1},
+		{1 << 30, 1},
+		// lba + blocks overflows int on 32-bit architectures
+		{math.MaxInt32, 1},
+	} {
+		if _, err := hw.ReadBlocks(tc.lba, tc.blocks); !errors.Is(err, ErrAddressOutOfRange) {
+			t.Errorf("ReadBlocks(%d, %d) = %v, expected %v", tc.lba, tc.blocks, err, ErrAddressOutOfRange)
+		}
+
+		if err := hw.WriteBlocks(tc.lba, make([]byte, tc.blocks*512)); tc.blocks > 0 && !errors.Is(err, ErrAddressOutOfRange) {
+			t.Errorf("WriteBlocks(%d, %d) = %v, expected %v", tc.lba, tc.blocks*512, err, ErrAddressOutOfRange)
+		}
+	}
+
+	if err := hw.checkRange(blocks-1, 1); err != nil {
+		t.Errorf("checkRange(%d, 1) = %v, expected success", blocks-1, err)
+	}
+
+	hw.partition = "boot1"
+
+	if err := hw.checkRange(255, 1); err != nil {
+		t.Errorf("boot1: checkRange(255, 1) = %v, expected success", err)
+	}
+
+	if _, err := hw.ReadBlocks(256, 1); !errors.Is(err, ErrAddressOutOfRange) {
+		t.Errorf("boot1: ReadBlocks(256, 1) = %v, expected %v", err, ErrAddressOutOfRange)
+	}
+}
+
+func TestWatermarkLevel(t *testing.T) {
+	for _, tc := range []struct {
+		blockSize uint32
+		expected  uint32
+	}{
+		{8, 2},
+		{64, 16},
+		{512, 128},
+		{1024, 128},
+		{4096, 128},
+		{1536, 128},
+		{1000, 125},
+		{2, 1},
+	} {
+		if res := watermarkLevel(tc.blockSize); res != tc.expected {
+			t.Errorf("watermarkLevel(%d) = %d, expected %d", tc.blockSize, res, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"github.com/f-secure-foundry/tamago/bits"
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+const (
+	// ADMA Error Status Register (uSDHCx_ADMA_ERR_STATUS), IMX6ULLRM
+	ADMA_ERR_STATUS_ADMADCE = 3
+	ADMA_ERR_STATUS_ADMALME = 2
+	ADMA_ERR_STATUS_ADMAES  = 0
+
+	ADMAES_ST_STOP = 0b00
+	ADMAES_ST_FDS  = 0b01
+	ADMAES_ST_TFR  = 0b11
+)
+
+// Transfer methods
+const (
+	TRANSFER_NONE = iota
+	TRANSFER_PIO
+	TRANSFER_SDMA
+	TRANSFER_ADMA1
+	TRANSFER_ADMA2
+)
+
+// TransferState represents the controller data transfer state, for
+// diagnostic purposes.
+type TransferState struct {
+	// Transfer method (TRANSFER_*) of the current, or last, data transfer
+	Method int
+	// Data lines active (data transfer in progress)
+	Active bool
+	// Transfer block size
+	BlockSize int
+	// Remaining blocks
+	BlockCount int
+	// ADMA descriptor address, pointing to the descriptor being executed
+	// (or the one following it once fetched)
+	DescriptorAddress uint32
+	// ADMA state (ADMAES_ST_*) at the time of the last ADMA error
+	ADMAState int
+	// ADMA descriptor (ADMADCE) or length mismatch (ADMALME) error
+	ADMADescriptorError bool
+	ADMALengthError     bool
+}
+
+// TransferState returns the controller data transfer state, including the
+// in-flight ADMA descriptor address, as reported by controller registers.
+//
+// To allow diagnosing stuck transfers, the state is read without waiting for
+// any ongoing operation to complete and without any side effect on the
+// controller.
+func (hw *USDHC) TransferState() (s TransferState) {
+	if hw.cg == 0 {
+		return
+	}
+
+	pres := reg.Read(hw.pres_state)
+	mix := reg.Read(hw.mix_ctrl)
+	prot := reg.Read(hw.prot_ctrl)
+	blk := reg.Read(hw.blk_att)
+	adma := reg.Read(hw.adma_err_status)
+
+	switch {
+	case hw.pioBuf != nil:
+		s.Method = TRANSFER_PIO
+	case bits.Get(&mix, MIX_CTRL_DMAEN, 1) == 0:
+		s.Method = TRANSFER_NONE
+	default:
+		switch bits.Get(&prot, PROT_CTRL_DMASEL, 0b11) {
+		case DMASEL_NONE:
+			s.Method = TRANSFER_SDMA
+		case DMASEL_ADMA1:
+			s.Method = TRANSFER_ADMA1
+		case DMASEL_ADMA2:
+			s.Method = TRANSFER_ADMA2
+		}
+	}
+
+	s.Active = bits.Get(&pres, PRES_STATE_DLA, 1) == 1
+	s.BlockSize = int(bits.Get(&blk, BLK_ATT_BLKSIZE, 0x1fff))
+	s.BlockCount = int(bits.Get(&blk, BLK_ATT_BLKCNT, 0xffff))
+
+	if s.Method == TRANSFER_ADMA1 || s.Method == TRANSFER_ADMA2 {
+		s.DescriptorAddress = reg.Read(hw.adma_sys_addr)
+		s.ADMAState = int(bits.Get(&adma, ADMA_ERR_STATUS_ADMAES, 0b11))
+		s.ADMADescriptorError = bits.Get(&adma, ADMA_ERR_STATUS_ADMADCE, 1) == 1
+		s.ADMALengthError = bits.Get(&adma, ADMA_ERR_STATUS_ADMALME, 1) == 1
+	}
+
+	return
+}
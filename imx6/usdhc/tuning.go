@@ -0,0 +1,183 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/f-secure-foundry/tamago/bits"
+	"github.com/f-secure-foundry/tamago/internal/reg"
+)
+
+// Tuning registers (58.8 uSDHC Memory Map/Register Definition, IMX6ULLRM).
+const (
+	USDHCx_TUNE_CTRL_STATUS           = 0x68
+	TUNE_CTRL_STATUS_DLY_CELL_SET_PRE = 8
+)
+
+// Tuning constants
+const (
+	// manual tuning delay cell range and step
+	TUNING_DELAY_MIN  = 0
+	TUNING_DELAY_MAX  = 128
+	TUNING_DELAY_STEP = 1
+
+	// delay applied before each tuning block read, allowing the card to
+	// recover from errors on the previous one
+	TUNING_DELAY = 1 * time.Millisecond
+
+	// Tuning Command (CMD19), SD-PL-7.10
+	SD_TUNING_BLOCK_SIZE = 64
+	// 6.6.5.1 Sampling Tuning Sequence for HS200, JESD84-B51
+	MMC_TUNING_BLOCK_SIZE = 128
+)
+
+// tuningBlock4Bit is the tuning block pattern returned by CMD19 on a 4-bit
+// data bus (Tuning Block Pattern, SD-PL-7.10).
+var tuningBlock4Bit = []byte{
+	0xff, 0x0f, 0xff, 0x00, 0xff, 0xcc, 0xc3, 0xcc,
+	0xc3, 0x3c, 0xcc, 0xff, 0xfe, 0xff, 0xfe, 0xef,
+	0xff, 0xdf, 0xff, 0xdd, 0xff, 0xfb, 0xff, 0xfb,
+	0xbf, 0xff, 0x7f, 0xff, 0x77, 0xf7, 0xbd, 0xef,
+	0xff, 0xf0, 0xff, 0xf0, 0x0f, 0xfc, 0xcc, 0x3c,
+	0xcc, 0x33, 0xcc, 0xcf, 0xff, 0xef, 0xff, 0xee,
+	0xff, 0xfd, 0xff, 0xfd, 0xdf, 0xff, 0xbf, 0xff,
+	0xbb, 0xff, 0xf7, 0xff, 0xf7, 0x7f, 0x7b, 0xde,
+}
+
+// tuningBlock8Bit is the tuning block pattern returned by CMD21 on an 8-bit
+// data bus (6.6.5.1 Sampling Tuning Sequence for HS200, JESD84-B51).
+var tuningBlock8Bit = []byte{
+	0xff, 0xff, 0x00, 0xff, 0xff, 0xff, 0x00, 0x00,
+	0xff, 0xff, 0xcc, 0xcc, 0xcc, 0x33, 0xcc, 0xcc,
+	0xcc, 0x33, 0x33, 0xcc, 0xcc, 0xcc, 0xff, 0xff,
+	0xff, 0xee, 0xff, 0xff, 0xff, 0xee, 0xee, 0xff,
+	0xff, 0xff, 0xdd, 0xff, 0xff, 0xff, 0xdd, 0xdd,
+	0xff, 0xff, 0xff, 0xbb, 0xff, 0xff, 0xff, 0xbb,
+	0xbb, 0xff, 0xff, 0xff, 0x77, 0xff, 0xff, 0xff,
+	0x77, 0x77, 0xff, 0x77, 0xbb, 0xdd, 0xee, 0xff,
+	0xff, 0xff, 0xff, 0x00, 0xff, 0xff, 0xff, 0x00,
+	0x00, 0xff, 0xff, 0xcc, 0xcc, 0xcc, 0x33, 0xcc,
+	0xcc, 0xcc, 0x33, 0x33, 0xcc, 0xcc, 0xcc, 0xff,
+	0xff, 0xff, 0xee, 0xff, 0xff, 0xff, 0xee, 0xee,
+	0xff, 0xff, 0xff, 0xdd, 0xff, 0xff, 0xff, 0xdd,
+	0xdd, 0xff, 0xff, 0xff, 0xbb, 0xff, 0xff, 0xff,
+	0xbb, 0xbb, 0xff, 0xff, 0xff, 0x77, 0xff, 0xff,
+	0xff, 0x77, 0x77, 0xff, 0x77, 0xbb, 0xdd, 0xee,
+}
+
+// prepareTuning enables manual tuning with the argument delay cell setting.
+func (hw *USDHC) prepareTuning(delay int) {
+	time.Sleep(TUNING_DELAY)
+
+	mix := reg.Read(hw.mix_ctrl)
+	bits.Set(&mix, MIX_CTRL_EXE_TUNE)
+	bits.Set(&mix, MIX_CTRL_SMP_CLK_SEL)
+	bits.Set(&mix, MIX_CTRL_FBCLK_SEL)
+	reg.Write(hw.mix_ctrl, mix)
+
+	reg.Write(hw.tune_ctrl_status, uint32(delay)<<TUNE_CTRL_STATUS_DLY_CELL_SET_PRE)
+}
+
+// resetTuning disables tuning, restoring the fixed sampling clock.
+func (hw *USDHC) resetTuning() {
+	mix := reg.Read(hw.mix_ctrl)
+	bits.Clear(&mix, MIX_CTRL_EXE_TUNE)
+	bits.Clear(&mix, MIX_CTRL_SMP_CLK_SEL)
+	bits.Clear(&mix, MIX_CTRL_FBCLK_SEL)
+	bits.Clear(&mix, MIX_CTRL_AUTO_TUNE_EN)
+	reg.Write(hw.mix_ctrl, mix)
+
+	reg.Write(hw.tune_ctrl_status, 0)
+
+	hw.setCRCConflictCheck(false)
+}
+
+// sendTuning reads the tuning block, returning whether it has been received
+// correctly.
+func (hw *USDHC) sendTuning(index uint32) bool {
+	pattern := tuningBlock4Bit
+
+	if hw.card.Width == 8 {
+		pattern = tuningBlock8Bit
+	}
+
+	buf := make([]byte, len(pattern))
+
+	// the transfer completes on the single block count
+	hw.noAC12 = true
+	defer func() { hw.noAC12 = false }()
+
+	if err := hw.transferArg(index, READ, 0, 1, uint32(len(buf)), buf); err != nil {
+		return false
+	}
+
+	return bytes.Equal(buf, pattern)
+}
+
+// executeTuning performs manual tuning of the sampling clock with the argument
+// tuning command (CMD19 for SD cards, CMD21 for eMMC cards), the delay cell
+// range is scanned to find the window of settings sampling the tuning block
+// correctly, its center is then applied.
+//
+// On failure tuning is disabled and an error is returned, the card must then
+// be switched to a mode not requiring it.
+func (hw *USDHC) executeTuning(index uint32) (err error) {
+	min := TUNING_DELAY_MIN
+
+	// find the minimum delay passing tuning
+	for ; min < TUNING_DELAY_MAX; min += TUNING_DELAY_STEP {
+		hw.prepareTuning(min)
+
+		if hw.sendTuning(index) {
+			break
+		}
+	}
+
+	if min >= TUNING_DELAY_MAX {
+		hw.resetTuning()
+		return errors.New("tuning failed, no sampling point found")
+	}
+
+	// find the maximum delay passing tuning
+	max := min
+
+	for max+TUNING_DELAY_STEP < TUNING_DELAY_MAX {
+		hw.prepareTuning(max + TUNING_DELAY_STEP)
+
+		if !hw.sendTuning(index) {
+			break
+		}
+
+		max += TUNING_DELAY_STEP
+	}
+
+	// apply the window center
+	hw.prepareTuning((min + max) / 2)
+
+	if !hw.sendTuning(index) {
+		hw.resetTuning()
+		return errors.New("tuning failed, no stable sampling point found")
+	}
+
+	// tuning complete, retain the tuned sampling clock
+	mix := reg.Read(hw.mix_ctrl)
+	bits.Clear(&mix, MIX_CTRL_EXE_TUNE)
+	bits.Set(&mix, MIX_CTRL_AUTO_TUNE_EN)
+	reg.Write(hw.mix_ctrl, mix)
+
+	hw.setCRCConflictCheck(true)
+
+	return
+}
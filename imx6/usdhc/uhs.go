@@ -0,0 +1,103 @@
+// NXP Ultra Secured Digital Host Controller (uSDHC) driver
+// https://github.com/f-secure-foundry/tamago
+//
+// IP: https://www.mobiveil.com/esdhc/
+//
+// Copyright (c) F-Secure Corporation
+// https://foundry.f-secure.com
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package usdhc
+
+import (
+	"time"
+)
+
+// switchAccessModeSD switches the card to the argument UHS-I access mode and
+// applies the corresponding card clock, returning whether the mode has been
+// selected by the card.
+func (hw *USDHC) switchAccessModeSD(accessMode uint32, dvs int, sdclkfs int) (selected bool, err error) {
+	status, err := hw.switchSD(MODE_SWITCH, accessMode)
+
+	if err != nil {
+		return
+	}
+
+	if err = hw.waitState(CURRENT_STATE_TRAN, 500*time.Millisecond); err != nil {
+		return
+	}
+
+	if sdSwitchSelected(status) != accessMode {
+		return
+	}
+
+	// clear clock
+	hw.setClock(0, 0)
+	// set access mode frequency
+	hw.setClock(dvs, sdclkfs)
+
+	return true, nil
+}
+
+// uhsSD enables the fastest UHS-I mode (SDR104 or SDR50) supported by the
+// card, returning whether any has been selected. The card must be operating
+// with 1.8V signaling and a 4-bit data bus.
+//
+// SDR104 requires sampling clock tuning, on its failure SDR50 is selected
+// instead, if supported.
+//
+// p37, 3.9.1 UHS-I Bus Speed Modes, SD-PL-7.10
+func (hw *USDHC) uhsSD() (uhs bool, err error) {
+	status, err := hw.switchSD(MODE_CHECK, 0xf)
+
+	if err != nil {
+		return
+	}
+
+	// modes not allowed by the base clock are not attempted
+	sdr104 := sdSwitchSupported(status, ACCESS_MODE_SDR104) &&
+		hw.verifyClock(DVS_SDR104, SDCLKFS_SDR104, false, SD_SDR104_FREQ) == nil
+	sdr50 := sdSwitchSupported(status, ACCESS_MODE_SDR50) &&
+		hw.verifyClock(DVS_SDR50, SDCLKFS_SDR50, false, SD_SDR50_FREQ) == nil
+
+	if !sdr104 && !sdr50 {
+		return
+	}
+
+	// the current limit must be set before switching access mode
+	if err = hw.setCurrentLimitSD(); err != nil {
+		return
+	}
+
+	if sdr104 {
+		if uhs, err = hw.switchAccessModeSD(ACCESS_MODE_SDR104, DVS_SDR104, SDCLKFS_SDR104); err != nil {
+			return
+		}
+
+		if uhs {
+			// CMD19 - SEND_TUNING_BLOCK - sampling clock tuning
+			if hw.executeTuning(19) == nil {
+				hw.card.SDR104 = true
+				return
+			}
+
+			// restore default speed until another mode is selected
+			hw.setClock(0, 0)
+			hw.setClock(DVS_OP, SDCLKFS_OP)
+
+			uhs = false
+		}
+	}
+
+	if sdr50 {
+		if uhs, err = hw.switchAccessModeSD(ACCESS_MODE_SDR50, DVS_SDR50, SDCLKFS_SDR50); err != nil {
+			return
+		}
+
+		hw.card.SDR50 = uhs
+	}
+
+	return
+}
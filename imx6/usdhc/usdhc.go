@@ -22,6 +22,7 @@
 package usdhc
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"sync"
@@ -57,17 +58,26 @@ const (
 	USDHCx_CMD_RSP2 = 0x18
 	USDHCx_CMD_RSP3 = 0x1c
 
+	USDHCx_DATA_BUFF_ACC_PORT = 0x20
+
 	USDHCx_PRES_STATE = 0x24
 	PRES_STATE_WPSPL  = 19
+	PRES_STATE_CINS   = 16
 	PRES_STATE_BREN   = 11
+	PRES_STATE_BWEN   = 10
 	PRES_STATE_SDSTB  = 3
+	PRES_STATE_DLA    = 2
 	PRES_STATE_CDIHB  = 1
 	PRES_STATE_CIHB   = 0
 
-	USDHCx_PROT_CTRL = 0x28
-	PROT_CTRL_DMASEL = 8
-	PROT_CTRL_EMODE  = 4
-	PROT_CTRL_DTW    = 1
+	USDHCx_PROT_CTRL  = 0x28
+	PROT_CTRL_IABG    = 19
+	PROT_CTRL_RWCTL   = 18
+	PROT_CTRL_CREQ    = 17
+	PROT_CTRL_SABGREQ = 16
+	PROT_CTRL_DMASEL  = 8
+	PROT_CTRL_EMODE   = 4
+	PROT_CTRL_DTW     = 1
 
 	USDHCx_SYS_CTRL  = 0x2c
 	SYS_CTRL_INITA   = 27
@@ -77,28 +87,52 @@ const (
 	SYS_CTRL_DTOCV   = 16
 	SYS_CTRL_SDCLKFS = 8
 	SYS_CTRL_DVS     = 4
+	SYS_CTRL_SDCLKEN = 3
+	SYS_CTRL_PEREN   = 2
+	SYS_CTRL_HCKEN   = 1
+	SYS_CTRL_IPGEN   = 0
 
 	USDHCx_INT_STATUS = 0x30
 	INT_STATUS_DMAE   = 28
 	INT_STATUS_TNE    = 26
 	INT_STATUS_AC12E  = 24
+	INT_STATUS_DCE    = 21
+	INT_STATUS_DTOE   = 20
 	INT_STATUS_CIE    = 19
 	INT_STATUS_CEBE   = 18
 	INT_STATUS_CCE    = 17
 	INT_STATUS_CTOE   = 16
+	INT_STATUS_CRM    = 7
+	INT_STATUS_CINS   = 6
 	INT_STATUS_BRR    = 5
+	INT_STATUS_BWR    = 4
+	INT_STATUS_BGE    = 2
 	INT_STATUS_TC     = 1
 	INT_STATUS_CC     = 0
 
 	USDHCx_INT_STATUS_EN  = 0x34
 	INT_STATUS_EN_DTOESEN = 20
+	INT_STATUS_EN_CRMSEN  = 7
+	INT_STATUS_EN_CINSSEN = 6
 
 	USDHCx_INT_SIGNAL_EN        = 0x38
 	USDHCx_AUTOCMD12_ERR_STATUS = 0x3c
 
+	USDHCx_HOST_CTRL_CAP = 0x40
+	HOST_CTRL_CAP_VS18   = 26
+	HOST_CTRL_CAP_VS30   = 25
+	HOST_CTRL_CAP_VS33   = 24
+	HOST_CTRL_CAP_SRS    = 23
+	HOST_CTRL_CAP_DMAS   = 22
+	HOST_CTRL_CAP_HSS    = 21
+	HOST_CTRL_CAP_ADMAS  = 20
+	HOST_CTRL_CAP_MBL    = 16
+
 	USDHCx_WTMK_LVL = 0x44
 	WTMK_LVL_WR_WML = 16
 	WTMK_LVL_RD_WML = 0
+	// maximum watermark level (words)
+	WTMK_LVL_MAX = 128
 
 	USDHCx_MIX_CTRL       = 0x48
 	MIX_CTRL_FBCLK_SEL    = 25
@@ -112,6 +146,25 @@ const (
 	MIX_CTRL_BCEN         = 1
 	MIX_CTRL_DMAEN        = 0
 
+	USDHCx_FORCE_EVENT    = 0x50
+	FORCE_EVENT_CINT      = 31
+	FORCE_EVENT_DMAE      = 28
+	FORCE_EVENT_TNE       = 26
+	FORCE_EVENT_AC12E     = 24
+	FORCE_EVENT_DEBE      = 22
+	FORCE_EVENT_DCE       = 21
+	FORCE_EVENT_DTOE      = 20
+	FORCE_EVENT_CIE       = 19
+	FORCE_EVENT_CEBE      = 18
+	FORCE_EVENT_CCE       = 17
+	FORCE_EVENT_CTOE      = 16
+	FORCE_EVENT_CNIBAC12E = 7
+	FORCE_EVENT_AC12IE    = 4
+	FORCE_EVENT_AC12EBE   = 3
+	FORCE_EVENT_AC12CE    = 2
+	FORCE_EVENT_AC12TOE   = 1
+	FORCE_EVENT_AC12NE    = 0
+
 	USDHCx_ADMA_ERR_STATUS = 0x54
 	USDHCx_ADMA_SYS_ADDR   = 0x58
 )
@@ -130,6 +183,21 @@ const (
 	// Data Timeout Counter Value: SDCLK x 2** 29
 	DTOCV = 0xf
 
+	// controller soft reset timeout
+	RESET_TIMEOUT = 10 * time.Millisecond
+
+	// Default delay after clock changes, covering at least 74 clock
+	// cycles at identification frequency (6.4.1 Power up, SD-PL-7.10).
+	CLOCK_SETTLE_DELAY = 200 * time.Microsecond
+
+	// Default delay before operating conditions negotiation (CMD1/ACMD41),
+	// covering the device power up time (6.4.1 Power up, SD-PL-7.10 and
+	// 6.3.1 Device reset to Pre-idle state, JESD84-B51).
+	POWER_UP_DELAY = 1 * time.Millisecond
+
+	// maximum number of blocks per transfer (BLK_ATT BLKCNT)
+	MAX_TRANSFER_BLOCKS = 0xffff
+
 	// Divide-by-8
 	DVS_ID = 8
 	// Base clock divided by 64
@@ -152,15 +220,32 @@ const (
 
 	// p35, Table 4, JESD84-B51
 	//
-	// Higher speed modes for eMMC cards are HS200 (controller supported,
-	// currently unimplemented by this driver) and HS400 mode (unsupported
-	// at controller level).
+	// Higher speed modes for eMMC cards are HS200 (see hs200.go) and HS400
+	// mode (unsupported at controller level, as the i.MX6 uSDHC lacks the
+	// data strobe input and its delay line).
 	//
 	// p37-38, Figure 3-14 and 3-15, SD-PL-7.10
 	//
-	// Higher speed modes for SD cards are SDR50/SDR104 (controller
-	// supported, currently unimplemented by this driver) and FD156/HD312
-	// (unsupported at controller level).
+	// Higher speed modes for SD cards are SDR50/SDR104 (see uhs.go) and
+	// FD156/HD312 (unsupported at controller level).
+
+	// Divide-by-1
+	DVS_SDR50 = 0
+	// Base clock divided by 2
+	SDCLKFS_SDR50 = 0x01
+	// SDR50 frequency: 200 / (1 * 2) == 100 MHz
+
+	// Divide-by-1
+	DVS_SDR104 = 0
+	// Base clock
+	SDCLKFS_SDR104 = 0x00
+	// SDR104 frequency: 200 / (1 * 1) == 200 MHz
+
+	// Divide-by-1
+	DVS_HS200 = 0
+	// Base clock
+	SDCLKFS_HS200 = 0x00
+	// HS200 frequency: 200 / (1 * 1) == 200 MHz
 )
 
 // CardInfo holds detected card information.
@@ -173,18 +258,230 @@ type CardInfo struct {
 	HC bool
 	// High Speed
 	HS bool
+	// UHS-I SDR50 mode
+	SDR50 bool
+	// UHS-I SDR104 mode
+	SDR104 bool
+	// eMMC HS200 mode
+	HS200 bool
 	// Dual Data Rate
 	DDR bool
+	// Dual Data Rate verified (see USDHC.VerifyDDR)
+	DDRVerified bool
 	// Block Size
 	BlockSize int
 	// Capacity
 	Blocks int
+	// Card Identification
+	CID CID
+	// SD current limit (mA), as selected in UHS-I modes
+	CurrentLimit int
+	// SD Configuration Register
+	SCR SCR
+	// Password locked card (see LockCard())
+	Locked bool
+	// Data bus width, as negotiated with the card (which might be lower
+	// than the one configured with Init() or SetBusWidth())
+	Width int
+
+	// The following fields are only set by Info().
+
+	// Card type (SD, SDHC, SDXC, MMC, eMMC)
+	Type string
+	// Capacity in bytes
+	Size int64
+	// Current card clock frequency (Hz)
+	Rate uint32
+}
+
+// Timings holds the duration of each card initialization phase, as measured
+// during the last card detection.
+type Timings struct {
+	// controller reset and card reset (CMD0)
+	Reset time.Duration
+	// voltage validation (CMD8, ACMD41, CMD1)
+	VoltageValidation time.Duration
+	// card identification (CMD2, CMD3)
+	Identification time.Duration
+	// capacity detection (CSD, EXT_CSD)
+	Capacity time.Duration
+	// card selection, bus width and speed mode switch
+	SpeedSwitch time.Duration
+	// overall card detection
+	Total time.Duration
+}
+
+// Voltage represents the card operating voltage conditions, as accepted by
+// the card during voltage validation.
+type Voltage struct {
+	// OCR voltage window (OCR[23:15], 2.7-3.6V in 100mV steps)
+	Window uint32
+	// Low voltage range (OCR[7], 1.70-1.95V)
+	LowVoltage bool
+	// 1.8V signaling accepted (S18A), SD only
+	Signaling18V bool
 }
 
 // USHDC represents a controller instance.
+//
+// Exported methods are safe for concurrent use, as they serialize on the
+// instance mutex, which a data transfer holds for its whole duration.
+// Methods reporting cached card information (e.g. Info()) therefore wait for
+// any transfer in progress. The exceptions are PauseAtBlockGap(), Continue()
+// and TransferState(), which are meant to be invoked during a transfer.
 type USDHC struct {
 	sync.Mutex
 
+	// PollStatus enables card status polling (CMD13) during data
+	// transfers, to abort them early on card errors.
+	PollStatus bool
+
+	// ManualCMD12 disables Auto CMD12 (MIX_CTRL AC12EN) on open-ended
+	// multiple block transfers (CMD18, CMD25), which are then stopped with
+	// a software CMD12 on completion. Auto CMD12, the default, saves the
+	// stop command round-trip and should only be disabled to work around
+	// cards misbehaving with it.
+	ManualCMD12 bool
+
+	// SetBlockCount enables, on cards supporting it, CMD23 (SET_BLOCK_COUNT)
+	// ahead of multiple block transfers (CMD18, CMD25), so that the card
+	// knows their exact length and no stop command is required.
+	SetBlockCount bool
+
+	// ReliableWrite requests, on eMMC cards and along with SetBlockCount,
+	// reliable write for multiple block writes, so that on power failure
+	// previously written data is preserved (see WriteReliability()).
+	ReliableWrite bool
+
+	// TransferBlockSize, when set, overrides the block size programmed in
+	// the controller Block Attributes register for transfers whose size is
+	// a multiple of it (e.g. 4096 to transfer eight 512 bytes blocks per
+	// controller block). Card addressing remains in card block size units.
+	//
+	// It must be a multiple of the card block size, not exceeding the
+	// controller maximum block length. ADMA2 descriptors are split at
+	// ADMA_BD_MAX_LENGTH regardless of this setting, therefore a block can
+	// span across descriptors.
+	TransferBlockSize int
+
+	// PIO forces data transfers to be performed with programmed I/O,
+	// through the controller data port, rather than with ADMA2 (the
+	// default). It is meant as a fallback on systems where DMA is
+	// unreliable, as transfers are slower and keep the CPU busy.
+	PIO bool
+
+	// DMABoundary, when set, prevents ADMA2 descriptors from crossing
+	// multiples of the given address boundary (e.g. 512 * 1024), the
+	// transfer of buffers spanning across it is split in multiple
+	// descriptors. It must be a power of 2. The uSDHC ADMA2 engine has no
+	// such limitation, therefore it is unset by default, it only needs to
+	// be set to accommodate bus or memory controller constraints.
+	DMABoundary int
+
+	// CardDetect indicates that the card detect signal (CD_B) is wired to
+	// the controller, so that Present() can report card presence from the
+	// controller Present State register instead of probing the card.
+	CardDetect bool
+
+	// DisableHCS prevents requesting High Capacity support (ACMD41 HCS
+	// bit) to SD cards, restricting operation to Standard Capacity (SDSC)
+	// cards with byte addressing. High Capacity (SDHC/SDXC) cards, which
+	// use block addressing, never complete initialization without it and
+	// are therefore not detected.
+	DisableHCS bool
+
+	// DisableSDv1 prevents treating cards not responding to CMD8
+	// (SEND_IF_COND) as Ver1.X SD cards, which are then not detected as SD
+	// cards, skipping their operating conditions negotiation (ACMD41).
+	// It speeds up detection of non-SD cards (e.g. eMMC) on systems where
+	// legacy SD cards are not expected.
+	DisableSDv1 bool
+
+	// ClockSettleDelay is the delay applied after each card clock change,
+	// before any further command is issued, it defaults to
+	// CLOCK_SETTLE_DELAY when unset on Init() (a negative value disables
+	// it).
+	ClockSettleDelay time.Duration
+
+	// PowerUpDelay is the delay applied, for both SD and MMC cards, before
+	// operating conditions negotiation (ACMD41/CMD1), it defaults to
+	// POWER_UP_DELAY when unset on Init() (a negative value disables it).
+	PowerUpDelay time.Duration
+
+	// CommandRetries is the number of retries, on transient command errors
+	// (see ErrTimeout, ErrCommandCRC), for commands issued during card
+	// identification, it defaults to COMMAND_RETRIES when unset on Init()
+	// (a negative value disables retries).
+	CommandRetries int
+
+	// DetectTimeout is the maximum duration of operating conditions
+	// negotiation (ACMD41/CMD1) during card detection, when zero
+	// SD_DETECT_TIMEOUT and MMC_DETECT_TIMEOUT are applied.
+	DetectTimeout time.Duration
+
+	// SanitizeTimeout is the maximum duration of eMMC sanitize operations
+	// (see Sanitize()), when zero MMC_SANITIZE_TIMEOUT is applied.
+	SanitizeTimeout time.Duration
+
+	// InitRetries is the number of additional voltage validation attempts
+	// performed by Detect() when no card responds to operating conditions
+	// negotiation (ACMD41/CMD1), each preceded by a card power cycle (0
+	// disables retries).
+	InitRetries int
+
+	// PowerCycle, when set, is invoked before each voltage validation
+	// retry (see InitRetries) to remove and restore card power (e.g.
+	// through a board regulator or GPIO), as the uSDHC lacks card power
+	// control. The card clock is gated during the invocation, which must
+	// only return once power is stable again. Without it retries are
+	// preceded by a card reset (CMD0) only.
+	PowerCycle func() error
+
+	// UHS enables, on SD cards, 1.8V signaling (when supported by both
+	// controller and card) and UHS-I bus speed modes. The controller
+	// VSELECT signal must control the card I/O voltage on the board.
+	//
+	// Cards switched to 1.8V signaling require a power cycle (see
+	// PowerCycle) to be detected again.
+	UHS bool
+
+	// HS200 enables, on eMMC cards, HS200 bus speed mode (when supported
+	// by the card on a 4-bit or 8-bit data bus). The card I/O voltage
+	// (VCCQ) must be 1.8V on the board.
+	HS200 bool
+
+	// MaxCurrent is the maximum card supply current (mA) the board can
+	// provide, used to select the SD card current limit in UHS-I modes. It
+	// defaults to DEFAULT_CURRENT_LIMIT when unset.
+	MaxCurrent int
+
+	// VerifyDDR enables verification of eMMC Dual Data Rate mode, after
+	// its activation, by comparing data read in both Single and Dual Data
+	// Rate modes. On mismatch Single Data Rate mode is restored.
+	VerifyDDR bool
+
+	// IdentificationFrequency is the card clock frequency (Hz) used during
+	// card identification, the controller dividers are computed from the
+	// uSDHC root clock to not exceed it. It defaults to, and is capped at,
+	// ID_FREQ.
+	IdentificationFrequency uint32
+
+	// LongWait is the card status polling policy for long operations
+	// (e.g. erase), it defaults to BACKOFF_* values when unset on Init().
+	LongWait Backoff
+
+	// ProbeRCAs are the Relative Card Addresses probed by ProbeExisting()
+	// to look up a card initialized by a prior boot stage, when unset the
+	// ones commonly assigned by hosts to eMMC cards (1, 2, 3) are probed.
+	// SD cards publish their own RCA (CMD3 response), which must therefore
+	// be set here for them to be found.
+	ProbeRCAs []uint32
+
+	// MeasureLatency enables latency measurement of read, write and erase
+	// operations (see Latencies()), timed with the runtime clock (backed
+	// by the ARM timer).
+	MeasureLatency bool
+
 	// controller index
 	n int
 	// bus width
@@ -193,26 +490,82 @@ type USDHC struct {
 	cg int
 	// Relative Card Address
 	rca uint32
+	// controller capabilities
+	cap uint32
+	// controller reset status
+	ready bool
 
 	// control registers
-	blk_att         uint32
-	wtmk_lvl        uint32
-	cmd_arg         uint32
-	cmd_xfr         uint32
-	cmd_rsp         uint32
-	prot_ctrl       uint32
-	sys_ctrl        uint32
-	mix_ctrl        uint32
-	pres_state      uint32
-	int_status      uint32
-	int_status_en   uint32
-	int_signal_en   uint32
-	adma_sys_addr   uint32
-	adma_err_status uint32
-	ac12_err_status uint32
+	blk_att          uint32
+	wtmk_lvl         uint32
+	cmd_arg          uint32
+	cmd_xfr          uint32
+	cmd_rsp          uint32
+	prot_ctrl        uint32
+	sys_ctrl         uint32
+	mix_ctrl         uint32
+	pres_state       uint32
+	int_status       uint32
+	int_status_en    uint32
+	int_signal_en    uint32
+	adma_sys_addr    uint32
+	adma_err_status  uint32
+	ac12_err_status  uint32
+	host_ctrl_cap    uint32
+	data_buff        uint32
+	force_event      uint32
+	dll_ctrl         uint32
+	dll_status       uint32
+	tune_ctrl_status uint32
+	vend_spec        uint32
+
+	// programmed I/O transfer buffer
+	pioBuf []byte
+	// disable automatic CMD12
+	noAC12 bool
+	// issue CMD55 (APP_CMD) before the data transfer command
+	acmd bool
+	// data CRC conflict check override
+	crcCheckOverride bool
+	crcCheck         bool
 
 	// detected card properties
 	card CardInfo
+	// last read card registers
+	ocr    uint32
+	cid    [4]uint32
+	csd    [4]uint32
+	extCSD []byte
+	// decoded CSD register
+	csdInfo CSD
+	// last read SD Status register
+	sdStatusInfo SDStatus
+
+	// eMMC sleep state
+	sleeping bool
+
+	// 1.8V signaling active
+	signaling18V bool
+
+	// selected physical partition
+	partition          string
+	partitionUncertain bool
+
+	// operation latencies
+	latencies Latencies
+
+	// controller configuration left by a prior boot stage
+	prior priorConfig
+
+	// card change notification
+	hotplug    sync.Mutex
+	cardChange func(present bool)
+	monitoring bool
+
+	// card initialization timings
+	timings Timings
+	// current initialization phase start
+	phaseStart time.Time
 
 	readTimeout  time.Duration
 	writeTimeout time.Duration
@@ -236,6 +589,163 @@ func (hw *USDHC) setClock(dvs int, sdclkfs int) {
 
 	reg.Write(hw.sys_ctrl, sys)
 	reg.Wait(hw.pres_state, PRES_STATE_SDSTB, 1, 1)
+
+	if (dvs != 0 || sdclkfs != 0) && hw.ClockSettleDelay > 0 {
+		// allow the card to settle on the new clock
+		time.Sleep(hw.ClockSettleDelay)
+	}
+}
+
+// powerUpDelay waits for the card power up before operating conditions
+// negotiation.
+func (hw *USDHC) powerUpDelay() {
+	if hw.PowerUpDelay > 0 {
+		time.Sleep(hw.PowerUpDelay)
+	}
+}
+
+// detectTimeout returns the card detection timeout, defaulting to the
+// argument one.
+func (hw *USDHC) detectTimeout(def time.Duration) time.Duration {
+	if hw.DetectTimeout > 0 {
+		return hw.DetectTimeout
+	}
+
+	return def
+}
+
+// cyclePower invokes the PowerCycle function, when set, with the card clock
+// gated.
+func (hw *USDHC) cyclePower() (err error) {
+	if hw.PowerCycle == nil {
+		return
+	}
+
+	reg.Clear(hw.sys_ctrl, SYS_CTRL_SDCLKEN)
+	err = hw.PowerCycle()
+	reg.Set(hw.sys_ctrl, SYS_CTRL_SDCLKEN)
+
+	if err != nil {
+		return fmt.Errorf("power cycle failed, %v", err)
+	}
+
+	return
+}
+
+// powerCycle power cycles the card, when a PowerCycle function is set, and
+// resets it to idle state ahead of a voltage validation retry.
+func (hw *USDHC) powerCycle() (err error) {
+	if err = hw.cyclePower(); err != nil {
+		return
+	}
+
+	return hw.initCard()
+}
+
+// initCard sends initialization clocks and resets the card to idle state.
+func (hw *USDHC) initCard() (err error) {
+	// initialize
+	reg.Set(hw.sys_ctrl, SYS_CTRL_INITA)
+	reg.Wait(hw.sys_ctrl, SYS_CTRL_INITA, 1, 0)
+
+	// CMD0 - GO_IDLE_STATE - reset card
+	return hw.cmd(0, GO_IDLE_STATE, CommandOpts{Response: RSP_NONE})
+}
+
+// setBusWidth configures the controller data transfer width.
+func (hw *USDHC) setBusWidth(width int) (err error) {
+	var dtw uint32
+
+	switch width {
+	case 1:
+		dtw = 0b00
+	case 4:
+		dtw = 0b01
+	case 8:
+		dtw = 0b10
+	default:
+		return errors.New("unsupported controller data transfer width")
+	}
+
+	reg.SetN(hw.prot_ctrl, PROT_CTRL_DTW, 0b11, dtw)
+
+	return
+}
+
+// reset performs a controller soft reset and programs its registers to the
+// default settings required before any card interaction.
+func (hw *USDHC) reset() (err error) {
+	// enable clock
+	reg.SetN(imx6.CCM_CCGR6, hw.cg, 0b11, 0b11)
+
+	// soft reset uSDHC
+	reg.Set(hw.sys_ctrl, SYS_CTRL_RSTA)
+
+	if !reg.WaitFor(RESET_TIMEOUT, hw.sys_ctrl, SYS_CTRL_RSTA, 1, 0) {
+		return fmt.Errorf("uSDHC%d reset timeout", hw.n)
+	}
+
+	// Enable IPG, AHB (HCK), peripheral and card clocks, the latter also
+	// clocks card detection debounce, rather than relying on their reset
+	// values.
+	sys := reg.Read(hw.sys_ctrl)
+	bits.Set(&sys, SYS_CTRL_IPGEN)
+	bits.Set(&sys, SYS_CTRL_HCKEN)
+	bits.Set(&sys, SYS_CTRL_PEREN)
+	bits.Set(&sys, SYS_CTRL_SDCLKEN)
+	reg.Write(hw.sys_ctrl, sys)
+
+	hw.cap = reg.Read(hw.host_ctrl_cap)
+
+	// ADMA2 support is required for data transfers, unless programmed
+	// I/O is forced
+	if !hw.PIO && bits.Get(&hw.cap, HOST_CTRL_CAP_ADMAS, 1) != 1 {
+		return fmt.Errorf("uSDHC%d lacks ADMA2 support", hw.n)
+	}
+
+	// A soft reset fails to clear MIX_CTRL register, clear it all except
+	// tuning bits.
+	mix := reg.Read(hw.mix_ctrl)
+	bits.Clear(&mix, MIX_CTRL_FBCLK_SEL)
+	bits.Clear(&mix, MIX_CTRL_AUTO_TUNE_EN)
+	bits.Clear(&mix, MIX_CTRL_SMP_CLK_SEL)
+	bits.Clear(&mix, MIX_CTRL_EXE_TUNE)
+	reg.Write(hw.mix_ctrl, mix)
+
+	// set data transfer width
+	if err = hw.setBusWidth(hw.width); err != nil {
+		return
+	}
+
+	// set little endian mode
+	reg.SetN(hw.prot_ctrl, PROT_CTRL_EMODE, 0b11, 0b10)
+
+	// set data CRC conflict check for legacy modes
+	hw.setCRCConflictCheck(false)
+
+	// set 3.3V signaling
+	hw.setSignaling18V(false)
+	hw.signaling18V = false
+
+	// clear clock
+	hw.setClock(0, 0)
+	// set identification frequency
+	hw.setClock(hw.idClock())
+
+	if reg.Get(hw.pres_state, PRES_STATE_SDSTB, 1) != 1 {
+		return fmt.Errorf("uSDHC%d clock not stable", hw.n)
+	}
+
+	// set data timeout counter to SDCLK x 2^28
+	reg.Clear(hw.int_status_en, INT_STATUS_EN_DTOESEN)
+	reg.SetN(hw.sys_ctrl, SYS_CTRL_DTOCV, 0xf, DTOCV)
+	reg.Set(hw.int_status_en, INT_STATUS_EN_DTOESEN)
+
+	// latch card insertion and removal events (see OnCardChange())
+	reg.Set(hw.int_status_en, INT_STATUS_EN_CINSSEN)
+	reg.Set(hw.int_status_en, INT_STATUS_EN_CRMSEN)
+
+	return
 }
 
 // Detect performs voltage validation to detect an SD or MMC card.
@@ -251,9 +761,97 @@ func (hw *USDHC) detect() (sd bool, mmc bool, hc bool, err error) {
 	return
 }
 
-// Info returns detected card information.
-func (hw *USDHC) Info() CardInfo {
-	return hw.card
+// Info returns detected card information, along with the current bus
+// configuration. No command is issued to the card.
+//
+// The reported capacity (Blocks, Size) is the one of the currently selected
+// physical partition (see SelectPartition()).
+func (hw *USDHC) Info() (info CardInfo) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	info = hw.card
+
+	if !info.SD && !info.MMC {
+		return
+	}
+
+	// capacity reflects the selected physical partition
+	info.Size = hw.partitionSize()
+	info.Blocks = int(info.Size / int64(info.BlockSize))
+
+	switch {
+	case info.SD && !info.HC:
+		info.Type = "SD"
+	case info.SD && info.Size <= SDHC_MAX_SIZE:
+		info.Type = "SDHC"
+	case info.SD:
+		info.Type = "SDXC"
+	case ResponseField(hw.cid, MMC_CID_CBX, 0b11) != CBX_CARD:
+		info.Type = "eMMC"
+	default:
+		info.Type = "MMC"
+	}
+
+	info.Rate = hw.clock()
+
+	return
+}
+
+// OCR returns the raw Operation Conditions Register last accepted by the
+// card in response to ACMD41 (SD) or CMD1 (MMC) during voltage validation.
+func (hw *USDHC) OCR() uint32 {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.ocr
+}
+
+// NegotiatedVoltage returns the operating voltage conditions accepted by the
+// card during voltage validation.
+func (hw *USDHC) NegotiatedVoltage() (v Voltage) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	ocr := hw.ocr
+
+	switch {
+	case hw.card.SD:
+		v.Window = bits.Get(&ocr, SD_OCR_VDD_HV_MIN, 0x1ff)
+		v.LowVoltage = bits.Get(&ocr, SD_OCR_VDD_LV, 1) == 1
+		v.Signaling18V = bits.Get(&ocr, SD_OCR_S18R, 1) == 1
+	case hw.card.MMC:
+		v.Window = bits.Get(&ocr, MMC_OCR_VDD_HV_MIN, 0x1ff)
+		v.LowVoltage = bits.Get(&ocr, MMC_OCR_VDD_LV, 1) == 1
+	}
+
+	return
+}
+
+// DDRActive returns whether the controller is operating the card in Dual
+// Data Rate mode.
+func (hw *USDHC) DDRActive() bool {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.cg != 0 && hw.card.DDR && reg.Get(hw.mix_ctrl, MIX_CTRL_DDR_EN, 1) == 1
+}
+
+// Timings returns the card initialization phases duration, as measured during
+// the last card detection.
+func (hw *USDHC) Timings() Timings {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.timings
+}
+
+// phase records the duration of the current initialization phase and starts
+// the next one.
+func (hw *USDHC) phase(d *time.Duration) {
+	now := time.Now()
+	*d = now.Sub(hw.phaseStart)
+	hw.phaseStart = now
 }
 
 // Init initializes the uSDHC controller instance.
@@ -284,6 +882,13 @@ func (hw *USDHC) Init(width int) {
 	hw.mix_ctrl = base + USDHCx_MIX_CTRL
 	hw.pres_state = base + USDHCx_PRES_STATE
 	hw.int_status = base + USDHCx_INT_STATUS
+	hw.host_ctrl_cap = base + USDHCx_HOST_CTRL_CAP
+	hw.data_buff = base + USDHCx_DATA_BUFF_ACC_PORT
+	hw.force_event = base + USDHCx_FORCE_EVENT
+	hw.dll_ctrl = base + USDHCx_DLL_CTRL
+	hw.dll_status = base + USDHCx_DLL_STATUS
+	hw.tune_ctrl_status = base + USDHCx_TUNE_CTRL_STATUS
+	hw.vend_spec = base + USDHCx_VEND_SPEC
 	hw.int_status_en = base + USDHCx_INT_STATUS_EN
 	hw.int_signal_en = base + USDHCx_INT_SIGNAL_EN
 	hw.adma_sys_addr = base + USDHCx_ADMA_SYS_ADDR
@@ -298,9 +903,53 @@ func (hw *USDHC) Init(width int) {
 	// p106, 4.6.2.2 Write, SD-PL-7.10
 	hw.writeTimeout = 500 * time.Millisecond
 
+	if hw.ClockSettleDelay == 0 {
+		hw.ClockSettleDelay = CLOCK_SETTLE_DELAY
+	}
+
+	if hw.PowerUpDelay == 0 {
+		hw.PowerUpDelay = POWER_UP_DELAY
+	}
+
+	if hw.CommandRetries == 0 {
+		hw.CommandRetries = COMMAND_RETRIES
+	}
+
+	if hw.LongWait == (Backoff{}) {
+		hw.LongWait = Backoff{
+			InitialDelay: BACKOFF_INITIAL_DELAY,
+			MaxDelay:     BACKOFF_MAX_DELAY,
+			Multiplier:   BACKOFF_MULTIPLIER,
+		}
+	}
+
+	hw.latencies = Latencies{}
+
+	// retain any configuration left by a prior boot stage
+	if reg.Get(imx6.CCM_CCGR6, hw.cg, 0b11) != 0 {
+		hw.prior = priorConfig{
+			valid: true,
+			prot:  reg.Read(hw.prot_ctrl),
+			mix:   reg.Read(hw.mix_ctrl),
+			sys:   reg.Read(hw.sys_ctrl),
+		}
+	}
+
+	// reset controller to a known state before any card interaction
+	hw.ready = hw.reset() == nil
+
 	hw.Unlock()
 }
 
+// Ready returns whether the controller reset, performed at initialization,
+// completed successfully.
+func (hw *USDHC) Ready() bool {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.ready
+}
+
 // Detect initializes an SD/MMC card as specified in
 // p347, 35.4.1 Initializing the SD/MMC card, IMX6FG.
 func (hw *USDHC) Detect() (err error) {
@@ -311,69 +960,96 @@ func (hw *USDHC) Detect() (err error) {
 		return errors.New("controller is not initialized")
 	}
 
-	// clear card information
-	hw.card = CardInfo{}
-
-	// enable clock
-	reg.SetN(imx6.CCM_CCGR6, hw.cg, 0b11, 0b11)
+	return hw.detectCard()
+}
 
-	// soft reset uSDHC
-	reg.Set(hw.sys_ctrl, SYS_CTRL_RSTA)
-	reg.Wait(hw.sys_ctrl, SYS_CTRL_RSTA, 1, 0)
+// Reset recovers an unresponsive card by power cycling it, when a
+// PowerCycle function is set, and repeating card detection (see Detect()),
+// which resets the controller and the card (CMD0) and refreshes all card
+// information (e.g. RCA and capacity).
+//
+// Without a PowerCycle function, cards switched to 1.8V signaling cannot be
+// recovered.
+func (hw *USDHC) Reset() (err error) {
+	hw.Lock()
+	defer hw.Unlock()
 
-	// A soft reset fails to clear MIX_CTRL register, clear it all except
-	// tuning bits.
-	mix := reg.Read(hw.mix_ctrl)
-	bits.Clear(&mix, MIX_CTRL_FBCLK_SEL)
-	bits.Clear(&mix, MIX_CTRL_AUTO_TUNE_EN)
-	bits.Clear(&mix, MIX_CTRL_SMP_CLK_SEL)
-	bits.Clear(&mix, MIX_CTRL_EXE_TUNE)
-	reg.Write(hw.mix_ctrl, mix)
+	if hw.cg == 0 {
+		return errors.New("controller is not initialized")
+	}
 
-	// data transfer width, default to 1-bit mode
-	dtw := 0b00
+	if hw.PowerCycle != nil {
+		// cards power up with 3.3V signaling
+		hw.setSignaling18V(false)
+		hw.signaling18V = false
 
-	switch hw.width {
-	case 1:
-		dtw = 0b00
-	case 4:
-		dtw = 0b01
-	case 8:
-		dtw = 0b10
-	default:
-		return errors.New("unsupported controller data transfer width")
+		if err = hw.cyclePower(); err != nil {
+			return
+		}
 	}
 
-	// set data transfer width
-	reg.SetN(hw.prot_ctrl, PROT_CTRL_DTW, 0b11, uint32(dtw))
-	// set little endian mode
-	reg.SetN(hw.prot_ctrl, PROT_CTRL_EMODE, 0b11, 0b10)
+	return hw.detectCard()
+}
 
-	// clear clock
-	hw.setClock(0, 0)
-	// set identification frequency
-	hw.setClock(DVS_ID, SDCLKFS_ID)
+// detectCard performs card detection and initialization.
+func (hw *USDHC) detectCard() (err error) {
+	// clear card information
+	hw.card = CardInfo{}
+	hw.rca = 0
+	hw.ocr = 0
+	hw.cid = [4]uint32{}
+	hw.csd = [4]uint32{}
+	hw.csdInfo = CSD{}
+	hw.extCSD = nil
+	hw.sdStatusInfo = SDStatus{}
 
-	// set data timeout counter to SDCLK x 2^28
-	reg.Clear(hw.int_status_en, INT_STATUS_EN_DTOESEN)
-	reg.SetN(hw.sys_ctrl, SYS_CTRL_DTOCV, 0xf, DTOCV)
-	reg.Set(hw.int_status_en, INT_STATUS_EN_DTOESEN)
+	hw.sleeping = false
 
-	// initialize
-	reg.Set(hw.sys_ctrl, SYS_CTRL_INITA)
-	reg.Wait(hw.sys_ctrl, SYS_CTRL_INITA, 1, 0)
+	// the user area is selected on card reset
+	hw.partition = "user"
+	hw.partitionUncertain = false
 
-	// CMD0 - GO_IDLE_STATE - reset card
-	if err = hw.cmd(0, READ, GO_IDLE_STATE, RSP_NONE, false, false, false, 0); err != nil {
+	// clear initialization timings
+	hw.timings = Timings{}
+	hw.phaseStart = time.Now()
+
+	defer func(start time.Time) {
+		hw.timings.Total = time.Since(start)
+	}(hw.phaseStart)
+
+	// reset controller
+	hw.ready = false
+
+	if err = hw.reset(); err != nil {
 		return
 	}
 
-	hw.card.SD, hw.card.MMC, hw.card.HC, err = hw.detect()
+	hw.ready = true
 
-	if err != nil {
+	if err = hw.initCard(); err != nil {
 		return
 	}
 
+	hw.phase(&hw.timings.Reset)
+
+	for retry := 0; ; retry++ {
+		hw.card.SD, hw.card.MMC, hw.card.HC, err = hw.detect()
+
+		if err != nil {
+			return
+		}
+
+		if hw.card.SD || hw.card.MMC || retry >= hw.InitRetries {
+			break
+		}
+
+		if err = hw.powerCycle(); err != nil {
+			return
+		}
+	}
+
+	hw.phase(&hw.timings.VoltageValidation)
+
 	if hw.card.SD {
 		err = hw.initSD()
 	} else if hw.card.MMC {
@@ -389,28 +1065,221 @@ func (hw *USDHC) Detect() (err error) {
 	if !hw.card.DDR {
 		// CMD16 - SET_BLOCKLEN - define the block length,
 		// only legal In single data rate mode.
-		err = hw.cmd(16, READ, uint32(hw.card.BlockSize), RSP_48, true, true, false, 0)
+		err = hw.cmd(16, uint32(hw.card.BlockSize), CommandOpts{Response: RSP_48, CheckIndex: true, CheckCRC: true})
+	}
+
+	hw.phase(&hw.timings.SpeedSwitch)
+
+	return
+}
+
+// SetBusWidth changes the data bus width of both the controller and the
+// detected card, it can be used at runtime (e.g. fallback to 1-bit mode for
+// error recovery) and the new width is retained for future card detection.
+//
+// Reducing an eMMC card in DDR mode to 1-bit width disables DDR, which is not
+// restored by later widening (a new Detect() is required for that).
+func (hw *USDHC) SetBusWidth(width int) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	if hw.cg == 0 {
+		return errors.New("controller is not initialized")
+	}
+
+	switch {
+	case hw.card.SD:
+		err = hw.setBusWidthSD(width)
+	case hw.card.MMC:
+		ddr := hw.card.DDR && width != 1
+
+		if err = hw.setBusWidthMMC(width, ddr); err != nil {
+			break
+		}
+
+		if hw.card.DDR && !ddr {
+			// clear clock
+			hw.setClock(0, 0)
+			// set high speed frequency
+			hw.setClock(DVS_HS, SDCLKFS_HS_SDR)
+
+			hw.card.DDR = false
+		}
+	default:
+		err = errors.New("no card detected")
 	}
 
+	if err != nil {
+		return
+	}
+
+	if err = hw.setBusWidth(width); err != nil {
+		return
+	}
+
+	hw.width = width
+	hw.card.Width = width
+
 	return
 }
 
+// transferBlocks returns the block count and size to be programmed in the
+// controller for a transfer, applying TransferBlockSize when set and when the
+// transfer size is a multiple of it.
+func (hw *USDHC) transferBlocks(blocks uint32, blockSize uint32) (uint32, uint32, error) {
+	size := uint32(hw.TransferBlockSize)
+
+	if size == 0 || size == blockSize {
+		return blocks, blockSize, nil
+	}
+
+	// Host Controller Capabilities MBL field
+	max := uint32(512) << bits.Get(&hw.cap, HOST_CTRL_CAP_MBL, 0b11)
+
+	if size%blockSize != 0 || size > max {
+		return 0, 0, fmt.Errorf("invalid transfer block size %d (block size:%d max:%d)", size, blockSize, max)
+	}
+
+	if (blocks*blockSize)%size != 0 {
+		return blocks, blockSize, nil
+	}
+
+	return (blocks * blockSize) / size, size, nil
+}
+
+// watermarkLevel returns the data buffer watermark level (in words) for the
+// argument controller block size, as the largest divisor of the block size
+// not exceeding the controller maximum, so that blocks are moved in whole
+// watermark chunks.
+func watermarkLevel(blockSize uint32) uint32 {
+	words := blockSize / 4
+	wml := words
+
+	if wml > WTMK_LVL_MAX {
+		wml = WTMK_LVL_MAX
+	}
+
+	for ; wml > 1; wml-- {
+		if words%wml == 0 {
+			return wml
+		}
+	}
+
+	return 1
+}
+
+// splitTransfer invokes the argument function for each sequential transfer,
+// of at most MAX_TRANSFER_BLOCKS blocks, required to move the argument number
+// of blocks from the argument byte offset, along with the matching portion of
+// the argument buffer.
+func splitTransfer(offset uint64, blocks uint32, blockSize uint32, buf []byte, fn func(offset uint64, blocks uint32, buf []byte) error) (err error) {
+	for blocks > MAX_TRANSFER_BLOCKS {
+		size := MAX_TRANSFER_BLOCKS * blockSize
+
+		if err = fn(offset, MAX_TRANSFER_BLOCKS, buf[0:size]); err != nil {
+			return
+		}
+
+		offset += uint64(size)
+		blocks -= MAX_TRANSFER_BLOCKS
+		buf = buf[size:]
+	}
+
+	return fn(offset, blocks, buf)
+}
+
+// transfer moves data from/to the card at the given byte offset, converted
+// to a block address on high capacity cards.
+//
+// Multiple block transfers exceeding the controller block count limit are
+// performed with sequential transfers of at most MAX_TRANSFER_BLOCKS each.
+func (hw *USDHC) transfer(index uint32, dtd uint32, offset uint64, blocks uint32, blockSize uint32, buf []byte) (err error) {
+	if index != 18 && index != 25 {
+		return hw.transferBuffers(index, dtd, offset, blocks, blockSize, [][]byte{buf})
+	}
+
+	start := offset
+
+	return splitTransfer(offset, blocks, blockSize, buf, func(offset uint64, blocks uint32, buf []byte) (err error) {
+		// wait for programming completion of any previous transfer
+		if dtd == WRITE && offset != start {
+			if err = hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout); err != nil {
+				return
+			}
+		}
+
+		return hw.transferBuffers(index, dtd, offset, blocks, blockSize, [][]byte{buf})
+	})
+}
+
+// transferBuffers is the scatter-gather variant of transfer (see
+// transferSG).
+//
+// Unlike transfer, it performs a single transfer, which therefore cannot
+// exceed MAX_TRANSFER_BLOCKS.
+func (hw *USDHC) transferBuffers(index uint32, dtd uint32, offset uint64, blocks uint32, blockSize uint32, bufs [][]byte) (err error) {
+	if hw.partitionUncertain {
+		return ErrPartitionUncertain
+	}
+
+	if hw.card.Locked {
+		return ErrCardLocked
+	}
+
+	if hw.MeasureLatency {
+		l := &hw.latencies.Read
+
+		if dtd == WRITE {
+			l = &hw.latencies.Write
+		}
+
+		defer func(start time.Time) { hw.measure(l, start, err) }(time.Now())
+	}
+
+	if hw.card.HC && blockSize > 0 {
+		// p102, 4.3.14 Command Functional Difference in Card Capacity Types, SD-PL-7.10
+		offset = offset / uint64(blockSize)
+	}
+
+	return hw.transferSG(index, dtd, uint32(offset), blocks, blockSize, bufs)
+}
+
+// transferArg moves data from/to the card, with the argument command
+// argument, using a single buffer (see transferSG).
+func (hw *USDHC) transferArg(index uint32, dtd uint32, arg uint32, blocks uint32, blockSize uint32, buf []byte) (err error) {
+	return hw.transferSG(index, dtd, arg, blocks, blockSize, [][]byte{buf})
+}
+
 // Transfer data from/to the card as specified in:
 //   p347, 35.5.1 Reading data from the card, IMX6FG,
 //   p354, 35.5.2 Writing data to the card, IMX6FG.
-func (hw *USDHC) transfer(index uint32, dtd uint32, offset uint64, blocks uint32, blockSize uint32, buf []byte) (err error) {
+//
+// The data is scattered (or gathered) across the argument buffers, each
+// transferred through its own ADMA2 descriptors.
+func (hw *USDHC) transferSG(index uint32, dtd uint32, arg uint32, blocks uint32, blockSize uint32, bufs [][]byte) (err error) {
 	var timeout time.Duration
+	var size int
 
 	if hw.cg == 0 {
 		return errors.New("controller is not initialized")
 	}
 
+	if hw.sleeping {
+		return errors.New("card is sleeping")
+	}
+
 	if blocks == 0 || blockSize == 0 {
 		return
 	}
 
-	if blocks > 0xffff {
-		return errors.New("transfer size cannot exceed 65535 blocks")
+	xfrBlocks, xfrBlockSize, err := hw.transferBlocks(blocks, blockSize)
+
+	if err != nil {
+		return
+	}
+
+	if xfrBlocks > MAX_TRANSFER_BLOCKS {
+		return fmt.Errorf("transfer size cannot exceed %d blocks", MAX_TRANSFER_BLOCKS)
 	}
 
 	err = hw.waitState(CURRENT_STATE_TRAN, 1*time.Millisecond)
@@ -419,75 +1288,228 @@ func (hw *USDHC) transfer(index uint32, dtd uint32, offset uint64, blocks uint32
 		return
 	}
 
+	if hw.acmd {
+		if err = hw.appCmd(); err != nil {
+			return
+		}
+	}
+
 	// set block size
-	reg.SetN(hw.blk_att, BLK_ATT_BLKSIZE, 0x1fff, blockSize)
+	reg.SetN(hw.blk_att, BLK_ATT_BLKSIZE, 0x1fff, xfrBlockSize)
 	// set block count
-	reg.SetN(hw.blk_att, BLK_ATT_BLKCNT, 0xffff, blocks)
+	reg.SetN(hw.blk_att, BLK_ATT_BLKCNT, 0xffff, xfrBlocks)
+
+	for _, buf := range bufs {
+		size += len(buf)
+	}
 
-	bufAddress := dma.Alloc(buf, 32)
-	defer dma.Free(bufAddress)
+	if size < int(blocks*blockSize) {
+		return fmt.Errorf("buffer size %d below transfer size %d", size, blocks*blockSize)
+	}
 
-	// ADMA2 descriptor
-	bd := &ADMABufferDescriptor{}
-	bd.Init(bufAddress, len(buf))
+	if b := hw.DMABoundary; b < 0 || b&(b-1) != 0 {
+		return fmt.Errorf("invalid DMA boundary %d", b)
+	}
 
-	bdAddress := dma.Alloc(bd.Bytes(), 0)
-	defer dma.Free(bdAddress)
+	var bufAddresses []uint32
 
-	reg.Write(hw.adma_sys_addr, bdAddress)
+	// Without a DMA region, such as during early bring-up, transfers are
+	// performed with programmed I/O.
+	pio := hw.PIO || !dma.Initialized()
 
-	if hw.card.HC {
-		// p102, 4.3.14 Command Functional Difference in Card Capacity Types, SD-PL-7.10
-		offset = offset / uint64(blockSize)
+	if pio {
+		if len(bufs) == 1 {
+			hw.pioBuf = bufs[0][0 : blocks*blockSize]
+		} else {
+			hw.pioBuf = bytes.Join(bufs, nil)[0 : blocks*blockSize]
+		}
+
+		defer func() { hw.pioBuf = nil }()
+	} else {
+		var bd *ADMABufferDescriptor
+
+		for _, buf := range bufs {
+			addr := dma.Alloc(buf, 32)
+			defer dma.Free(addr)
+
+			bufAddresses = append(bufAddresses, addr)
+
+			// ADMA2 descriptor
+			if bd == nil {
+				bd = &ADMABufferDescriptor{}
+				bd.Init(addr, len(buf))
+			} else {
+				bd.Append(addr, len(buf))
+			}
+		}
+
+		bd.Split(hw.DMABoundary)
+
+		bdAddress := dma.Alloc(bd.Bytes(), 0)
+		defer dma.Free(bdAddress)
+
+		reg.Write(hw.adma_sys_addr, bdAddress)
 	}
 
 	if dtd == WRITE {
 		timeout = hw.writeTimeout * time.Duration(blocks)
 		// set write watermark level
-		reg.SetN(hw.wtmk_lvl, WTMK_LVL_WR_WML, 0xff, blockSize/4)
+		reg.SetN(hw.wtmk_lvl, WTMK_LVL_WR_WML, 0xff, watermarkLevel(xfrBlockSize))
 	} else {
 		timeout = hw.readTimeout * time.Duration(blocks)
 		// set read watermark level
-		reg.SetN(hw.wtmk_lvl, WTMK_LVL_RD_WML, 0xff, blockSize/4)
+		reg.SetN(hw.wtmk_lvl, WTMK_LVL_RD_WML, 0xff, watermarkLevel(xfrBlockSize))
+	}
+
+	// multiple block transfers have their block count predefined (CMD23),
+	// or are stopped either with Auto CMD12 or in software
+	multi := !hw.noAC12 && (index == 18 || index == 25)
+	count := multi && hw.SetBlockCount && hw.cmd23Supported()
+	stop := multi && !count && hw.ManualCMD12
+
+	if count || stop {
+		hw.noAC12 = true
+		defer func() { hw.noAC12 = false }()
+	}
+
+	if count {
+		if err = hw.setBlockCount(dtd, blocks); err != nil {
+			return fmt.Errorf("len:%d arg:%#x, %w", size, arg, err)
+		}
 	}
 
-	err = hw.cmd(index, dtd, uint32(offset), RSP_48, true, true, true, timeout)
+	err = hw.cmd(index, arg, CommandOpts{Write: dtd == WRITE, Response: RSP_48, CheckIndex: true, CheckCRC: true, Data: true, Timeout: timeout})
 	adma_err := reg.Read(hw.adma_err_status)
 
+	if err == nil && stop {
+		// CMD12 - STOP_TRANSMISSION - stop multiple block transfer
+		err = hw.cmd(12, 0, CommandOpts{Response: RSP_48_CHECK_BUSY, CheckIndex: true, CheckCRC: true, Timeout: timeout})
+	}
+
 	if err != nil {
-		return fmt.Errorf("len:%d offset:%#x timeout:%v ADMA:%#x, %v", len(buf), offset, timeout, adma_err, err)
+		// re-lock delay line, if lost, for later transfers
+		hw.recoverDLL()
+
+		if status, e := hw.cardStatus(); e == nil {
+			err = fmt.Errorf("%w, %v", err, status)
+
+			if status.OutOfRange {
+				err = fmt.Errorf("%w (%v)", ErrAddressOutOfRange, err)
+			}
+		}
+
+		// recover the data line, once its state has been reported, for
+		// subsequent transfers
+		hw.resetDataLine()
+
+		return fmt.Errorf("len:%d arg:%#x timeout:%v ADMA:%#x, %w", size, arg, timeout, adma_err, err)
+	}
+
+	// the Auto CMD12 response (CMD_RSP3) is only updated by multiple
+	// block transfers stopped with Auto CMD12
+	ac12 := (index == 18 || index == 25) && reg.Get(hw.mix_ctrl, MIX_CTRL_AC12EN, 1) == 1
+
+	if outOfRange(hw.rsp(0), hw.rsp(3), ac12) {
+		return fmt.Errorf("len:%d arg:%#x, %w", size, arg, ErrAddressOutOfRange)
 	}
 
 	if adma_err > 0 {
-		return fmt.Errorf("len:%d offset:%#x timeout:%v ADMA:%#x", len(buf), offset, timeout, adma_err)
+		return fmt.Errorf("len:%d arg:%#x timeout:%v ADMA:%#x", size, arg, timeout, adma_err)
 	}
 
-	if dtd == READ {
-		dma.Read(bufAddress, 0, buf)
+	if dtd != READ {
+		return
+	}
+
+	if pio && len(bufs) > 1 {
+		// scatter the gathered buffer
+		for off, i := 0, 0; i < len(bufs); i++ {
+			off += copy(bufs[i], hw.pioBuf[off:])
+		}
+	}
+
+	for i, addr := range bufAddresses {
+		dma.Read(addr, 0, bufs[i])
 	}
 
 	return
 }
 
-// ReadBlocks transfers full blocks of data from the card.
-func (hw *USDHC) ReadBlocks(lba int, blocks int, buf []byte) (err error) {
-	blockSize := hw.card.BlockSize
-	bufSize := blocks * blockSize
-	offset := uint64(lba) * uint64(blockSize)
+// outOfRange returns whether the card reports an ADDRESS_OUT_OF_RANGE error
+// in the argument command (or CMD12) response or, when ac12 is true, in the
+// Auto CMD12 response.
+func outOfRange(rsp uint32, ac12Rsp uint32, ac12 bool) bool {
+	if bits.Get(&rsp, STATUS_OUT_OF_RANGE, 1) == 1 {
+		return true
+	}
 
-	if bufSize == 0 {
-		return
+	return ac12 && bits.Get(&ac12Rsp, STATUS_OUT_OF_RANGE, 1) == 1
+}
+
+// checkRange verifies that a block range lies within the selected physical
+// partition capacity, partitions of unknown size are left to card
+// validation.
+func (hw *USDHC) checkRange(lba int, blocks int) error {
+	size := hw.partitionSize()
+
+	if size == 0 && hw.partition != "user" {
+		return nil
 	}
 
-	if len(buf) < bufSize {
-		return errors.New("invalid buffer size")
+	if lba < 0 || blocks < 0 || (int64(lba)+int64(blocks))*int64(hw.card.BlockSize) > size {
+		return ErrAddressOutOfRange
 	}
 
+	return nil
+}
+
+// ReadBlocks transfers full blocks of data from the card, returning them in
+// a buffer of blocks * BlockSize bytes.
+//
+// Blocks beyond the card capacity are rejected with ErrAddressOutOfRange
+// while transfers not completing in time, which might be retried, return an
+// error wrapping ErrTimeout.
+func (hw *USDHC) ReadBlocks(lba int, blocks int) (buf []byte, err error) {
 	hw.Lock()
 	defer hw.Unlock()
 
-	// CMD18 - READ_MULTIPLE_BLOCK - read consecutive blocks
-	err = hw.transfer(18, READ, offset, uint32(blocks), uint32(blockSize), buf)
+	return hw.readBlocks(lba, blocks)
+}
+
+func (hw *USDHC) readBlocks(lba int, blocks int) (buf []byte, err error) {
+	blockSize := hw.card.BlockSize
+	offset := uint64(lba) * uint64(blockSize)
+
+	if blockSize == 0 {
+		return nil, errors.New("no card detected")
+	}
+
+	if blocks <= 0 {
+		return nil, errors.New("invalid block count")
+	}
+
+	if err = hw.checkRange(lba, blocks); err != nil {
+		return nil, err
+	}
+
+	buf = make([]byte, blocks*blockSize)
+
+	if blocks == 1 {
+		// the transfer completes on the single block count
+		hw.noAC12 = true
+		defer func() { hw.noAC12 = false }()
+
+		// CMD17 - READ_SINGLE_BLOCK - read a block
+		err = hw.transfer(17, READ, offset, 1, uint32(blockSize), buf)
+	} else {
+		// CMD18 - READ_MULTIPLE_BLOCK - read consecutive blocks, stopped
+		// with Auto CMD12
+		err = hw.transfer(18, READ, offset, uint32(blocks), uint32(blockSize), buf)
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
 	return
 }
@@ -537,16 +1559,44 @@ func (hw *USDHC) Read(offset int, size int) (buf []byte, err error) {
 	return
 }
 
-// WriteBlocks transfers full blocks of data to the card.
+// WriteBlocks transfers full blocks of data to the card, a buffer size which
+// is not a multiple of the block size is rejected. Data CRC errors reported
+// by the card are returned as ErrWriteCRC.
 func (hw *USDHC) WriteBlocks(lba int, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.writeBlocks(lba, buf)
+}
+
+func (hw *USDHC) writeBlocks(lba int, buf []byte) (err error) {
 	blockSize := hw.card.BlockSize
 	offset := uint64(lba) * uint64(blockSize)
 
-	return hw.Write(offset, buf)
+	if blockSize == 0 {
+		return errors.New("no card detected")
+	}
+
+	if len(buf)%blockSize != 0 {
+		return fmt.Errorf("write size must be %d bytes aligned", blockSize)
+	}
+
+	if err = hw.checkRange(lba, len(buf)/blockSize); err != nil {
+		return
+	}
+
+	return hw.write(offset, buf)
 }
 
 // Write transfers data to the card.
 func (hw *USDHC) Write(offset uint64, buf []byte) (err error) {
+	hw.Lock()
+	defer hw.Unlock()
+
+	return hw.write(offset, buf)
+}
+
+func (hw *USDHC) write(offset uint64, buf []byte) (err error) {
 	blockSize := uint32(hw.card.BlockSize)
 	size := len(buf)
 
@@ -566,9 +1616,44 @@ func (hw *USDHC) Write(offset uint64, buf []byte) (err error) {
 
 	blocks := uint32(size) / blockSize
 
+	if blocks == 1 {
+		// the transfer completes on the single block count
+		hw.noAC12 = true
+		defer func() { hw.noAC12 = false }()
+
+		// CMD24 - WRITE_BLOCK - write a block
+		err = hw.transfer(24, WRITE, offset, blocks, blockSize, buf)
+	} else {
+		// CMD25 - WRITE_MULTIPLE_BLOCK - write consecutive blocks
+		err = hw.transfer(25, WRITE, offset, blocks, blockSize, buf)
+	}
+
+	if err != nil {
+		return
+	}
+
+	// wait for programming completion
+	return hw.waitState(CURRENT_STATE_TRAN, hw.writeTimeout)
+}
+
+// ForceEvent sets, for fault injection purposes, the interrupt status error
+// flags selected in the argument mask (FORCE_EVENT_* bit positions) as if the
+// respective events occurred. It is meant to validate error handling on real
+// hardware.
+//
+// Forcing events only affects status flags and not the actual controller or
+// card state, therefore command and data error events (CTOE to DMAE) are safe
+// to force during commands or transfers, which then fail accordingly. Auto
+// CMD12 error events (AC12NE to CNIBAC12E) trigger an explicit CMD12 retry. The
+// card interrupt event (CINT) is not handled by this driver and should not be
+// forced.
+func (hw *USDHC) ForceEvent(mask uint32) {
 	hw.Lock()
 	defer hw.Unlock()
 
-	// CMD25 - WRITE_MULTIPLE_BLOCK - write consecutive blocks
-	return hw.transfer(25, WRITE, offset, blocks, blockSize, buf)
+	if hw.cg == 0 {
+		return
+	}
+
+	reg.Write(hw.force_event, mask)
 }